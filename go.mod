@@ -7,6 +7,7 @@ require (
 	github.com/naoina/toml v0.1.2-0.20210730182554-e80af6068b28
 	github.com/syndtr/goleveldb v1.0.1-0.20220614013038-64ee5596c38a
 	go.uber.org/ratelimit v0.2.0
+	golang.org/x/crypto v0.8.0
 )
 
 require (
@@ -93,7 +94,6 @@ require (
 	go.uber.org/atomic v1.9.0 // indirect
 	go.uber.org/multierr v1.8.0 // indirect
 	go.uber.org/zap v1.21.0 // indirect
-	golang.org/x/crypto v0.8.0 // indirect
 	golang.org/x/exp v0.0.0-20230206171751-46f607a40771 // indirect
 	golang.org/x/mod v0.8.0 // indirect
 	golang.org/x/net v0.9.0 // indirect
@@ -24,93 +24,158 @@ func main() {
 		os.Exit(1)
 	}
 	cFile := os.Args[1]
-	f, err := os.Open(cFile)
+	config, err := loadConfig(cFile)
 	if err != nil {
 		log.Error("Error", "error", err)
 		os.Exit(1)
 	}
-	defer f.Close()
-
-	var config nodes.Config
-	if err := toml.NewDecoder(f).Decode(&config); err != nil {
+	nodes.EnableMetrics(config)
+	if err := nodes.ConfigureVulnFeeds(config); err != nil {
 		log.Error("Error", "error", err)
 		os.Exit(1)
 	}
-	nodes.EnableMetrics(&config)
 
-	mon, err := spinupMonitor(config)
+	chainConfs, err := config.ChainConfigs()
 	if err != nil {
 		log.Error("Error", "error", err)
 		os.Exit(1)
 	}
+	var chains []*chainEntry
+	for _, chainConf := range chainConfs {
+		chain, err := spinupMonitor(config, chainConf)
+		if err != nil {
+			log.Error("Error", "chain", chainConf.Name, "error", err)
+			os.Exit(1)
+		}
+		if err := chain.mon.EnableAlerts(config.Alerts); err != nil {
+			log.Error("Error", "error", err)
+			os.Exit(1)
+		}
+		chains = append(chains, chain)
+	}
 
-	spinupServer(config)
+	spinupServer(*config, chains)
 
-	mon.Start()
+	for _, chain := range chains {
+		chain.mon.Start()
+	}
 	// Wait for ctrl-c
 	quitCh := make(chan os.Signal, 1)
 	signal.Notify(quitCh, os.Interrupt)
 
-	// TODO: Monitor changes to the config file
+	// Monitor changes to the config file
+	w := newConfigWatcher(cFile, config, chains)
+	w.Start()
 
 	<-quitCh
-	mon.Stop()
+	w.Stop()
+	for _, chain := range chains {
+		chain.mon.Stop()
+	}
 	os.Exit(0)
 }
 
-func spinupMonitor(config nodes.Config) (*nodes.NodeMonitor, error) {
-	db, err := nodes.NewBlockDB()
+func loadConfig(path string) (*nodes.Config, error) {
+	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
-	reload, err := time.ParseDuration(config.ReloadInterval)
+	defer f.Close()
+
+	var config nodes.Config
+	if err := toml.NewDecoder(f).Decode(&config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// nodeFactory instantiates the node for a configured client.
+type nodeFactory func(c nodes.ClientInfo, config *nodes.Config) (nodes.Node, error)
+
+// chainEntry is a monitored chain, along with its configured clients.
+type chainEntry struct {
+	conf    nodes.ChainConfig
+	mon     *nodes.NodeMonitor
+	clients []*clientEntry
+	factory nodeFactory
+}
+
+func spinupMonitor(config *nodes.Config, chainConf nodes.ChainConfig) (*chainEntry, error) {
+	db, err := nodes.NewBlockDB(config.Storage, chainConf.Datadir)
 	if err != nil {
 		return nil, err
 	}
-	var clients []nodes.Node
-	for _, c := range config.Clients {
-		var (
-			node nodes.Node
-			err  error
-		)
+	reload, err := time.ParseDuration(chainConf.ReloadInterval)
+	if err != nil {
+		return nil, err
+	}
+	blockTime, stallThreshold, err := chainConf.StallSettings()
+	if err != nil {
+		return nil, err
+	}
+	chain := chainConf.ID()
+	factory := func(c nodes.ClientInfo, config *nodes.Config) (nodes.Node, error) {
+		timeout, err := c.RequestTimeout()
+		if err != nil {
+			return nil, err
+		}
 		switch c.Kind {
 		case "infura":
-			node, err = nodes.NewInfuraNode(c.Name, config.InfuraKey, config.InfuraEndpoint,
-				db, c.Ratelimit)
+			return nodes.NewInfuraNode(c.Name, chain, config.InfuraKey, config.InfuraEndpoint,
+				db, c.Ratelimit, timeout)
 		case "alchemy":
-			node, err = nodes.NewAlchemyNode(c.Name, config.AlchemyKey, config.AlchemyEndpoint,
-				db, c.Ratelimit)
+			return nodes.NewAlchemyNode(c.Name, chain, config.AlchemyKey, config.AlchemyEndpoint,
+				db, c.Ratelimit, timeout)
 		case "rpc":
-			node, err = nodes.NewRPCNode(c.Name, c.Url, c.AuthHeaders, db, c.Ratelimit)
+			return nodes.NewRPCNode(c.Name, chain, c.Url, c.AuthHeaders, db, c.Ratelimit, timeout)
+		case "beacon":
+			return nodes.NewBeaconNode(c.Name, chain, c.Url, c.AuthHeaders, c.Ratelimit, timeout)
 		case "etherscan":
-			node, err = nodes.NewEtherscanNode(c.Name, config.EtherscanKey, config.EtherscanEndpoint,
-				db, c.Ratelimit)
+			return nodes.NewEtherscanNode(c.Name, chain, config.EtherscanKey, config.EtherscanEndpoint,
+				db, c.Ratelimit, timeout)
+		case "simulated":
+			return nodes.NewSimulatedNode(c.Name, c.Scenario)
 		case "testnode-canon":
-			node = nodes.NewLiveTestNode("canon", 13_000_000, []uint64{0}, []int{0})
+			return nodes.NewLiveTestNode("canon", 13_000_000, []uint64{0}, []int{0}), nil
 		case "testnode-fork-old":
-			node = nodes.NewLiveTestNode("old", 12_800_000, []uint64{0, 12_799_998}, []int{0, 2})
+			return nodes.NewLiveTestNode("old", 12_800_000, []uint64{0, 12_799_998}, []int{0, 2}), nil
 		case "testnode-fork-recent":
-			node = nodes.NewLiveTestNode("legacy", 12_999_900, []uint64{0, 12_999_800}, []int{0, 1})
+			return nodes.NewLiveTestNode("legacy", 12_999_900, []uint64{0, 12_999_800}, []int{0, 1}), nil
 		default:
-			log.Error("Wrong client type", "kind", c.Kind, "available", "[rpc, infura, alchemy]")
+			log.Error("Wrong client type", "kind", c.Kind, "available", "[rpc, beacon, infura, alchemy, etherscan, simulated]")
 			return nil, errors.New("invalid config")
 		}
-		if err != nil {
-			return nil, err
-		}
-		clients = append(clients, node)
-		log.Info("Client configured", "name", c.Name)
 	}
-	return nodes.NewMonitor(clients, db, reload, config.ChainName)
+	clients, err := reconcileClients(nil, chainConf.Clients, config, factory)
+	if err != nil {
+		return nil, err
+	}
+	mon, err := nodes.NewMonitor(clientNodes(clients), db, reload, chainConf.Name, chain)
+	if err != nil {
+		return nil, err
+	}
+	mon.SetLagThreshold(chainConf.LagThreshold)
+	mon.SetStallThreshold(blockTime, stallThreshold)
+	mon.SetVotes(chainConf.Votes())
+	return &chainEntry{conf: chainConf, mon: mon, clients: clients, factory: factory}, nil
 }
 
-func spinupServer(config nodes.Config) error {
+func spinupServer(config nodes.Config, chains []*chainEntry) error {
 	if len(config.ServerAddress) == 0 {
 		return nil
 	}
+	var mons []*nodes.NodeMonitor
+	for _, chain := range chains {
+		mons = append(mons, chain.mon)
+	}
 	fs := http.FileServer(http.Dir("www/"))
-	http.Handle("/", http.StripPrefix("/", fs))
+	http.Handle("/", nodes.ChainsHandler(http.StripPrefix("/", fs), mons))
+	if path := nodes.PrometheusPath(&config); len(path) > 0 {
+		log.Info("Serving Prometheus metrics", "path", path)
+		http.Handle(path, nodes.PrometheusHandler())
+	}
 	log.Info("Starting web server", "address", config.ServerAddress)
-	go http.ListenAndServe(config.ServerAddress, nil)
+	srv := &http.Server{Addr: config.ServerAddress, ConnContext: nodes.ConnContext}
+	go srv.ListenAndServe()
 	return nil
 }
@@ -0,0 +1,356 @@
+package nodes
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// Alert kinds
+const (
+	AlertUnreachable = "unreachable" // a node does not respond
+	AlertStalled     = "stalled"     // a node has not progressed for a while
+	AlertLagging     = "lagging"     // a node is behind the head of the nodes
+	AlertSplit       = "split"       // two nodes are on different chains
+	AlertBadBlock    = "badblock"    // a node reported a bad block
+	AlertVulnerable  = "vulnerable"  // a node runs a version with a known vulnerability
+	AlertFinalized   = "finalized"   // a node finalized a block the majority disagrees with
+	AlertWrongChain  = "wrongchain"  // a node is not on the expected chain
+)
+
+// Alert severities
+const (
+	AlertWarning  = "warning"
+	AlertCritical = "critical"
+)
+
+// Alert states
+const (
+	AlertFiring   = "firing"
+	AlertResolved = "resolved"
+)
+
+// Alert is a condition detected by the monitor. It fires when first detected,
+// and is resolved once the condition no longer holds.
+type Alert struct {
+	Key      string     `json:"key"` // identifies the condition, for deduplication
+	Kind     string     `json:"kind"`
+	Severity string     `json:"severity"`
+	Status   string     `json:"status"`
+	Chain    string     `json:"chain"`
+	Nodes    []string   `json:"nodes,omitempty"`
+	Message  string     `json:"message"`
+	Since    time.Time  `json:"since"`
+	Resolved *time.Time `json:"resolved,omitempty"`
+}
+
+// alerter turns the outcome of each round of checks into alerts, and delivers
+// the changes to the subscribers and webhooks.
+type alerter struct {
+	stallTimeout time.Duration
+	splitDepth   int64
+	webhooks     []*webhook
+	active       map[string]*Alert
+	badBlocks    map[common.Hash]bool // the bad blocks alerted on already
+	feed         event.Feed
+}
+
+func newAlerter(conf alertsConfig) (*alerter, error) {
+	a := &alerter{
+		splitDepth: int64(conf.SplitDepth),
+		active:     make(map[string]*Alert),
+		badBlocks:  make(map[common.Hash]bool),
+	}
+	if len(conf.StallTimeout) > 0 {
+		d, err := time.ParseDuration(conf.StallTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid stall_timeout: %w", err)
+		}
+		a.stallTimeout = d
+	}
+	if a.splitDepth < 1 {
+		a.splitDepth = 1
+	}
+	for _, wc := range conf.Webhooks {
+		wh, err := newWebhook(wc)
+		if err != nil {
+			return nil, err
+		}
+		a.webhooks = append(a.webhooks, wh)
+	}
+	return a, nil
+}
+
+// detect returns the alert conditions which currently hold.
+func (a *alerter) detect(r *Report, splits []*chainSplit) []*Alert {
+	var alerts []*Alert
+	cols := r.Cols
+	if r.Beacon != nil {
+		cols = append(cols[:len(cols):len(cols)], r.Beacon.Cols...)
+	}
+	for _, c := range cols {
+		if c.Status == NodeStatusUnreachable {
+			alerts = append(alerts, &Alert{
+				Key:     AlertUnreachable + "/" + c.Name,
+				Kind:    AlertUnreachable,
+				Nodes:   []string{c.Name},
+				Message: fmt.Sprintf("Node %v is unreachable", c.Name),
+			})
+		} else if c.Status == NodeStatusWrongChain {
+			alerts = append(alerts, &Alert{
+				Key:      AlertWrongChain + "/" + c.Name,
+				Kind:     AlertWrongChain,
+				Severity: AlertCritical,
+				Nodes:    []string{c.Name},
+				Message:  fmt.Sprintf("Node %v is on the wrong chain: %v", c.Name, c.Error),
+			})
+		} else if c.LastProgress > 0 {
+			since := time.Since(time.Unix(c.LastProgress, 0))
+			if c.Status == NodeStatusStalled || (a.stallTimeout > 0 && since > a.stallTimeout) {
+				alerts = append(alerts, &Alert{
+					Key:     AlertStalled + "/" + c.Name,
+					Kind:    AlertStalled,
+					Nodes:   []string{c.Name},
+					Message: fmt.Sprintf("Node %v has not progressed for %v", c.Name, since.Round(time.Second)),
+				})
+			}
+		}
+		if c.Status == NodeStatusLagging && c.Lag != nil {
+			alerts = append(alerts, &Alert{
+				Key:     AlertLagging + "/" + c.Name,
+				Kind:    AlertLagging,
+				Nodes:   []string{c.Name},
+				Message: fmt.Sprintf("Node %v is %d blocks behind the head, for %v", c.Name, c.Lag.Blocks, time.Duration(c.Lag.Seconds)*time.Second),
+			})
+		}
+		if c.FinalizedMismatch && c.Finalized != nil {
+			alerts = append(alerts, &Alert{
+				Key:      AlertFinalized + "/" + c.Name,
+				Kind:     AlertFinalized,
+				Severity: AlertCritical,
+				Nodes:    []string{c.Name},
+				Message:  fmt.Sprintf("Node %v finalized block %d [%v], which the majority disagrees with", c.Name, c.Finalized.Number, c.Finalized.Hash.TerminalString()),
+			})
+		}
+		for _, uid := range c.Vulnerabilities {
+			alerts = append(alerts, &Alert{
+				Key:     AlertVulnerable + "/" + c.Name + "/" + uid,
+				Kind:    AlertVulnerable,
+				Nodes:   []string{c.Name},
+				Message: fmt.Sprintf("Node %v (%v) is affected by vulnerability %v", c.Name, c.Version, uid),
+			})
+		}
+	}
+	for _, s := range splits {
+		if s.length < a.splitDepth {
+			continue
+		}
+		alerts = append(alerts, &Alert{
+			Key:     AlertSplit + "/" + s.a + "/" + s.b,
+			Kind:    AlertSplit,
+			Nodes:   []string{s.a, s.b},
+			Message: fmt.Sprintf("Nodes %v and %v split at block %d, %d blocks deep", s.a, s.b, s.num, s.length),
+		})
+	}
+	return alerts
+}
+
+// badBlockAlerts returns the alerts for the bad blocks which were not alerted
+// on before. There is no condition for them to be resolved by, so they fire
+// once, and are not tracked as active.
+func (a *alerter) badBlockAlerts(r *Report) []*Alert {
+	var alerts []*Alert
+	for _, bb := range r.BadBlocks {
+		if a.badBlocks[bb.Hash] {
+			continue
+		}
+		a.badBlocks[bb.Hash] = true
+		alerts = append(alerts, &Alert{
+			Key:     fmt.Sprintf("%v/%#x", AlertBadBlock, bb.Hash),
+			Kind:    AlertBadBlock,
+			Nodes:   bb.Clients,
+			Message: fmt.Sprintf("Bad block %v (number %v) reported by %v", bb.Hash.TerminalString(), bb.Number, strings.Join(bb.Clients, ", ")),
+		})
+	}
+	return alerts
+}
+
+// update evaluates the alert conditions, fires the new alerts and resolves the
+// ones which no longer hold. It returns the active alerts. Bad blocks are
+// alerted on once, as they are first reported.
+func (a *alerter) update(r *Report, splits []*chainSplit) []*Alert {
+	now := time.Now()
+	current := make(map[string]bool)
+	for _, alert := range a.detect(r, splits) {
+		current[alert.Key] = true
+		if prev, ok := a.active[alert.Key]; ok {
+			// Already firing, just keep the details up to date
+			prev.Message = alert.Message
+			prev.Nodes = alert.Nodes
+			continue
+		}
+		alert.Status = AlertFiring
+		if alert.Severity == "" {
+			alert.Severity = AlertWarning
+		}
+		alert.Chain = r.Chain
+		alert.Since = now
+		a.active[alert.Key] = alert
+		log.Warn("Alert firing", "kind", alert.Kind, "severity", alert.Severity, "message", alert.Message)
+		a.notify(alert)
+	}
+	for _, alert := range a.badBlockAlerts(r) {
+		alert.Status = AlertFiring
+		alert.Severity = AlertWarning
+		alert.Chain = r.Chain
+		alert.Since = now
+		log.Warn("Alert firing", "kind", alert.Kind, "severity", alert.Severity, "message", alert.Message)
+		a.notify(alert)
+	}
+	for key, alert := range a.active {
+		if current[key] {
+			continue
+		}
+		delete(a.active, key)
+		alert.Status = AlertResolved
+		alert.Resolved = &now
+		log.Info("Alert resolved", "kind", alert.Kind, "message", alert.Message)
+		a.notify(alert)
+	}
+	list := make([]*Alert, 0, len(a.active))
+	for _, alert := range a.active {
+		cpy := *alert
+		list = append(list, &cpy)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].Since.Equal(list[j].Since) {
+			return list[i].Since.Before(list[j].Since)
+		}
+		return list[i].Key < list[j].Key
+	})
+	return list
+}
+
+func (a *alerter) notify(alert *Alert) {
+	cpy := *alert
+	for _, wh := range a.webhooks {
+		wh.enqueue(&cpy)
+	}
+	a.feed.Send(&cpy)
+}
+
+// start launches the webhook delivery, until quitCh is closed.
+func (a *alerter) start(wg *sync.WaitGroup, quitCh chan struct{}) {
+	for _, wh := range a.webhooks {
+		wg.Add(1)
+		go wh.loop(wg, quitCh)
+	}
+}
+
+// webhookBackoff is the delay before the first retry of a failed delivery,
+// doubling with each attempt.
+var webhookBackoff = time.Second
+
+// webhook delivers alerts as json to a HTTP endpoint.
+type webhook struct {
+	url     string
+	headers http.Header
+	retries int
+	client  *http.Client
+	queue   chan *Alert
+}
+
+func newWebhook(conf webhookConfig) (*webhook, error) {
+	if len(conf.Url) == 0 {
+		return nil, fmt.Errorf("webhook url missing")
+	}
+	headers, err := parseHeaders(conf.Headers)
+	if err != nil {
+		return nil, err
+	}
+	// Zero means the default, a negative count disables the retries
+	retries := conf.Retries
+	if retries == 0 {
+		retries = 3
+	} else if retries < 0 {
+		retries = 0
+	}
+	return &webhook{
+		url:     conf.Url,
+		headers: headers,
+		retries: retries,
+		client:  &http.Client{Timeout: 5 * time.Second},
+		queue:   make(chan *Alert, 100),
+	}, nil
+}
+
+func (wh *webhook) enqueue(alert *Alert) {
+	select {
+	case wh.queue <- alert:
+	default:
+		log.Warn("Webhook queue full, dropping alert", "url", wh.url, "key", alert.Key)
+	}
+}
+
+func (wh *webhook) loop(wg *sync.WaitGroup, quitCh chan struct{}) {
+	defer wg.Done()
+	for {
+		select {
+		case <-quitCh:
+			return
+		case alert := <-wh.queue:
+			backoff := webhookBackoff
+			for attempt := 0; ; attempt++ {
+				err := wh.deliver(alert)
+				if err == nil {
+					break
+				}
+				if attempt >= wh.retries {
+					log.Error("Webhook delivery failed", "url", wh.url, "key", alert.Key, "error", err)
+					break
+				}
+				log.Debug("Webhook delivery failed, retrying", "url", wh.url, "error", err, "backoff", backoff)
+				select {
+				case <-quitCh:
+					return
+				case <-time.After(backoff):
+				}
+				backoff *= 2
+			}
+		}
+	}
+}
+
+func (wh *webhook) deliver(alert *Alert) error {
+	data, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, wh.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	for k, v := range wh.headers {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "nodemonitor")
+	res, err := wh.client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %v", res.Status)
+	}
+	return nil
+}
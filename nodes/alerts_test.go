@@ -0,0 +1,204 @@
+package nodes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+func TestAlertWebhook(t *testing.T) {
+	log.Root().SetHandler(log.LvlFilterHandler(
+		log.LvlCrit, log.StreamHandler(os.Stderr, log.TerminalFormat(false))))
+
+	// Disable the vuln check for tests
+	disableVulnCheck = true
+	webhookBackoff = 10 * time.Millisecond
+
+	var (
+		mu       sync.Mutex
+		requests int
+		received = make(chan *Alert, 100)
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		first := requests == 1
+		mu.Unlock()
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		// Fail the first delivery, it should be retried
+		if first {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var alert Alert
+		if err := json.NewDecoder(r.Body).Decode(&alert); err != nil {
+			t.Error(err)
+		}
+		received <- &alert
+	}))
+	defer srv.Close()
+
+	canon := newTestNode("canon", 13_000_000, []uint64{0}, []int{0})
+	fork := newTestNode("fork", 12_999_900, []uint64{0, 12_999_800}, []int{0, 1})
+	broken := &brokenNode{"broken"}
+	nm, err := NewMonitor([]Node{canon, fork, broken}, nil, time.Second, "Playdoh-net", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nm.doChecks()
+	err = nm.EnableAlerts(alertsConfig{
+		SplitDepth: 50,
+		Webhooks: []webhookConfig{{
+			Url:     srv.URL,
+			Headers: []string{"Authorization: Bearer secret"},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	quitCh := make(chan struct{})
+	nm.alerts.start(&wg, quitCh)
+	defer func() {
+		close(quitCh)
+		wg.Wait()
+	}()
+
+	// waitFor waits until the given alert is delivered
+	waitFor := func(key, status string) *Alert {
+		t.Helper()
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case alert := <-received:
+				if alert.Key == key && alert.Status == status {
+					return alert
+				}
+			case <-timeout:
+				t.Fatalf("alert %v (%v) not delivered", key, status)
+			}
+		}
+	}
+	nm.doChecks()
+	waitFor(AlertUnreachable+"/broken", AlertFiring)
+	split := waitFor(AlertSplit+"/"+canon.Name()+"/"+fork.Name(), AlertFiring)
+	if have, want := split.Chain, "Playdoh-net"; have != want {
+		t.Errorf("wrong chain, want %v, have %v", want, have)
+	}
+	var active int
+	for _, alert := range nm.LastReport().Alerts {
+		if alert.Kind == AlertUnreachable || alert.Kind == AlertSplit {
+			active++
+		}
+	}
+	if active != 2 {
+		t.Fatalf("wrong active alerts in report, want 2, have %d", active)
+	}
+	// A repeated check does not fire the same alerts again
+	nm.doChecks()
+	// Removing the node resolves the alert
+	nm.Reconfigure([]Node{canon, fork}, time.Second, "Playdoh-net")
+	nm.doChecks()
+	alert := waitFor(AlertUnreachable+"/broken", AlertResolved)
+	if alert.Resolved == nil {
+		t.Errorf("missing resolve time")
+	}
+	for _, alert := range nm.LastReport().Alerts {
+		if alert.Kind == AlertUnreachable {
+			t.Errorf("resolved alert still active")
+		}
+	}
+}
+
+func TestBadBlockAlerts(t *testing.T) {
+	log.Root().SetHandler(log.LvlFilterHandler(
+		log.LvlCrit, log.StreamHandler(os.Stderr, log.TerminalFormat(false))))
+
+	a, err := newAlerter(alertsConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := make(chan *Alert, 10)
+	sub := a.feed.Subscribe(ch)
+	defer sub.Unsubscribe()
+
+	r := NewReport(nil, "Playdoh-net")
+	r.BadBlocks = BadBlockList{{Hash: common.HexToHash("0x01"), Clients: []string{"geth"}}}
+	// A bad block fires once, without being tracked as active
+	for i := 0; i < 2; i++ {
+		if active := a.update(r, nil); len(active) != 0 {
+			t.Errorf("bad block alert tracked as active: %v", active)
+		}
+	}
+	// Nor is it resolved, once it is no longer in the report
+	r.BadBlocks = nil
+	a.update(r, nil)
+	close(ch)
+	var alerts []*Alert
+	for alert := range ch {
+		alerts = append(alerts, alert)
+	}
+	if len(alerts) != 1 || alerts[0].Kind != AlertBadBlock || alerts[0].Status != AlertFiring {
+		t.Errorf("wrong bad block alerts: %v", alerts)
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	log.Root().SetHandler(log.LvlFilterHandler(
+		log.LvlCrit, log.StreamHandler(os.Stderr, log.TerminalFormat(false))))
+	webhookBackoff = time.Millisecond
+
+	for i, tt := range []struct {
+		retries int
+		want    []string // the alert keys delivered, in order
+	}{
+		{retries: 0, want: []string{"a", "a", "a", "a", "b"}},
+		{retries: 1, want: []string{"a", "a", "b"}},
+		{retries: -1, want: []string{"a", "b"}},
+	} {
+		keys := make(chan string, 10)
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var alert Alert
+			json.NewDecoder(r.Body).Decode(&alert)
+			keys <- alert.Key
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		wh, err := newWebhook(webhookConfig{Url: srv.URL, Retries: tt.retries})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var wg sync.WaitGroup
+		quitCh := make(chan struct{})
+		wg.Add(1)
+		go wh.loop(&wg, quitCh)
+		wh.enqueue(&Alert{Key: "a"})
+		wh.enqueue(&Alert{Key: "b"})
+
+		var have []string
+		for len(have) < len(tt.want) {
+			select {
+			case key := <-keys:
+				have = append(have, key)
+			case <-time.After(5 * time.Second):
+				t.Fatalf("test %d: deliveries missing, have %v, want %v", i, have, tt.want)
+			}
+		}
+		close(quitCh)
+		wg.Wait()
+		srv.Close()
+		if fmt.Sprint(have) != fmt.Sprint(tt.want) {
+			t.Errorf("test %d: wrong deliveries, have %v, want %v", i, have, tt.want)
+		}
+	}
+}
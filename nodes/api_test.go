@@ -1,6 +1,7 @@
 package nodes
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"testing"
@@ -11,11 +12,11 @@ import (
 func TestInfura(t *testing.T) {
 	key := os.Getenv("INFURA_KEY")
 	fmt.Printf("key: %v\n", key)
-	node, err := NewInfuraNode("Infura", key, "https://mainnet.infura.io/v3/", nil, 1)
+	node, err := NewInfuraNode("Infura", "", key, "https://mainnet.infura.io/v3/", nil, 1, 0)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if err := node.UpdateLatest(); err != nil {
+	if err := node.UpdateLatest(context.Background()); err != nil {
 		t.Fatal(err)
 	}
 	if node.HeadNum() == 0 {
@@ -27,11 +28,11 @@ func TestInfura(t *testing.T) {
 func TestAlchemy(t *testing.T) {
 	key := os.Getenv("ALCHEMY_KEY")
 	fmt.Printf("key: %v\n", key)
-	node, err := NewAlchemyNode("Alchemy", key, "https://eth-mainnet.alchemyapi.io/v2/", nil, 1)
+	node, err := NewAlchemyNode("Alchemy", "", key, "https://eth-mainnet.alchemyapi.io/v2/", nil, 1, 0)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if err := node.UpdateLatest(); err != nil {
+	if err := node.UpdateLatest(context.Background()); err != nil {
 		t.Fatal(err)
 	}
 	if node.HeadNum() == 0 {
@@ -43,11 +44,11 @@ func TestAlchemy(t *testing.T) {
 func TestEtherscan(t *testing.T) {
 	key := os.Getenv("ETHERSCAN_KEY")
 	fmt.Printf("key: %v\n", key)
-	node, err := NewEtherscanNode("Etherscan", key, "https://api.etherscan.io/api", nil, 1)
+	node, err := NewEtherscanNode("Etherscan", "", key, "https://api.etherscan.io/api", nil, 1, 0)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if err := node.UpdateLatest(); err != nil {
+	if err := node.UpdateLatest(context.Background()); err != nil {
 		t.Fatal(err)
 	}
 	if node.HeadNum() == 0 {
@@ -55,7 +56,7 @@ func TestEtherscan(t *testing.T) {
 	}
 	t.Logf("Latest is %v", node.HeadNum())
 
-	got10 := node.HashAt(10, false)
+	got10 := node.HashAt(context.Background(), 10, false)
 	want10 := common.HexToHash("0x4ff4a38b278ab49f7739d3a4ed4e12714386a9fdf72192f2e8f7da7822f10b4d")
 	if got10 != want10 {
 		want16 := common.HexToHash("0x9657beaf8542273d7448f6d277bb61aef0f700a91b238ac8b34c020f7fb8664c")
@@ -0,0 +1,403 @@
+package nodes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/eth"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"go.uber.org/ratelimit"
+)
+
+// maxBeaconReorg is how far back a beacon node walks the chain to find where
+// a new head connects to the known chain.
+const maxBeaconReorg = 64
+
+const slotsPerEpoch = 32
+
+var errBeaconNotFound = errors.New("not found")
+
+type beaconVersionResponse struct {
+	Data struct {
+		Version string `json:"version"`
+	} `json:"data"`
+}
+
+type beaconBlockResponse struct {
+	Data struct {
+		Message struct {
+			Slot       uint64      `json:"slot,string"`
+			ParentRoot common.Hash `json:"parent_root"`
+		} `json:"message"`
+	} `json:"data"`
+}
+
+type beaconHeaderResponse struct {
+	Data struct {
+		Root   common.Hash `json:"root"`
+		Header struct {
+			Message struct {
+				Slot       uint64      `json:"slot,string"`
+				ParentRoot common.Hash `json:"parent_root"`
+			} `json:"message"`
+		} `json:"header"`
+	} `json:"data"`
+}
+
+type beaconSpecResponse struct {
+	Data struct {
+		SecondsPerSlot uint64 `json:"SECONDS_PER_SLOT,string"`
+	} `json:"data"`
+}
+
+type beaconCheckpoint struct {
+	Epoch uint64      `json:"epoch,string"`
+	Root  common.Hash `json:"root"`
+}
+
+type beaconFinalityResponse struct {
+	Data struct {
+		Finalized        beaconCheckpoint `json:"finalized"`
+		CurrentJustified beaconCheckpoint `json:"current_justified"`
+	} `json:"data"`
+}
+
+// BeaconNode represents a consensus-layer node, queried via the standard
+// beacon node API. Numbers are slots, and hashes are block roots. Empty slots
+// are represented by blocks with a zero root.
+type BeaconNode struct {
+	url     string
+	headers http.Header
+	client  *http.Client
+	// Some local cached values
+	version      string
+	name         string
+	latest       *blockInfo
+	finalized    beaconCheckpoint
+	justified    beaconCheckpoint
+	syncing      bool
+	peers        int // -1 if unknown
+	chainHistory map[uint64]*blockInfo
+	status       int
+	mu           sync.RWMutex
+	lastProgress int64         // Last unix-time the node progressed the chain
+	slotTime     time.Duration // from the chain spec, 0 until known
+
+	headGauge         metrics.Gauge
+	finalizedLagGauge metrics.Gauge
+	syncingGauge      metrics.Gauge
+	peersGauge        metrics.Gauge
+	// rate limiting
+	throttle  ratelimit.Limiter
+	lastCheck map[string]time.Time
+}
+
+func NewBeaconNode(name, chain, url string, authHeaders []string, rateLimit int, timeout time.Duration) (*BeaconNode, error) {
+	if len(url) == 0 {
+		return nil, errors.New("Missing url")
+	}
+	headers, err := parseHeaders(authHeaders)
+	if err != nil {
+		return nil, err
+	}
+	throttle := ratelimit.NewUnlimited()
+	if rateLimit > 0 {
+		throttle = ratelimit.New(rateLimit)
+	}
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	return &BeaconNode{
+		url:               strings.TrimSuffix(url, "/"),
+		headers:           headers,
+		client:            &http.Client{Timeout: timeout},
+		name:              name,
+		version:           "n/a",
+		chainHistory:      make(map[uint64]*blockInfo),
+		headGauge:         nodeGauge("beacon/head", chain, name),
+		finalizedLagGauge: nodeGauge("beacon/finalized/lag", chain, name),
+		syncingGauge:      nodeGauge("beacon/syncing", chain, name),
+		peersGauge:        nodeGauge("beacon/peers", chain, name),
+		peers:             -1,
+		throttle:          throttle,
+		lastCheck:         make(map[string]time.Time),
+	}, nil
+}
+
+// get performs a throttled request against the beacon API, and decodes the
+// json response into v.
+func (node *BeaconNode) get(ctx context.Context, path string, v interface{}) error {
+	node.throttle.Take()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, node.url+path, nil)
+	if err != nil {
+		return err
+	}
+	for k, v := range node.headers {
+		req.Header[k] = v
+	}
+	req.Header.Set("Accept", "application/json")
+	res, err := node.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return errBeaconNotFound
+	}
+	if res.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 256))
+		return fmt.Errorf("%v: %v %s", path, res.Status, body)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+func (node *BeaconNode) SetStatus(status int) {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	node.status = status
+}
+
+func (node *BeaconNode) Status() int {
+	node.mu.RLock()
+	defer node.mu.RUnlock()
+	return node.status
+}
+
+func (node *BeaconNode) Version(ctx context.Context) (string, error) {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	// Don't request version more than once every 30 seconds
+	if time.Since(node.lastCheck["version"]) < time.Second*30 {
+		return node.version, nil
+	}
+	node.lastCheck["version"] = time.Now()
+
+	var res beaconVersionResponse
+	if err := node.get(ctx, "/eth/v1/node/version", &res); err != nil {
+		return "", err
+	}
+	node.version = res.Data.Version
+	return node.version, nil
+}
+
+func (node *BeaconNode) Name() string {
+	node.mu.RLock()
+	defer node.mu.RUnlock()
+	return node.name
+}
+
+func (node *BeaconNode) HeadNum() uint64 {
+	node.mu.RLock()
+	defer node.mu.RUnlock()
+	if node.latest != nil {
+		return node.latest.num
+	}
+	return 0
+}
+
+func (node *BeaconNode) LastProgress() int64 {
+	node.mu.RLock()
+	defer node.mu.RUnlock()
+	return node.lastProgress
+}
+
+// Finalized returns the finalized checkpoint, as the first slot of the epoch
+// along with the checkpoint root.
+func (node *BeaconNode) Finalized() *blockInfo {
+	node.mu.RLock()
+	defer node.mu.RUnlock()
+	return checkpointBlock(node.finalized)
+}
+
+// Safe returns the current justified checkpoint.
+func (node *BeaconNode) Safe() *blockInfo {
+	node.mu.RLock()
+	defer node.mu.RUnlock()
+	return checkpointBlock(node.justified)
+}
+
+func checkpointBlock(cp beaconCheckpoint) *blockInfo {
+	if cp.Root == (common.Hash{}) {
+		return nil
+	}
+	return &blockInfo{num: cp.Epoch * slotsPerEpoch, hash: cp.Root}
+}
+
+func (node *BeaconNode) UpdateLatest(ctx context.Context) error {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+
+	var head beaconBlockResponse
+	if err := node.get(ctx, "/eth/v2/beacon/blocks/head", &head); err != nil {
+		return err
+	}
+	bl, err := node.fetchHeader(ctx, fmt.Sprintf("%d", head.Data.Message.Slot))
+	if err != nil {
+		return err
+	}
+	if node.latest == nil || node.latest.hash != bl.hash {
+		node.reconnect(ctx, bl)
+		node.lastProgress = time.Now().Unix()
+		node.latest = bl
+		node.headGauge.Update(int64(bl.num))
+	}
+	var finality beaconFinalityResponse
+	if err := node.get(ctx, "/eth/v1/beacon/states/head/finality_checkpoints", &finality); err != nil {
+		log.Debug("Error fetching finality checkpoints", "node", node.name, "error", err)
+	} else {
+		node.finalized = finality.Data.Finalized
+		node.justified = finality.Data.CurrentJustified
+		node.finalizedLagGauge.Update(int64(node.latest.num - node.finalized.Epoch*slotsPerEpoch))
+	}
+	node.checkSync(ctx)
+	node.checkSpec(ctx)
+	return nil
+}
+
+// checkSpec fetches the slot time from the chain spec, until known. The caller
+// must hold the node lock.
+func (node *BeaconNode) checkSpec(ctx context.Context) {
+	if node.slotTime > 0 || time.Since(node.lastCheck["spec"]) < healthCheckInterval {
+		return
+	}
+	node.lastCheck["spec"] = time.Now()
+
+	var spec beaconSpecResponse
+	if err := node.get(ctx, "/eth/v1/config/spec", &spec); err != nil {
+		log.Debug("Error fetching chain spec", "node", node.name, "error", err)
+		return
+	}
+	node.slotTime = time.Duration(spec.Data.SecondsPerSlot) * time.Second
+}
+
+// SlotTime returns the slot time of the chain, or 0 if not known yet.
+func (node *BeaconNode) SlotTime() time.Duration {
+	node.mu.RLock()
+	defer node.mu.RUnlock()
+	return node.slotTime
+}
+
+// reconnect walks back from the given new head, until it connects to the
+// cached chain, fixing up the cache for the slots which were reorged out or
+// turned out to be empty.
+func (node *BeaconNode) reconnect(ctx context.Context, head *blockInfo) {
+	var (
+		current = head
+		reorged = 0
+	)
+	for i := 0; i < maxBeaconReorg && current.num > 0; i++ {
+		// Find the closest cached block below the current one
+		var below *blockInfo
+		for slot := current.num - 1; slot+maxBeaconReorg >= current.num; slot-- {
+			if bl, ok := node.chainHistory[slot]; ok && bl.hash != (common.Hash{}) {
+				below = bl
+				break
+			}
+			if slot == 0 {
+				break
+			}
+		}
+		if below == nil {
+			break // nothing cached to connect to
+		}
+		if below.hash == current.pHash {
+			node.markEmpty(below.num+1, current.num)
+			break
+		}
+		// Either the parent is not cached yet, or the cached chain was reorged
+		stale := make(map[uint64]*blockInfo)
+		for slot := below.num; slot < current.num; slot++ {
+			if bl, ok := node.chainHistory[slot]; ok && bl.hash != (common.Hash{}) {
+				stale[slot] = bl
+			}
+		}
+		parent, err := node.fetchHeader(ctx, fmt.Sprintf("%#x", current.pHash))
+		if err != nil {
+			break
+		}
+		for slot, bl := range stale {
+			if slot >= parent.num && bl.hash != parent.hash {
+				reorged++
+			}
+		}
+		node.markEmpty(parent.num+1, current.num)
+		current = parent
+	}
+	if reorged > 0 {
+		log.Info("Beacon node reorged", "name", node.name, "size", reorged)
+	}
+}
+
+// markEmpty marks the slots in the range [from, to) as empty.
+func (node *BeaconNode) markEmpty(from, to uint64) {
+	for slot := from; slot < to; slot++ {
+		node.chainHistory[slot] = &blockInfo{num: slot}
+	}
+}
+
+// fetchHeader fetches the header with the given block id (slot or root), and
+// stores it in the chain cache. If the slot is empty, a block with zero root
+// is returned.
+func (node *BeaconNode) fetchHeader(ctx context.Context, id string) (*blockInfo, error) {
+	var res beaconHeaderResponse
+	err := node.get(ctx, "/eth/v1/beacon/headers/"+id, &res)
+	if err == errBeaconNotFound {
+		var slot uint64
+		if _, err := fmt.Sscanf(id, "%d", &slot); err != nil || strings.HasPrefix(id, "0x") {
+			return nil, fmt.Errorf("block %v not found on node %v", id, node.name)
+		}
+		bl := &blockInfo{num: slot}
+		node.chainHistory[slot] = bl
+		return bl, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	bl := &blockInfo{
+		num:   res.Data.Header.Message.Slot,
+		hash:  res.Data.Root,
+		pHash: res.Data.Header.Message.ParentRoot,
+	}
+	node.chainHistory[bl.num] = bl
+	return bl, nil
+}
+
+func (node *BeaconNode) BlockAt(ctx context.Context, num uint64, force bool) *blockInfo {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+
+	if node.latest != nil && node.latest.num < num {
+		return nil // that block is future, don't bother
+	}
+	if !force {
+		if bl, ok := node.chainHistory[num]; ok {
+			return bl // have it already, don't refetch it
+		}
+	}
+	bl, _ := node.fetchHeader(ctx, fmt.Sprintf("%d", num))
+	return bl
+}
+
+func (node *BeaconNode) HashAt(ctx context.Context, num uint64, force bool) common.Hash {
+	if bl := node.BlockAt(ctx, num, force); bl != nil {
+		return bl.hash
+	}
+	return common.Hash{}
+}
+
+func (node *BeaconNode) BadBlocks(ctx context.Context) []*eth.BadBlockArgs {
+	return []*eth.BadBlockArgs{}
+}
+
+func (node *BeaconNode) BadBlockCount() int {
+	return 0
+}
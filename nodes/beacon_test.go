@@ -0,0 +1,205 @@
+package nodes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// beaconStub serves a minimal beacon API for a chain of the given length,
+// with empty slots at every 'empty'th slot. From slot 'fork' onwards, the
+// roots differ from those of other stubs.
+type beaconStub struct {
+	head  uint64
+	fork  uint64
+	empty uint64
+}
+
+func (s *beaconStub) root(slot uint64) common.Hash {
+	if s.empty > 0 && slot > 0 && slot%s.empty == 0 {
+		return common.Hash{}
+	}
+	h := common.BigToHash(new(big.Int).SetUint64(slot + 1))
+	if s.fork > 0 && slot >= s.fork {
+		h[0] = 0xff
+	}
+	return h
+}
+
+func (s *beaconStub) parent(slot uint64) common.Hash {
+	for slot > 0 {
+		slot--
+		if h := s.root(slot); h != (common.Hash{}) {
+			return h
+		}
+	}
+	return common.Hash{}
+}
+
+func (s *beaconStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	reply := func(v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": v})
+	}
+	header := func(slot uint64) {
+		root := s.root(slot)
+		if root == (common.Hash{}) {
+			http.NotFound(w, r)
+			return
+		}
+		reply(map[string]interface{}{
+			"root": root,
+			"header": map[string]interface{}{
+				"message": map[string]interface{}{
+					"slot":        strconv.FormatUint(slot, 10),
+					"parent_root": s.parent(slot),
+				},
+			},
+		})
+	}
+	switch path := r.URL.Path; {
+	case path == "/eth/v1/node/version":
+		reply(map[string]string{"version": "Stub/v1.0.0"})
+	case path == "/eth/v1/config/spec":
+		reply(map[string]string{"SECONDS_PER_SLOT": "6", "SLOTS_PER_EPOCH": "32"})
+	case path == "/eth/v2/beacon/blocks/head":
+		reply(map[string]interface{}{
+			"message": map[string]interface{}{
+				"slot":        strconv.FormatUint(s.head, 10),
+				"parent_root": s.parent(s.head),
+			},
+		})
+	case path == "/eth/v1/beacon/states/head/finality_checkpoints":
+		epoch := s.head/32 - 2
+		reply(map[string]interface{}{
+			"finalized":         map[string]interface{}{"epoch": strconv.FormatUint(epoch, 10), "root": s.root(epoch * 32)},
+			"current_justified": map[string]interface{}{"epoch": strconv.FormatUint(epoch+1, 10), "root": s.root((epoch + 1) * 32)},
+		})
+	case strings.HasPrefix(path, "/eth/v1/beacon/headers/"):
+		id := strings.TrimPrefix(path, "/eth/v1/beacon/headers/")
+		if strings.HasPrefix(id, "0x") {
+			want := common.HexToHash(id)
+			for slot := uint64(0); slot <= s.head; slot++ {
+				if s.root(slot) == want {
+					header(slot)
+					return
+				}
+			}
+			http.NotFound(w, r)
+			return
+		}
+		slot, err := strconv.ParseUint(id, 10, 64)
+		if err != nil || slot > s.head {
+			http.NotFound(w, r)
+			return
+		}
+		header(slot)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestBeaconNode(t *testing.T) {
+	log.Root().SetHandler(log.LvlFilterHandler(
+		log.LvlCrit, log.StreamHandler(os.Stderr, log.TerminalFormat(false))))
+
+	stub := &beaconStub{head: 200, empty: 7}
+	srv := httptest.NewServer(stub)
+	defer srv.Close()
+
+	node, err := NewBeaconNode("beacon", "", srv.URL, nil, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := node.Version(context.Background()); err != nil || v != "Stub/v1.0.0" {
+		t.Fatalf("wrong version: %v %v", v, err)
+	}
+	if err := node.UpdateLatest(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := node.HeadNum(), stub.head; have != want {
+		t.Fatalf("wrong head, have %d, want %d", have, want)
+	}
+	if have, want := node.HashAt(context.Background(), 100, false), stub.root(100); have != want {
+		t.Errorf("wrong root at 100, have %x, want %x", have, want)
+	}
+	if have := node.HashAt(context.Background(), 196, false); have != (common.Hash{}) {
+		t.Errorf("empty slot should have zero root, have %x", have)
+	}
+	if have := node.BlockAt(context.Background(), 201, false); have != nil {
+		t.Errorf("future slot should be nil")
+	}
+	if f := node.Finalized(); f == nil || f.num != 128 || f.hash != stub.root(128) {
+		t.Errorf("wrong finalized checkpoint: %v", f)
+	}
+	if have, want := node.SlotTime(), 6*time.Second; have != want {
+		t.Errorf("wrong slot time, have %v, want %v", have, want)
+	}
+	// Advance the chain, the node should connect to the cached blocks and
+	// mark the skipped empty slots
+	stub.head = 209
+	if err := node.UpdateLatest(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if bl, ok := node.chainHistory[203]; !ok || bl.hash != (common.Hash{}) {
+		t.Errorf("slot 203 should be marked empty")
+	}
+}
+
+func TestBeaconMonitor(t *testing.T) {
+	log.Root().SetHandler(log.LvlFilterHandler(
+		log.LvlCrit, log.StreamHandler(os.Stderr, log.TerminalFormat(false))))
+
+	// Disable the vuln check for tests
+	disableVulnCheck = true
+
+	var list []Node
+	for i, stub := range []*beaconStub{{head: 300, empty: 11}, {head: 290, fork: 250, empty: 11}} {
+		srv := httptest.NewServer(stub)
+		defer srv.Close()
+		node, err := NewBeaconNode(fmt.Sprintf("beacon-%d", i), "", srv.URL, nil, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		list = append(list, node)
+	}
+	list = append(list, newTestNode("canon", 13_000_000, []uint64{0}, []int{0}))
+	nm, err := NewMonitor(list, nil, time.Second, "Playdoh-net", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nm.doChecks()
+	r := nm.LastReport()
+	if len(r.Cols) != 1 {
+		t.Fatalf("wrong execution nodes, have %d, want 1", len(r.Cols))
+	}
+	if r.Beacon == nil {
+		t.Fatal("missing beacon report")
+	}
+	if len(r.Beacon.Cols) != 2 {
+		t.Fatalf("wrong beacon nodes, have %d, want 2", len(r.Beacon.Cols))
+	}
+	var found bool
+	for _, num := range r.Beacon.Numbers {
+		if num == 249 || num == 250 {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("split point missing from beacon numbers: %v", r.Beacon.Numbers)
+	}
+	if f := r.Beacon.Cols[0].Finalized; f == nil || f.Number != 7*slotsPerEpoch {
+		t.Errorf("wrong finalized checkpoint: %v", f)
+	}
+}
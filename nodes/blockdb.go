@@ -0,0 +1,163 @@
+package nodes
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/ethdb/leveldb"
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
+	gleveldb "github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/errors"
+)
+
+// Storage engines of the block database.
+const (
+	EngineLevelDB = "leveldb"
+	EnginePebble  = "pebble"
+	EngineMemory  = "memory"
+)
+
+// headerPrefix is the key prefix of the headers, which are keyed by hash.
+var headerPrefix = []byte("header-")
+
+// blockDB stores the headers fetched from the nodes by hash, along with the
+// history of reorgs and splits, and the triage of bad blocks.
+type blockDB struct {
+	db        ethdb.KeyValueStore
+	retention uint64 // how many blocks below the head headers are kept for, 0 to keep all
+}
+
+// NewBlockDB opens the block database in the given directory, which is relative
+// to the configured data directory (if any).
+func NewBlockDB(conf storageConfig, dir string) (*blockDB, error) {
+	if len(conf.Datadir) > 0 && !filepath.IsAbs(dir) {
+		dir = filepath.Join(conf.Datadir, dir)
+	}
+	var (
+		db  ethdb.KeyValueStore
+		err error
+	)
+	switch strings.ToLower(conf.Engine) {
+	case "", EngineLevelDB:
+		db, err = openLevelDB(dir)
+	case EnginePebble:
+		db, err = openPebble(dir)
+	case EngineMemory:
+		db = memorydb.New()
+	default:
+		return nil, fmt.Errorf("unknown storage engine %q", conf.Engine)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &blockDB{db: db, retention: conf.Retention}, nil
+}
+
+// openLevelDB opens the leveldb database in the given directory. A database
+// corrupted by a crash is recovered, rather than failing the startup.
+func openLevelDB(dir string) (ethdb.KeyValueStore, error) {
+	db, err := leveldb.New(dir, 16, 16, "", false)
+	if _, corrupted := err.(*errors.ErrCorrupted); corrupted {
+		log.Warn("Block database corrupted, recovering", "dir", dir, "error", err)
+		var recovered *gleveldb.DB
+		if recovered, err = gleveldb.RecoverFile(dir, nil); err != nil {
+			return nil, err
+		}
+		recovered.Close()
+		db, err = leveldb.New(dir, 16, 16, "", false)
+	}
+	return db, err
+}
+
+// openBlockDB opens the leveldb database in the given directory, keeping all
+// headers.
+func openBlockDB(dir string) (*blockDB, error) {
+	return NewBlockDB(storageConfig{}, dir)
+}
+
+// Close closes the database.
+func (db *blockDB) Close() error {
+	return db.db.Close()
+}
+
+func headerKey(hash common.Hash) []byte {
+	return append(append([]byte{}, headerPrefix...), hash[:]...)
+}
+
+func (db *blockDB) add(key common.Hash, h *types.Header) {
+	k := headerKey(key)
+	if ok, _ := db.db.Has(k); ok {
+		return
+	}
+	data, err := rlp.EncodeToBytes(h)
+	if err != nil {
+		panic(fmt.Sprintf("Failed encoding header: %v", err))
+	}
+	db.db.Put(k, data)
+}
+
+func (db *blockDB) get(key common.Hash) *types.Header {
+	data, err := db.db.Get(headerKey(key))
+	if err != nil {
+		return nil
+	}
+	var h types.Header
+	if err = rlp.DecodeBytes(data, &h); err != nil {
+		panic(fmt.Sprintf("Failed decoding our own data: %v", err))
+	}
+	return &h
+}
+
+// prune deletes the headers more than the retention below the given head,
+// except for the ones with the given hashes or numbers. It returns the number
+// of headers deleted, and gives up once quitCh is closed.
+func (db *blockDB) prune(head uint64, keepHashes map[common.Hash]bool, keepNumbers map[uint64]bool, quitCh chan struct{}) int {
+	if db.retention == 0 || head <= db.retention {
+		return 0
+	}
+	limit := head - db.retention
+	var stale [][]byte
+	it := db.db.NewIterator(headerPrefix, nil)
+	for it.Next() {
+		select {
+		case <-quitCh:
+			it.Release()
+			return 0
+		default:
+		}
+		if keepHashes[common.BytesToHash(it.Key()[len(headerPrefix):])] {
+			continue
+		}
+		var h types.Header
+		if err := rlp.DecodeBytes(it.Value(), &h); err != nil {
+			continue
+		}
+		if num := h.Number.Uint64(); num < limit && !keepNumbers[num] {
+			stale = append(stale, common.CopyBytes(it.Key()))
+		}
+	}
+	it.Release()
+
+	batch := db.db.NewBatch()
+	for _, key := range stale {
+		batch.Delete(key)
+		if batch.ValueSize() >= ethdb.IdealBatchSize {
+			if err := batch.Write(); err != nil {
+				log.Error("Failed to prune headers", "error", err)
+				return 0
+			}
+			batch.Reset()
+		}
+	}
+	if err := batch.Write(); err != nil {
+		log.Error("Failed to prune headers", "error", err)
+		return 0
+	}
+	return len(stale)
+}
@@ -0,0 +1,14 @@
+//go:build !((arm64 || amd64) && !openbsd)
+
+package nodes
+
+import (
+	"errors"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+)
+
+// Pebble is only supported on 64-bit platforms.
+func openPebble(dir string) (ethdb.KeyValueStore, error) {
+	return nil, errors.New("pebble is not supported on this platform")
+}
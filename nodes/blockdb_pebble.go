@@ -0,0 +1,12 @@
+//go:build (arm64 || amd64) && !openbsd
+
+package nodes
+
+import (
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/ethdb/pebble"
+)
+
+func openPebble(dir string) (ethdb.KeyValueStore, error) {
+	return pebble.New(dir, 16, 16, "", false)
+}
@@ -0,0 +1,162 @@
+package nodes
+
+import (
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+	gleveldb "github.com/syndtr/goleveldb/leveldb"
+)
+
+func testHeader(num uint64, fork byte) *types.Header {
+	return &types.Header{
+		Number:     new(big.Int).SetUint64(num),
+		Difficulty: common.Big0,
+		Extra:      []byte{fork},
+	}
+}
+
+func TestBlockDBEngines(t *testing.T) {
+	log.Root().SetHandler(log.LvlFilterHandler(
+		log.LvlCrit, log.StreamHandler(os.Stderr, log.TerminalFormat(false))))
+
+	for _, engine := range []string{EngineLevelDB, EnginePebble, EngineMemory} {
+		t.Run(engine, func(t *testing.T) {
+			conf := storageConfig{Engine: engine, Datadir: t.TempDir()}
+			db, err := NewBlockDB(conf, "blockDB")
+			if err != nil {
+				t.Fatal(err)
+			}
+			h := testHeader(100, 0)
+			db.add(h.Hash(), h)
+			if have := db.get(h.Hash()); have == nil || have.Hash() != h.Hash() {
+				t.Fatalf("header not stored")
+			}
+			hist := newHistory(db)
+			for i := 1; i <= 3; i++ {
+				hist.put(&HistoryEvent{Kind: EventReorg, Number: uint64(i), Time: time.Unix(int64(i), 0)})
+			}
+			var numbers []uint64
+			hist.iterate(uint64(time.Unix(3, 0).UnixNano()), func(ev *HistoryEvent) bool {
+				numbers = append(numbers, ev.Number)
+				return true
+			}, -1)
+			if len(numbers) != 2 || numbers[0] != 2 || numbers[1] != 1 {
+				t.Errorf("wrong events before the last, have %v, want [2 1]", numbers)
+			}
+			if err := db.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if engine == EngineMemory {
+				return
+			}
+			// The data is kept across restarts, in the configured data directory
+			if _, err := os.Stat(filepath.Join(conf.Datadir, "blockDB")); err != nil {
+				t.Fatal(err)
+			}
+			db, err = NewBlockDB(conf, "blockDB")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			if db.get(h.Hash()) == nil {
+				t.Errorf("header lost on reopen")
+			}
+		})
+	}
+	if _, err := NewBlockDB(storageConfig{Engine: "boltdb"}, t.TempDir()); err == nil {
+		t.Errorf("unknown engine accepted")
+	}
+}
+
+func TestBlockDBPrune(t *testing.T) {
+	db, err := NewBlockDB(storageConfig{Engine: EngineMemory, Retention: 100}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var headers []*types.Header
+	for num := uint64(0); num < 300; num++ {
+		h := testHeader(num, 0)
+		db.add(h.Hash(), h)
+		headers = append(headers, h)
+	}
+	side := testHeader(50, 1) // the other side of a split
+	db.add(side.Hash(), side)
+	db.putHistory(&HistoryEvent{Id: 1, Kind: EventSplit, Number: 50})
+	db.putTriage(triageKey("triage", headers[0].Hash()), []byte("{}"))
+
+	// Pruning gives up when the monitor stops
+	quitCh := make(chan struct{})
+	close(quitCh)
+	if pruned := db.prune(299, nil, nil, quitCh); pruned != 0 {
+		t.Errorf("pruned %d headers after quitting", pruned)
+	}
+
+	keepHashes := map[common.Hash]bool{headers[10].Hash(): true}
+	keepNumbers := map[uint64]bool{50: true}
+	if have, want := db.prune(299, keepHashes, keepNumbers, nil), 199-2; have != want {
+		t.Errorf("wrong number of pruned headers, have %d, want %d", have, want)
+	}
+	for num, h := range headers {
+		kept := num >= 199 || num == 10 || num == 50
+		if have := db.get(h.Hash()) != nil; have != kept {
+			t.Errorf("header %d: kept %v, want %v", num, have, kept)
+		}
+	}
+	if db.get(side.Hash()) == nil {
+		t.Errorf("split header pruned")
+	}
+	var events int
+	db.iterateHistory(0, func(*HistoryEvent) bool { events++; return true }, -1)
+	if events != 1 {
+		t.Errorf("history pruned")
+	}
+	if db.getTriage(triageKey("triage", headers[0].Hash())) == nil {
+		t.Errorf("triage pruned")
+	}
+	// Without retention, nothing is pruned
+	db.retention = 0
+	if pruned := db.prune(1000, nil, nil, nil); pruned != 0 {
+		t.Errorf("pruned %d headers without retention", pruned)
+	}
+}
+
+func TestBlockDBRecover(t *testing.T) {
+	log.Root().SetHandler(log.LvlFilterHandler(
+		log.LvlCrit, log.StreamHandler(os.Stderr, log.TerminalFormat(false))))
+
+	dir := t.TempDir()
+	db, err := openBlockDB(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := testHeader(100, 0)
+	db.add(h.Hash(), h)
+	db.Close()
+
+	// Corrupt the manifest, as a crash might
+	manifests, _ := filepath.Glob(filepath.Join(dir, "MANIFEST-*"))
+	if len(manifests) == 0 {
+		t.Fatal("no manifest")
+	}
+	for _, m := range manifests {
+		if err := os.WriteFile(m, []byte("garbage"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := gleveldb.OpenFile(dir, nil); err == nil {
+		t.Fatal("database not corrupted")
+	}
+	if db, err = openBlockDB(dir); err != nil {
+		t.Fatalf("corrupted database not recovered: %v", err)
+	}
+	defer db.Close()
+	if db.get(h.Hash()) == nil {
+		t.Errorf("header lost in recovery")
+	}
+}
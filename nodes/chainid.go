@@ -0,0 +1,174 @@
+package nodes
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// chainCheckInterval is how often the chain id and genesis of a node are
+// re-verified.
+const chainCheckInterval = 10 * time.Minute
+
+// chainIdentity is the chain a node is on.
+type chainIdentity struct {
+	chainId *big.Int // nil if the node can't tell
+	genesis common.Hash
+}
+
+// knownChains are the identities of the well-known chains, by (lowercase) name.
+var knownChains = map[string]*chainIdentity{
+	"mainnet": {big.NewInt(1), params.MainnetGenesisHash},
+	"ropsten": {big.NewInt(3), common.HexToHash("0x41941023680923e0fe4d74a34bdac8141f2540e3ae90623718e47d66d1ca4a2d")},
+	"rinkeby": {big.NewInt(4), params.RinkebyGenesisHash},
+	"goerli":  {big.NewInt(5), params.GoerliGenesisHash},
+	"sepolia": {big.NewInt(11155111), params.SepoliaGenesisHash},
+	"holesky": {big.NewInt(17000), common.HexToHash("0xb5f7f912443c940f21fd611f12828d75b534364ed9e95ca4e307729a4661bde4")},
+}
+
+// chainIDCaller is implemented by method callers (and nodes) which can report
+// the chain id.
+type chainIDCaller interface {
+	ChainID(ctx context.Context) (*big.Int, error)
+}
+
+func (caller *JSONRPCMethodCaller) ChainID(ctx context.Context) (*big.Int, error) {
+	ctx, cancel := context.WithTimeout(ctx, caller.timeout)
+	defer cancel()
+	return caller.ethCli.ChainID(ctx)
+}
+
+// ChainID returns the chain id of the node, or nil if not supported.
+func (node *RemoteNode) ChainID(ctx context.Context) (*big.Int, error) {
+	caller, ok := node.RPCMethodCaller.(chainIDCaller)
+	if !ok {
+		return nil, nil
+	}
+	node.throttle.Take()
+	start := time.Now()
+	id, err := caller.ChainID(ctx)
+	node.requests.observe("eth_chainId", start, err)
+	return id, err
+}
+
+// chainVerifier checks that the nodes are on the expected chain: the one named
+// by the chain name if it is well-known, otherwise the one most nodes are on.
+type chainVerifier struct {
+	mu         sync.Mutex
+	identities map[Node]*chainIdentity
+	checked    map[Node]time.Time
+}
+
+func newChainVerifier() *chainVerifier {
+	return &chainVerifier{
+		identities: make(map[Node]*chainIdentity),
+		checked:    make(map[Node]time.Time),
+	}
+}
+
+// refresh fetches the chain id and genesis of the node, unless done recently.
+// The genesis is refetched rather than taken from the cache, so that a node
+// resynced on another chain is noticed. Beacon nodes are not checked.
+func (cv *chainVerifier) refresh(ctx context.Context, node Node) {
+	if _, ok := node.(*BeaconNode); ok {
+		return
+	}
+	cv.mu.Lock()
+	last := cv.checked[node]
+	cv.mu.Unlock()
+	if time.Since(last) < chainCheckInterval {
+		return
+	}
+	id := &chainIdentity{genesis: node.HashAt(ctx, 0, true)}
+	if id.genesis == (common.Hash{}) {
+		return // try again next time
+	}
+	if n, ok := node.(chainIDCaller); ok {
+		chainId, err := n.ChainID(ctx)
+		if err != nil {
+			log.Info("Error fetching chain id", "node", node.Name(), "error", err)
+			return
+		}
+		id.chainId = chainId
+	}
+	cv.mu.Lock()
+	defer cv.mu.Unlock()
+	cv.identities[node] = id
+	cv.checked[node] = time.Now()
+}
+
+// verify returns the nodes which are not on the expected chain, along with the
+// reason.
+func (cv *chainVerifier) verify(nodes []Node, chainName string) map[Node]error {
+	cv.mu.Lock()
+	defer cv.mu.Unlock()
+
+	// Forget about the nodes no longer monitored
+	known := make(map[Node]bool)
+	for _, n := range nodes {
+		known[n] = true
+	}
+	for n := range cv.identities {
+		if !known[n] {
+			delete(cv.identities, n)
+			delete(cv.checked, n)
+		}
+	}
+	want := knownChains[strings.ToLower(chainName)]
+	if want == nil {
+		want = cv.majority()
+	}
+	if want == nil {
+		return nil
+	}
+	wrong := make(map[Node]error)
+	for _, n := range nodes {
+		id, ok := cv.identities[n]
+		if !ok {
+			continue
+		}
+		if id.genesis != want.genesis {
+			wrong[n] = fmt.Errorf("wrong genesis %v, expected %v", id.genesis.TerminalString(), want.genesis.TerminalString())
+		} else if id.chainId != nil && want.chainId != nil && id.chainId.Cmp(want.chainId) != 0 {
+			wrong[n] = fmt.Errorf("wrong chain id %v, expected %v", id.chainId, want.chainId)
+		}
+	}
+	return wrong
+}
+
+// majority returns the genesis and chain id which more than half of the nodes
+// agree on, if any.
+func (cv *chainVerifier) majority() *chainIdentity {
+	var (
+		geneses  = make(map[common.Hash]int)
+		chainIds = make(map[string]int)
+		want     = new(chainIdentity)
+		ids      int
+	)
+	for _, id := range cv.identities {
+		if geneses[id.genesis]++; 2*geneses[id.genesis] > len(cv.identities) {
+			want.genesis = id.genesis
+		}
+		if id.chainId == nil {
+			continue
+		}
+		ids++
+		chainIds[id.chainId.String()]++
+	}
+	if want.genesis == (common.Hash{}) {
+		return nil
+	}
+	for _, id := range cv.identities {
+		if id.chainId != nil && 2*chainIds[id.chainId.String()] > ids {
+			want.chainId = id.chainId
+		}
+	}
+	return want
+}
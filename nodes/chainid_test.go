@@ -0,0 +1,102 @@
+package nodes
+
+import (
+	"context"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// chainIDNode is a test node which reports a chain id and genesis.
+type chainIDNode struct {
+	*testNode
+	chainId *big.Int
+	genesis common.Hash
+}
+
+func (n *chainIDNode) ChainID(ctx context.Context) (*big.Int, error) {
+	return n.chainId, nil
+}
+
+func (n *chainIDNode) HashAt(ctx context.Context, num uint64, force bool) common.Hash {
+	if num == 0 {
+		if !force {
+			return common.Hash{} // stale cache, the genesis must be refetched
+		}
+		return n.genesis
+	}
+	return n.testNode.HashAt(ctx, num, force)
+}
+
+func TestVerifyChain(t *testing.T) {
+	var (
+		mainnet = &chainIDNode{newTestNode("mainnet", 100, []uint64{0}, []int{0}), big.NewInt(1), params.MainnetGenesisHash}
+		goerli  = &chainIDNode{newTestNode("goerli", 100, []uint64{0}, []int{0}), big.NewInt(5), params.GoerliGenesisHash}
+		forked  = &chainIDNode{newTestNode("forked", 100, []uint64{0}, []int{0}), big.NewInt(7), params.MainnetGenesisHash}
+		nodes   = []Node{mainnet, goerli, forked}
+	)
+	cv := newChainVerifier()
+	for _, n := range nodes {
+		cv.refresh(context.Background(), n)
+	}
+	// Well-known chain
+	wrong := cv.verify(nodes, "Mainnet")
+	if len(wrong) != 2 || wrong[goerli] == nil || wrong[forked] == nil {
+		t.Fatalf("wrong nodes on the wrong chain: %v", wrong)
+	}
+	// Otherwise, by majority of genesis and chain id
+	wrong = cv.verify(nodes, "Playdoh-net")
+	if len(wrong) != 1 || wrong[goerli] == nil {
+		t.Fatalf("wrong nodes on the wrong chain: %v", wrong)
+	}
+	// No majority, no verdict
+	wrong = cv.verify([]Node{mainnet, goerli}, "Playdoh-net")
+	if len(wrong) != 0 {
+		t.Fatalf("wrong nodes on the wrong chain: %v", wrong)
+	}
+}
+
+func TestWrongChainExcluded(t *testing.T) {
+	log.Root().SetHandler(log.LvlFilterHandler(
+		log.LvlCrit, log.StreamHandler(os.Stderr, log.TerminalFormat(false))))
+
+	// Disable the vuln check for tests
+	disableVulnCheck = true
+
+	var (
+		a     = &statusNode{testNode: newTestNode("a", 13_000_000, []uint64{0}, []int{0})}
+		b     = &statusNode{testNode: newTestNode("b", 13_000_000, []uint64{0}, []int{0})}
+		other = &statusNode{testNode: newTestNode("other", 13_000_000, []uint64{0}, []int{1})}
+	)
+	nm, err := NewMonitor([]Node{a, b, other}, nil, time.Second, "Playdoh-net", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nm.doChecks()
+	r := nm.LastReport()
+	if len(r.Splits) != 0 {
+		t.Errorf("node on the wrong chain not excluded from splits: %v", r.Splits[0].Nodes)
+	}
+	if other.status != NodeStatusWrongChain {
+		t.Errorf("wrong status, have %v, want %v", StatusName(other.status), StatusName(NodeStatusWrongChain))
+	}
+	for _, c := range r.Cols {
+		if wrong := c.Name == other.Name(); wrong != (c.Error != "") {
+			t.Errorf("%v: wrong error %q", c.Name, c.Error)
+		}
+	}
+	var alerted bool
+	for _, alert := range r.Alerts {
+		if alert.Kind == AlertWrongChain && alert.Nodes[0] == other.Name() {
+			alerted = true
+		}
+	}
+	if !alerted {
+		t.Errorf("no alert for node on the wrong chain")
+	}
+}
@@ -0,0 +1,122 @@
+package nodes
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// semverRe matches the version part of a client version string, e.g.
+// "v1.11.5-stable-a38f4108" or "2.42.0".
+var semverRe = regexp.MustCompile(`^v?(\d+)\.(\d+)(?:\.(\d+))?([-+].*)?$`)
+
+// commitRe matches a (possibly abbreviated) commit hash.
+var commitRe = regexp.MustCompile(`^[0-9a-f]{7,40}$`)
+
+// semver is a major, minor and patch version.
+type semver [3]int
+
+// parseSemver parses a version like "v1.9.24" or "1.9". Anything following the
+// patch version is ignored.
+func parseSemver(s string) (semver, bool) {
+	m := semverRe.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return semver{}, false
+	}
+	var v semver
+	for i := range v {
+		if len(m[i+1]) > 0 {
+			v[i], _ = strconv.Atoi(m[i+1])
+		}
+	}
+	return v, true
+}
+
+// cmp returns -1, 0 or 1, if v is lower, equal or higher than o.
+func (v semver) cmp(o semver) int {
+	for i := range v {
+		if v[i] < o[i] {
+			return -1
+		}
+		if v[i] > o[i] {
+			return 1
+		}
+	}
+	return 0
+}
+
+func (v semver) String() string {
+	return fmt.Sprintf("%d.%d.%d", v[0], v[1], v[2])
+}
+
+// clientVersion is a parsed web3_clientVersion string. The clients format it
+// alike: name, optionally an identity (geth), the version with tag and commit,
+// the platform and the runtime (if any).
+//
+//	Geth/v1.11.5-stable-a38f4108/linux-amd64/go1.20.2
+//	besu/v23.1.2/linux-x86_64/openjdk-java-17
+//	Nethermind/v1.17.3+da1e2d28/linux-x64/dotnet7.0.4
+//	erigon/2.42.0/linux-amd64/go1.20.2
+//	reth/v0.1.0-alpha.1-a5e3e7c3/x86_64-unknown-linux-gnu
+type clientVersion struct {
+	Name    string // lowercase, e.g. "geth"
+	Version string // e.g. "1.11.5"
+	Tag     string // e.g. "stable", "unstable" or "alpha.1"
+	Commit  string
+	OS      string // the platform, e.g. "linux-amd64"
+	Runtime string // e.g. "go1.20.2"
+
+	semver semver
+}
+
+// prerelease returns whether the version is a pre-release, e.g. "unstable" or
+// "alpha.1", rather than the release of its version number.
+func (cv *clientVersion) prerelease() bool {
+	return len(cv.Tag) > 0 && cv.Tag != "stable"
+}
+
+// parseClientVersion parses the given client version string, returning nil if
+// no version number is found in it.
+func parseClientVersion(s string) *clientVersion {
+	parts := strings.Split(strings.TrimSpace(s), "/")
+	if len(parts) < 2 || len(parts[0]) == 0 {
+		return nil
+	}
+	for i := 1; i < len(parts); i++ {
+		m := semverRe.FindStringSubmatch(parts[i])
+		if m == nil {
+			continue
+		}
+		v, _ := parseSemver(parts[i])
+		cv := &clientVersion{
+			Name:    strings.ToLower(parts[0]),
+			Version: v.String(),
+			semver:  v,
+		}
+		// The suffix holds the tag and commit, as in "-stable-a38f4108" or "+da1e2d28"
+		suffix, meta, _ := strings.Cut(m[4], "+")
+		var tags []string
+		for _, t := range strings.Split(suffix, "-") {
+			switch {
+			case len(t) == 0:
+			case len(cv.Commit) == 0 && commitRe.MatchString(t) && strings.Trim(t, "0123456789") != "":
+				cv.Commit = t
+			default:
+				tags = append(tags, t)
+			}
+		}
+		cv.Tag = strings.Join(tags, "-")
+		if len(cv.Commit) == 0 && commitRe.MatchString(meta) {
+			cv.Commit = meta
+		}
+		if i+1 < len(parts) {
+			cv.OS = parts[i+1]
+		}
+		if i+2 < len(parts) {
+			cv.Runtime = parts[i+2]
+		}
+		return cv
+	}
+	return nil
+}
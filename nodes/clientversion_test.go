@@ -0,0 +1,40 @@
+package nodes
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseClientVersion(t *testing.T) {
+	for _, tt := range []struct {
+		input string
+		want  *clientVersion
+	}{
+		{"Geth/v1.11.5-stable-a38f4108/linux-amd64/go1.20.2",
+			&clientVersion{Name: "geth", Version: "1.11.5", Tag: "stable", Commit: "a38f4108", OS: "linux-amd64", Runtime: "go1.20.2", semver: semver{1, 11, 5}}},
+		{"Geth/v1.9.25-unstable-15339cf1-20201204/linux-amd64/go1.15.4",
+			&clientVersion{Name: "geth", Version: "1.9.25", Tag: "unstable-20201204", Commit: "15339cf1", OS: "linux-amd64", Runtime: "go1.15.4", semver: semver{1, 9, 25}}},
+		// Geth with an identity
+		{"Geth/mynode/v1.10.26-stable/linux-amd64/go1.18.5",
+			&clientVersion{Name: "geth", Version: "1.10.26", Tag: "stable", OS: "linux-amd64", Runtime: "go1.18.5", semver: semver{1, 10, 26}}},
+		{"besu/v23.1.2/linux-x86_64/openjdk-java-17",
+			&clientVersion{Name: "besu", Version: "23.1.2", OS: "linux-x86_64", Runtime: "openjdk-java-17", semver: semver{23, 1, 2}}},
+		{"besu/v23.4.1-dev-ac23d311/linux-x86_64/openjdk-java-17",
+			&clientVersion{Name: "besu", Version: "23.4.1", Tag: "dev", Commit: "ac23d311", OS: "linux-x86_64", Runtime: "openjdk-java-17", semver: semver{23, 4, 1}}},
+		{"Nethermind/v1.17.3+da1e2d28/linux-x64/dotnet7.0.4",
+			&clientVersion{Name: "nethermind", Version: "1.17.3", Commit: "da1e2d28", OS: "linux-x64", Runtime: "dotnet7.0.4", semver: semver{1, 17, 3}}},
+		{"erigon/2.42.0/linux-amd64/go1.20.2",
+			&clientVersion{Name: "erigon", Version: "2.42.0", OS: "linux-amd64", Runtime: "go1.20.2", semver: semver{2, 42, 0}}},
+		{"reth/v0.1.0-alpha.1-a5e3e7c3/x86_64-unknown-linux-gnu",
+			&clientVersion{Name: "reth", Version: "0.1.0", Tag: "alpha.1", Commit: "a5e3e7c3", OS: "x86_64-unknown-linux-gnu", semver: semver{0, 1, 0}}},
+		{"TestNode/v0.1/darwin/go1.4.1",
+			&clientVersion{Name: "testnode", Version: "0.1.0", OS: "darwin", Runtime: "go1.4.1", semver: semver{0, 1, 0}}},
+		{"Etherscan", nil},
+		{"Infura V3", nil},
+		{"", nil},
+	} {
+		if have := parseClientVersion(tt.input); !reflect.DeepEqual(have, tt.want) {
+			t.Errorf("%q: wrong version\nhave %+v\nwant %+v", tt.input, have, tt.want)
+		}
+	}
+}
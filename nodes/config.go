@@ -1,11 +1,27 @@
 package nodes
 
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
 type Config struct {
 	ReloadInterval string
 	ChainName      string
 	ServerAddress  string
+	LagThreshold   uint64 // how many blocks a node may be behind the head of the nodes, defaults to 10
+	BlockTime      string // the expected block time, e.g. "12s", defaults to the slot time reported by the nodes
+	StallThreshold string // how long a node may go without progress, defaults to 25 times the block time
 	Clients        []ClientInfo
+	Chains         []ChainConfig // multiple chains, instead of ChainName and Clients
 	Metrics        metricsConfig
+	Alerts         alertsConfig
+	Vulns          vulnsConfig
+	Storage        storageConfig
+	VulnFile       string // local vulnerability feed, for use without internet access
 
 	InfuraKey      string
 	InfuraEndpoint string
@@ -18,12 +34,21 @@ type Config struct {
 }
 
 type metricsConfig struct {
-	Enabled   bool
+	Enabled   bool // push metrics to InfluxDB
 	Endpoint  string
 	Username  string
 	Database  string
 	Password  string
 	Namespace string
+
+	Prometheus     bool   // serve metrics for Prometheus on the built-in server
+	PrometheusPath string // defaults to /metrics
+}
+
+type storageConfig struct {
+	Engine    string // leveldb (default), pebble or memory
+	Datadir   string // the directory of the block databases, defaults to the working directory
+	Retention uint64 // how many blocks below the head headers are kept for, 0 to keep all
 }
 
 type ClientInfo struct {
@@ -32,4 +57,182 @@ type ClientInfo struct {
 	Kind        string
 	Ratelimit   int
 	AuthHeaders []string
+	Timeout     string  // per request, e.g. "10s", defaults to 3s
+	Scenario    string  // the scenario file of a simulated node
+	Weight      float64 // how much the client counts towards the canonical chain, defaults to 1
+	Group       string  // clients of the same group share one vote, e.g. "geth"
+}
+
+// RequestTimeout returns how long a request to the client may take.
+func (c *ClientInfo) RequestTimeout() (time.Duration, error) {
+	if len(c.Timeout) == 0 {
+		return DefaultTimeout, nil
+	}
+	timeout, err := time.ParseDuration(c.Timeout)
+	if err != nil {
+		return 0, fmt.Errorf("client %q: invalid timeout: %v", c.Name, err)
+	}
+	if timeout <= 0 {
+		return 0, fmt.Errorf("client %q: timeout must be positive", c.Name)
+	}
+	return timeout, nil
+}
+
+type alertsConfig struct {
+	StallTimeout string // alert when a node has not progressed for this long, e.g. "5m"
+	SplitDepth   int    // alert on splits at least this many blocks deep (default 1)
+	Webhooks     []webhookConfig
+}
+
+type webhookConfig struct {
+	Url     string
+	Headers []string // "Key: value" pairs
+	Retries int      // default 3, -1 disables the retries
+}
+
+type vulnsConfig struct {
+	Feeds []vulnFeedConfig // defaults to the geth vulnerability feed
+}
+
+type vulnFeedConfig struct {
+	Url        string   // http(s) url or local file, in the format of the geth feed
+	Signature  string   // minisign signature, defaults to the url + ".minisig"
+	PublicKeys []string // minisign keys; if set, the feed is only used if signed by one of them
+	Client     string   // if set, only nodes of this client are checked, e.g. "besu"
+}
+
+// ChainConfig is the configuration of one of the monitored chains.
+type ChainConfig struct {
+	Name           string
+	ReloadInterval string // defaults to the global reload interval
+	LagThreshold   uint64 // defaults to the global lag threshold
+	BlockTime      string // defaults to the global block time
+	StallThreshold string // defaults to the global stall threshold
+	Datadir        string // the block database, defaults to blockDB-<id>
+	Clients        []ClientInfo
+
+	id string // empty for a single chain configured the legacy way
+}
+
+// ID returns the identifier of the chain, which is used in the dashboard paths
+// and as the chain label of the metrics. It is empty if the chain is configured
+// at the top level of the config, rather than as one of multiple chains.
+func (c *ChainConfig) ID() string {
+	return c.id
+}
+
+// StallSettings returns the expected block time of the chain, and how long a
+// node may go without progress before it is considered stalled. Either is zero
+// if not configured.
+func (c *ChainConfig) StallSettings() (blockTime, threshold time.Duration, err error) {
+	if len(c.BlockTime) > 0 {
+		if blockTime, err = time.ParseDuration(c.BlockTime); err != nil {
+			return 0, 0, fmt.Errorf("chain %q: invalid block_time: %v", c.Name, err)
+		}
+		if blockTime <= 0 {
+			return 0, 0, fmt.Errorf("chain %q: block_time must be positive", c.Name)
+		}
+	}
+	if len(c.StallThreshold) > 0 {
+		if threshold, err = time.ParseDuration(c.StallThreshold); err != nil {
+			return 0, 0, fmt.Errorf("chain %q: invalid stall_threshold: %v", c.Name, err)
+		}
+		if threshold <= 0 {
+			return 0, 0, fmt.Errorf("chain %q: stall_threshold must be positive", c.Name)
+		}
+	}
+	return blockTime, threshold, nil
+}
+
+// Votes returns how much each client counts towards the canonical chain, by name.
+func (c *ChainConfig) Votes() map[string]Vote {
+	votes := make(map[string]Vote)
+	for _, client := range c.Clients {
+		if client.Weight != 0 || len(client.Group) > 0 {
+			votes[client.Name] = Vote{Weight: client.Weight, Group: client.Group}
+		}
+	}
+	return votes
+}
+
+// validate checks the settings of the chain which are not checked when the
+// clients are instantiated.
+func (c *ChainConfig) validate() error {
+	if _, _, err := c.StallSettings(); err != nil {
+		return err
+	}
+	for _, client := range c.Clients {
+		if client.Weight < 0 {
+			return fmt.Errorf("client %q: weight must not be negative", client.Name)
+		}
+	}
+	return nil
+}
+
+// chainID derives the identifier of a chain from its name: lowercase, with
+// the words joined by dashes and anything but letters and digits left out.
+func chainID(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_' {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, strings.Join(strings.Fields(name), "-"))
+}
+
+// ChainConfigs returns the configured chains. If there are no chain sections,
+// the top-level chain name and clients make up the one chain.
+func (conf *Config) ChainConfigs() ([]ChainConfig, error) {
+	if len(conf.Chains) == 0 {
+		c := ChainConfig{
+			Name:           conf.ChainName,
+			ReloadInterval: conf.ReloadInterval,
+			LagThreshold:   conf.LagThreshold,
+			BlockTime:      conf.BlockTime,
+			StallThreshold: conf.StallThreshold,
+			Datadir:        "blockDB",
+			Clients:        conf.Clients,
+		}
+		if err := c.validate(); err != nil {
+			return nil, err
+		}
+		return []ChainConfig{c}, nil
+	}
+	if len(conf.Clients) > 0 {
+		return nil, errors.New("clients must be configured per chain when using chain sections")
+	}
+	var (
+		chains = make([]ChainConfig, len(conf.Chains))
+		seen   = make(map[string]bool)
+	)
+	for i, c := range conf.Chains {
+		c.id = chainID(c.Name)
+		if len(c.id) == 0 {
+			return nil, fmt.Errorf("chain %d: missing name", i)
+		}
+		if seen[c.id] {
+			return nil, fmt.Errorf("chain %q: duplicate name", c.Name)
+		}
+		seen[c.id] = true
+		if len(c.ReloadInterval) == 0 {
+			c.ReloadInterval = conf.ReloadInterval
+		}
+		if c.LagThreshold == 0 {
+			c.LagThreshold = conf.LagThreshold
+		}
+		if len(c.BlockTime) == 0 {
+			c.BlockTime = conf.BlockTime
+		}
+		if len(c.StallThreshold) == 0 {
+			c.StallThreshold = conf.StallThreshold
+		}
+		if err := c.validate(); err != nil {
+			return nil, err
+		}
+		if len(c.Datadir) == 0 {
+			c.Datadir = "blockDB-" + c.id
+		}
+		chains[i] = c
+	}
+	return chains, nil
 }
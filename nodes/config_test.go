@@ -0,0 +1,93 @@
+package nodes
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/naoina/toml"
+)
+
+func TestChainConfigs(t *testing.T) {
+	var config Config
+	err := toml.NewDecoder(strings.NewReader(`
+reload_interval = "10s"
+lag_threshold = 5
+block_time = "12s"
+
+[[chains]]
+name = "Mainnet"
+
+[[chains.clients]]
+kind = "rpc"
+url = "http://localhost:8545"
+name = "geth"
+
+[[chains]]
+name = "Sepolia testnet"
+reload_interval = "30s"
+lag_threshold = 20
+stall_threshold = "2m"
+datadir = "/data/sepolia"
+
+[[chains.clients]]
+kind = "rpc"
+url = "http://localhost:8546"
+name = "geth"
+weight = 2.0
+group = "geth"
+`)).Decode(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chains, err := config.ChainConfigs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chains) != 2 {
+		t.Fatalf("wrong chains, have %d, want 2", len(chains))
+	}
+	for i, want := range []ChainConfig{
+		{Name: "Mainnet", ReloadInterval: "10s", LagThreshold: 5, BlockTime: "12s", Datadir: "blockDB-mainnet", id: "mainnet"},
+		{Name: "Sepolia testnet", ReloadInterval: "30s", LagThreshold: 20, BlockTime: "12s", StallThreshold: "2m", Datadir: "/data/sepolia", id: "sepolia-testnet"},
+	} {
+		have := chains[i]
+		if have.Name != want.Name || have.ReloadInterval != want.ReloadInterval || have.LagThreshold != want.LagThreshold || have.BlockTime != want.BlockTime || have.StallThreshold != want.StallThreshold || have.Datadir != want.Datadir || have.ID() != want.id {
+			t.Errorf("chain %d: wrong config, have %+v, want %+v", i, have, want)
+		}
+		if len(have.Clients) != 1 {
+			t.Errorf("chain %d: wrong clients, have %d, want 1", i, len(have.Clients))
+		}
+	}
+	if have, want := chains[1].Votes(), map[string]Vote{"geth": {Weight: 2, Group: "geth"}}; !reflect.DeepEqual(have, want) {
+		t.Errorf("wrong votes, have %v, want %v", have, want)
+	}
+	if votes := chains[0].Votes(); len(votes) != 0 {
+		t.Errorf("wrong default votes: %v", votes)
+	}
+	// Without chain sections, the top-level settings make up the one chain
+	legacy := Config{ChainName: "Mainnet", ReloadInterval: "10s", Clients: []ClientInfo{{Name: "geth"}}}
+	chains, err = legacy.ChainConfigs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chains) != 1 || chains[0].ID() != "" || chains[0].Datadir != "blockDB" || len(chains[0].Clients) != 1 {
+		t.Errorf("wrong legacy chain: %+v", chains)
+	}
+	// Invalid durations are rejected
+	config.Chains[1].StallThreshold = "2 minutes"
+	if _, err := config.ChainConfigs(); err == nil {
+		t.Errorf("invalid stall threshold not rejected")
+	}
+	config.Chains[1].StallThreshold = ""
+	config.Chains[1].Clients[0].Weight = -1
+	if _, err := config.ChainConfigs(); err == nil {
+		t.Errorf("negative weight not rejected")
+	}
+	config.Chains[1].Clients[0].Weight = 1
+	// Duplicate chains are rejected
+	config.Chains[1].Name = " mainnet"
+	if _, err := config.ChainConfigs(); err == nil {
+		t.Errorf("duplicate chains not rejected")
+	}
+}
@@ -0,0 +1,114 @@
+package nodes
+
+import (
+	"context"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Vote is how much the opinion of a node counts, when determining the canonical
+// chain by majority.
+type Vote struct {
+	Weight float64 // the trust in the node, defaults to 1
+	Group  string  // nodes of the same group share one vote, e.g. the nodes of one client
+}
+
+// consensusJson is the canonical hash at one of the interesting numbers, as
+// determined by the weighted majority of the nodes.
+type consensusJson struct {
+	Number   int
+	Hash     common.Hash // zero if there is no majority (or the slot is empty, for beacon nodes)
+	Weight   float64     // the votes for the canonical hash
+	Total    float64     // the votes of all nodes with a block at the number
+	Minority []string    `json:",omitempty"` // the nodes with another block at the number
+}
+
+// canonicalChain determines the canonical hash at each of the numbers, by the
+// weighted votes of the nodes. Each node votes for the hash of its block at the
+// number, if any. The nodes which share a group split its vote between them,
+// so that e.g. running more nodes of one client does not outvote the others.
+// A tie means there is no canonical hash.
+func canonicalChain(ctx context.Context, nodes []Node, numbers []int, votes map[string]Vote) []*consensusJson {
+	var consensus []*consensusJson
+	for _, num := range numbers {
+		var (
+			hashes = make(map[string]common.Hash)
+			groups = make(map[string]int)
+		)
+		for _, n := range nodes {
+			bl := n.BlockAt(ctx, uint64(num), false)
+			if bl == nil {
+				continue // no opinion on it
+			}
+			hashes[n.Name()] = bl.hash
+			groups[voteGroup(n.Name(), votes)]++
+		}
+		if len(hashes) == 0 {
+			continue
+		}
+		c := &consensusJson{Number: num}
+		tally := make(map[common.Hash]float64)
+		for name, hash := range hashes {
+			weight := voteWeight(name, votes) / float64(groups[voteGroup(name, votes)])
+			tally[hash] += weight
+			c.Total += weight
+		}
+		var tie bool
+		for hash, weight := range tally {
+			switch {
+			case weight > c.Weight:
+				c.Hash, c.Weight, tie = hash, weight, false
+			case weight == c.Weight:
+				tie = true
+			}
+		}
+		if tie {
+			c.Hash = common.Hash{}
+		} else {
+			for name, hash := range hashes {
+				if hash != c.Hash {
+					c.Minority = append(c.Minority, name)
+				}
+			}
+			sort.Strings(c.Minority)
+		}
+		consensus = append(consensus, c)
+	}
+	return consensus
+}
+
+func voteWeight(name string, votes map[string]Vote) float64 {
+	if v, ok := votes[name]; ok && v.Weight > 0 {
+		return v.Weight
+	}
+	return 1
+}
+
+// voteGroup returns the group of the named node, which is the node itself if
+// it is not part of a group.
+func voteGroup(name string, votes map[string]Vote) string {
+	if v, ok := votes[name]; ok && len(v.Group) > 0 {
+		return "group/" + v.Group
+	}
+	return "node/" + name
+}
+
+// minorityNodes returns the nodes on a minority fork, disagreeing with the
+// canonical hash at any of the numbers.
+func minorityNodes(consensus []*consensusJson) []string {
+	var (
+		names []string
+		seen  = make(map[string]bool)
+	)
+	for _, c := range consensus {
+		for _, name := range c.Minority {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,84 @@
+package nodes
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+func TestCanonicalChain(t *testing.T) {
+	var (
+		a = newTestNode("a", 1000, []uint64{0}, []int{0})
+		b = newTestNode("b", 1000, []uint64{0}, []int{0})
+		c = newTestNode("c", 1005, []uint64{0}, []int{0})
+		d = newTestNode("d", 1000, []uint64{0, 990}, []int{0, 1})
+		e = newTestNode("e", 1000, []uint64{0, 990}, []int{0, 1})
+
+		nodes   = []Node{a, b, c, d, e}
+		numbers = []int{980, 1000, 1005}
+		canon   = hashFromSeed(0, 1000)
+		fork    = hashFromSeed(1, 1000)
+	)
+	for i, tt := range []struct {
+		votes    map[string]Vote
+		want     common.Hash // the canonical hash at 1000, zero for a tie
+		minority []string
+	}{
+		// One node, one vote
+		{nil, canon, []string{d.Name(), e.Name()}},
+		// The forked nodes are trusted more
+		{map[string]Vote{d.Name(): {Weight: 2}, e.Name(): {Weight: 2}}, fork, []string{a.Name(), b.Name(), c.Name()}},
+		// The canonical nodes are of the same client, and share one vote
+		{map[string]Vote{a.Name(): {Group: "geth"}, b.Name(): {Group: "geth"}, c.Name(): {Group: "geth"}}, fork, []string{a.Name(), b.Name(), c.Name()}},
+		// A tie decides nothing
+		{map[string]Vote{a.Name(): {Weight: 0.5}, b.Name(): {Weight: 0.5}}, common.Hash{}, nil},
+	} {
+		consensus := canonicalChain(context.Background(), nodes, numbers, tt.votes)
+		if len(consensus) != len(numbers) {
+			t.Fatalf("test %d: wrong consensus, have %d numbers, want %d", i, len(consensus), len(numbers))
+		}
+		if have := consensus[1].Hash; have != tt.want {
+			t.Errorf("test %d: wrong canonical hash, have %x, want %x", i, have, tt.want)
+		}
+		if have := minorityNodes(consensus); !reflect.DeepEqual(have, tt.minority) {
+			t.Errorf("test %d: wrong minority, have %v, want %v", i, have, tt.minority)
+		}
+		// Below the fork, all agree. Above the head of the others, c is alone.
+		if c := consensus[0]; len(c.Minority) != 0 || c.Total == 0 || c.Weight != c.Total {
+			t.Errorf("test %d: disagreement below the fork: %+v", i, c)
+		}
+		if c := consensus[2]; len(c.Minority) != 0 || c.Hash != hashFromSeed(0, 1005) {
+			t.Errorf("test %d: wrong consensus above the heads: %+v", i, c)
+		}
+	}
+}
+
+func TestVotesFirstRound(t *testing.T) {
+	log.Root().SetHandler(log.LvlFilterHandler(
+		log.LvlCrit, log.StreamHandler(os.Stderr, log.TerminalFormat(false))))
+
+	// Disable the vuln check for tests
+	disableVulnCheck = true
+
+	var (
+		a = newTestNode("a", 1000, []uint64{0}, []int{0})
+		b = newTestNode("b", 1000, []uint64{0}, []int{0})
+		c = newTestNode("c", 1000, []uint64{0, 990}, []int{0, 1})
+	)
+	nm, err := NewMonitor([]Node{a, b, c}, nil, time.Hour, "Playdoh-net", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nm.Stop()
+	// The forked node outweighs the others from the first round on
+	nm.SetVotes(map[string]Vote{c.Name(): {Weight: 3}})
+	r := waitReport(t, nm)
+	if want := []string{a.Name(), b.Name()}; !reflect.DeepEqual(r.Minority, want) {
+		t.Errorf("wrong minority in the first report, have %v, want %v", r.Minority, want)
+	}
+}
@@ -1,6 +1,7 @@
 package nodes
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,25 +12,28 @@ import (
 
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/eth"
-	"github.com/ethereum/go-ethereum/metrics"
-	"go.uber.org/ratelimit"
+	"github.com/ethereum/go-ethereum/rpc"
 )
 
 // etherscanMethodCaller wraps calls to etherscan.
 type etherscanMethodCaller struct {
-	url    string
-	apiKey string
+	url     string
+	apiKey  string
+	timeout time.Duration
 }
 
-func NewEtherscanHeaderCall(url, apiKey string) *etherscanMethodCaller {
-	return &etherscanMethodCaller{url: url, apiKey: apiKey}
+func NewEtherscanHeaderCall(url, apiKey string, timeout time.Duration) *etherscanMethodCaller {
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	return &etherscanMethodCaller{url: url, apiKey: apiKey, timeout: timeout}
 }
 
-func (caller *etherscanMethodCaller) Version() (string, error) {
+func (caller *etherscanMethodCaller) Version(ctx context.Context) (string, error) {
 	return "Not available", nil
 }
 
-func (caller *etherscanMethodCaller) GetBadBlocks() ([]*eth.BadBlockArgs, error) {
+func (caller *etherscanMethodCaller) GetBadBlocks(ctx context.Context) ([]*eth.BadBlockArgs, error) {
 	return []*eth.BadBlockArgs{}, nil
 }
 
@@ -41,15 +45,25 @@ type jsonrpcMessage struct {
 	Result  json.RawMessage `json:"result,omitempty"`
 }
 
-func (caller *etherscanMethodCaller) HeaderByNumber(num *big.Int) (*types.Header, error) {
+func (caller *etherscanMethodCaller) HeaderByNumber(ctx context.Context, num *big.Int) (*types.Header, error) {
 	action := "eth_getBlockByNumber"
 	tag := fmt.Sprintf("0x%x", num)
 	if num == nil {
 		tag = "latest"
+	} else if num.Sign() < 0 {
+		// finalized, safe or pending
+		txt, _ := rpc.BlockNumber(num.Int64()).MarshalText()
+		tag = string(txt)
 	}
 	// https://api.etherscan.io/api?module=proxy&action=eth_getBlockByNumber&tag=0x10d4f&boolean=true&apikey=YourApiKeyToken
 	url := fmt.Sprintf("%s?module=proxy&action=%s&tag=%s&boolean=true&apikey=%s", caller.url, action, tag, caller.apiKey)
-	resp, err := http.Get(url)
+	ctx, cancel := context.WithTimeout(ctx, caller.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -66,24 +80,11 @@ func (caller *etherscanMethodCaller) HeaderByNumber(num *big.Int) (*types.Header
 	return head, nil
 }
 
-func NewEtherscanNode(name, apiKey, endpoint string, db *blockDB, rateLimit int) (*RemoteNode, error) {
+func NewEtherscanNode(name, chain, apiKey, endpoint string, db *blockDB, rateLimit int, timeout time.Duration) (*RemoteNode, error) {
 	if len(apiKey) == 0 {
 		return nil, errors.New("Missing etherscan_key")
 	}
-	gaugeName := fmt.Sprintf("head/%v", name)
-	throttle := ratelimit.NewUnlimited()
-	if rateLimit > 0 {
-		throttle = ratelimit.New(rateLimit)
-	}
 
-	return &RemoteNode{
-		RPCMethodCaller: NewEtherscanHeaderCall(endpoint, apiKey),
-		name:            name,
-		version:         "Etherscan",
-		chainHistory:    make(map[uint64]*blockInfo),
-		db:              db,
-		headGauge:       metrics.GetOrRegisterGauge(gaugeName, registry),
-		throttle:        throttle,
-		lastCheck:       make(map[string]time.Time),
-	}, nil
+	caller := NewEtherscanHeaderCall(endpoint, apiKey, timeout)
+	return newRemoteNode(name, chain, "Etherscan", caller, db, rateLimit), nil
 }
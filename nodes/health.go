@@ -0,0 +1,351 @@
+package nodes
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// healthCheckInterval is how often the sync status and peer count are polled.
+const healthCheckInterval = 30 * time.Second
+
+// DefaultBlockTime is the expected time between blocks, unless configured or
+// reported by the nodes.
+const DefaultBlockTime = 12 * time.Second
+
+// stallBlocks is how many blocks' worth of time a node may go without
+// progressing the chain, before it is considered stalled, unless the stall
+// threshold is configured.
+const stallBlocks = 25
+
+// DefaultLagThreshold is how many blocks a node may be behind the head of the
+// nodes, before it is considered lagging, unless configured otherwise.
+const DefaultLagThreshold = 10
+
+// syncChecker is implemented by method callers which can report the sync status
+// and peer count of the node.
+type syncChecker interface {
+	SyncProgress(ctx context.Context) (*ethereum.SyncProgress, error)
+	PeerCount(ctx context.Context) (uint64, error)
+}
+
+// slotTimer is implemented by nodes which can tell the block time of their
+// chain, e.g. the slot time of a beacon node.
+type slotTimer interface {
+	SlotTime() time.Duration // 0 if unknown
+}
+
+// healthReporter is implemented by nodes which report their sync status and
+// peer count.
+type healthReporter interface {
+	Syncing() bool
+	Peers() int // -1 if unknown
+}
+
+func (caller *JSONRPCMethodCaller) SyncProgress(ctx context.Context) (*ethereum.SyncProgress, error) {
+	ctx, cancel := context.WithTimeout(ctx, caller.timeout)
+	defer cancel()
+	return caller.ethCli.SyncProgress(ctx)
+}
+
+func (caller *JSONRPCMethodCaller) PeerCount(ctx context.Context) (uint64, error) {
+	ctx, cancel := context.WithTimeout(ctx, caller.timeout)
+	defer cancel()
+	return caller.ethCli.PeerCount(ctx)
+}
+
+// checkSync polls the sync status and peer count, if supported and not done
+// recently. The caller must hold the node lock.
+func (node *RemoteNode) checkSync(ctx context.Context) {
+	checker, ok := node.RPCMethodCaller.(syncChecker)
+	if !ok || time.Since(node.lastCheck["eth_syncing"]) < healthCheckInterval {
+		return
+	}
+	node.lastCheck["eth_syncing"] = time.Now()
+
+	node.throttle.Take()
+	start := time.Now()
+	progress, err := checker.SyncProgress(ctx)
+	node.requests.observe("eth_syncing", start, err)
+	if err != nil {
+		log.Debug("Error checking sync status", "node", node.name, "error", err)
+	} else {
+		node.syncing = progress != nil
+		if node.syncing {
+			node.syncingGauge.Update(int64(progress.HighestBlock - progress.CurrentBlock))
+		} else {
+			node.syncingGauge.Update(0)
+		}
+	}
+	node.throttle.Take()
+	start = time.Now()
+	peers, err := checker.PeerCount(ctx)
+	node.requests.observe("net_peerCount", start, err)
+	if err != nil {
+		log.Debug("Error checking peer count", "node", node.name, "error", err)
+		node.peers = -1
+	} else {
+		node.peers = int(peers)
+		node.peersGauge.Update(int64(peers))
+	}
+}
+
+func (node *RemoteNode) Syncing() bool {
+	node.mu.RLock()
+	defer node.mu.RUnlock()
+	return node.syncing
+}
+
+func (node *RemoteNode) Peers() int {
+	node.mu.RLock()
+	defer node.mu.RUnlock()
+	return node.peers
+}
+
+type beaconSyncingResponse struct {
+	Data struct {
+		IsSyncing    bool   `json:"is_syncing"`
+		SyncDistance uint64 `json:"sync_distance,string"`
+	} `json:"data"`
+}
+
+type beaconPeerCountResponse struct {
+	Data struct {
+		Connected uint64 `json:"connected,string"`
+	} `json:"data"`
+}
+
+// checkSync polls the sync status and peer count, if not done recently. The
+// caller must hold the node lock.
+func (node *BeaconNode) checkSync(ctx context.Context) {
+	if time.Since(node.lastCheck["syncing"]) < healthCheckInterval {
+		return
+	}
+	node.lastCheck["syncing"] = time.Now()
+
+	var syncing beaconSyncingResponse
+	if err := node.get(ctx, "/eth/v1/node/syncing", &syncing); err != nil {
+		log.Debug("Error checking sync status", "node", node.name, "error", err)
+	} else {
+		node.syncing = syncing.Data.IsSyncing
+		node.syncingGauge.Update(int64(syncing.Data.SyncDistance))
+	}
+	var peers beaconPeerCountResponse
+	if err := node.get(ctx, "/eth/v1/node/peer_count", &peers); err != nil {
+		log.Debug("Error checking peer count", "node", node.name, "error", err)
+		node.peers = -1
+	} else {
+		node.peers = int(peers.Data.Connected)
+		node.peersGauge.Update(int64(node.peers))
+	}
+}
+
+func (node *BeaconNode) Syncing() bool {
+	node.mu.RLock()
+	defer node.mu.RUnlock()
+	return node.syncing
+}
+
+func (node *BeaconNode) Peers() int {
+	node.mu.RLock()
+	defer node.mu.RUnlock()
+	return node.peers
+}
+
+// chainSlotTime returns the block time reported by any of the nodes, or 0 if
+// none of them can tell.
+func chainSlotTime(nodes []Node) time.Duration {
+	for _, n := range nodes {
+		if st, ok := n.(slotTimer); ok {
+			if d := st.SlotTime(); d > 0 {
+				return d
+			}
+		}
+	}
+	return 0
+}
+
+// nodeStatus returns the status of a reachable node, as far as the node itself
+// can tell.
+func nodeStatus(node Node) int {
+	hr, ok := node.(healthReporter)
+	switch {
+	case !ok:
+		return NodeStatusOK
+	case hr.Syncing():
+		return NodeStatusSyncing
+	case hr.Peers() == 0:
+		return NodeStatusNoPeers
+	}
+	return NodeStatusOK
+}
+
+// lagJson is how far a node is behind the head of the nodes.
+type lagJson struct {
+	Blocks  uint64 // the number of blocks behind
+	Seconds int64  // how long ago the block following the node's head was first reached
+}
+
+// StallEvent is sent on the monitor's event stream when a node stalls, and
+// when it progresses again.
+type StallEvent struct {
+	Chain        string    `json:"chain"`
+	Node         string    `json:"node"`
+	Beacon       bool      `json:"beacon,omitempty"`
+	Stalled      bool      `json:"stalled"` // false once the node progresses again
+	Head         uint64    `json:"head"`
+	LastProgress time.Time `json:"lastProgress"`
+	Threshold    string    `json:"threshold"` // how long the node may go without progress
+}
+
+// progressChecker marks the healthy nodes which have not progressed for a while
+// as stalled, and the ones too far behind the head of the nodes as lagging. The
+// head is the highest number reached by at least half of the synced nodes, so
+// that a single node running ahead (e.g. on a fork) does not make all others
+// lag. The nodes are expected to be of the same layer.
+type progressChecker struct {
+	lagThreshold   uint64
+	blockTime      time.Duration        // the expected block time, 0 to go by the nodes
+	stallThreshold time.Duration        // 0 for stallBlocks times the block time
+	namespace      string               // labels the metrics, empty when monitoring a single chain
+	prefix         string               // of the metric names, for the beacon nodes
+	reached        map[uint64]time.Time // when the head numbers were first reached by any of the nodes
+	stalled        map[string]bool      // the nodes currently stalled, by name
+}
+
+func newProgressChecker(namespace, prefix string) *progressChecker {
+	return &progressChecker{
+		lagThreshold: DefaultLagThreshold,
+		namespace:    namespace,
+		prefix:       prefix,
+		reached:      make(map[uint64]time.Time),
+		stalled:      make(map[string]bool),
+	}
+}
+
+// threshold returns how long a node may go without progress, given the block
+// time reported by the nodes (0 if unknown).
+func (pc *progressChecker) threshold(slotTime time.Duration) time.Duration {
+	if pc.stallThreshold > 0 {
+		return pc.stallThreshold
+	}
+	blockTime := pc.blockTime
+	if blockTime == 0 {
+		blockTime = slotTime
+	}
+	if blockTime == 0 {
+		blockTime = DefaultBlockTime
+	}
+	return stallBlocks * blockTime
+}
+
+// check updates the status of the nodes, and returns the lag of each node by
+// name, along with the nodes which stalled or progressed again since the last
+// check. The slot time is the block time reported by the nodes, 0 if unknown.
+// The nodes left out of a round, e.g. while unreachable, keep their stall until
+// checked again, or forgotten once removed.
+func (pc *progressChecker) check(nodes []Node, slotTime time.Duration) (map[string]*lagJson, []*StallEvent) {
+	if len(nodes) == 0 {
+		return nil, nil
+	}
+	var (
+		now       = time.Now()
+		threshold = pc.threshold(slotTime)
+		heads     []uint64 // of the synced nodes, highest first
+		events    []*StallEvent
+	)
+	for _, n := range nodes {
+		// Syncing nodes are behind by nature, they'd only skew the head
+		if n.Status() == NodeStatusSyncing {
+			continue
+		}
+		head := n.HeadNum()
+		heads = append(heads, head)
+		if _, ok := pc.reached[head]; !ok {
+			pc.reached[head] = now
+		}
+	}
+	sort.Slice(heads, func(i, j int) bool { return heads[i] > heads[j] })
+	var head uint64
+	if len(heads) > 0 {
+		head = heads[(len(heads)-1)/2]
+		// Only the numbers above the lowest head are needed to tell the lag
+		for num := range pc.reached {
+			if num <= heads[len(heads)-1] {
+				delete(pc.reached, num)
+			}
+		}
+	}
+	lags := make(map[string]*lagJson)
+	for _, n := range nodes {
+		lag := new(lagJson)
+		if n.HeadNum() < head {
+			lag.Blocks = head - n.HeadNum()
+			lag.Seconds = int64(now.Sub(pc.firstReached(n.HeadNum())) / time.Second)
+		}
+		lags[n.Name()] = lag
+		nodeGauge(pc.prefix+"lag", pc.namespace, n.Name()).Update(int64(lag.Blocks))
+		nodeGauge(pc.prefix+"lag/seconds", pc.namespace, n.Name()).Update(lag.Seconds)
+
+		// Any node checked can stall, but only the status of the healthy
+		// ones is changed: any other status takes precedence
+		var (
+			last    = n.LastProgress()
+			stalled = last > 0 && now.Sub(time.Unix(last, 0)) > threshold
+		)
+		if n.Status() == NodeStatusOK {
+			if stalled {
+				n.SetStatus(NodeStatusStalled)
+			} else if lag.Blocks > pc.lagThreshold {
+				n.SetStatus(NodeStatusLagging)
+			}
+		}
+		if stalled != pc.stalled[n.Name()] {
+			events = append(events, &StallEvent{
+				Node:         n.Name(),
+				Beacon:       len(pc.prefix) > 0,
+				Stalled:      stalled,
+				Head:         n.HeadNum(),
+				LastProgress: time.Unix(last, 0),
+				Threshold:    threshold.String(),
+			})
+		}
+		if stalled {
+			pc.stalled[n.Name()] = true
+			nodeGauge(pc.prefix+"stalled", pc.namespace, n.Name()).Update(1)
+		} else {
+			delete(pc.stalled, n.Name())
+			nodeGauge(pc.prefix+"stalled", pc.namespace, n.Name()).Update(0)
+		}
+	}
+	return lags, events
+}
+
+// forget drops the stall of the named node, which is no longer monitored.
+func (pc *progressChecker) forget(name string) {
+	if pc.stalled[name] {
+		delete(pc.stalled, name)
+		nodeGauge(pc.prefix+"stalled", pc.namespace, name).Update(0)
+	}
+}
+
+// firstReached returns when the first number above the given head was reached
+// by any of the nodes.
+func (pc *progressChecker) firstReached(head uint64) time.Time {
+	var (
+		first time.Time
+		num   uint64
+	)
+	for n, t := range pc.reached {
+		if n > head && (first.IsZero() || n < num) {
+			first, num = t, n
+		}
+	}
+	if first.IsZero() {
+		return time.Now()
+	}
+	return first
+}
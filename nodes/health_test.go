@@ -0,0 +1,247 @@
+package nodes
+
+import (
+	"context"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/eth"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// syncingCaller is a method caller which reports a sync status and peer count.
+type syncingCaller struct {
+	progress *ethereum.SyncProgress
+	peers    uint64
+}
+
+func (c *syncingCaller) HeaderByNumber(ctx context.Context, num *big.Int) (*types.Header, error) {
+	return &types.Header{Number: big.NewInt(100), Difficulty: common.Big0}, nil
+}
+
+func (c *syncingCaller) Version(ctx context.Context) (string, error) { return "Syncing/v1", nil }
+
+func (c *syncingCaller) GetBadBlocks(ctx context.Context) ([]*eth.BadBlockArgs, error) {
+	return nil, nil
+}
+
+func (c *syncingCaller) SyncProgress(ctx context.Context) (*ethereum.SyncProgress, error) {
+	return c.progress, nil
+}
+
+func (c *syncingCaller) PeerCount(ctx context.Context) (uint64, error) { return c.peers, nil }
+
+// statusNode is a test node which keeps its status and progress.
+type statusNode struct {
+	*testNode
+	status       int
+	lastProgress int64
+}
+
+func (n *statusNode) Status() int          { return n.status }
+func (n *statusNode) SetStatus(status int) { n.status = status }
+func (n *statusNode) LastProgress() int64  { return n.lastProgress }
+
+func TestSyncStatus(t *testing.T) {
+	caller := &syncingCaller{
+		progress: &ethereum.SyncProgress{CurrentBlock: 100, HighestBlock: 150},
+		peers:    3,
+	}
+	node := newRemoteNode("syncing", "", "", caller, nil, 0)
+	if err := node.UpdateLatest(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := nodeStatus(node), NodeStatusSyncing; have != want {
+		t.Errorf("wrong status, have %v, want %v", StatusName(have), StatusName(want))
+	}
+	// Done syncing, but lost the peers. The status is only polled periodically.
+	caller.progress, caller.peers = nil, 0
+	node.UpdateLatest(context.Background())
+	if have, want := nodeStatus(node), NodeStatusSyncing; have != want {
+		t.Errorf("wrong status, have %v, want %v", StatusName(have), StatusName(want))
+	}
+	node.lastCheck["eth_syncing"] = time.Time{}
+	node.UpdateLatest(context.Background())
+	if have, want := nodeStatus(node), NodeStatusNoPeers; have != want {
+		t.Errorf("wrong status, have %v, want %v", StatusName(have), StatusName(want))
+	}
+	caller.peers = 5
+	node.lastCheck["eth_syncing"] = time.Time{}
+	node.UpdateLatest(context.Background())
+	if have, want := nodeStatus(node), NodeStatusOK; have != want {
+		t.Errorf("wrong status, have %v, want %v", StatusName(have), StatusName(want))
+	}
+	if have, want := node.Peers(), 5; have != want {
+		t.Errorf("wrong peers, have %d, want %d", have, want)
+	}
+}
+
+func TestCheckProgress(t *testing.T) {
+	now := time.Now().Unix()
+	var (
+		ok      = &statusNode{testNode: newTestNode("ok", 1000, []uint64{0}, []int{0}), lastProgress: now}
+		lagging = &statusNode{testNode: newTestNode("lagging", 980, []uint64{0}, []int{0}), lastProgress: now}
+		stalled = &statusNode{testNode: newTestNode("stalled", 995, []uint64{0}, []int{0}), lastProgress: now - 3600}
+		syncing = &statusNode{testNode: newTestNode("syncing", 500, []uint64{0}, []int{0}), lastProgress: now, status: NodeStatusSyncing}
+	)
+	pc := newProgressChecker("", "")
+	lags, _ := pc.check([]Node{ok, lagging, stalled, syncing}, 0)
+	for _, tt := range []struct {
+		node *statusNode
+		want int
+	}{
+		{ok, NodeStatusOK},
+		{lagging, NodeStatusLagging},
+		{stalled, NodeStatusStalled},
+		{syncing, NodeStatusSyncing},
+	} {
+		if tt.node.status != tt.want {
+			t.Errorf("%v: wrong status, have %v, want %v", tt.node.Name(), StatusName(tt.node.status), StatusName(tt.want))
+		}
+	}
+	// The head is the one reached by half of the nodes, the syncing one aside
+	if have, want := lags[lagging.Name()].Blocks, uint64(15); have != want {
+		t.Errorf("wrong lag, have %d, want %d", have, want)
+	}
+	for _, n := range []Node{ok, stalled} {
+		if lag := lags[n.Name()]; lag.Blocks != 0 || lag.Seconds != 0 {
+			t.Errorf("wrong lag of node at the head: %+v", lag)
+		}
+	}
+	if have, want := lags[syncing.Name()].Blocks, uint64(495); have != want {
+		t.Errorf("wrong lag of syncing node, have %d, want %d", have, want)
+	}
+	// The lag in time counts from when the block after the node's head was
+	// first reached. The thresholds are configurable.
+	pc.reached[995] = time.Now().Add(-time.Minute)
+	pc.lagThreshold = 50
+	lagging.status = NodeStatusOK
+	lags, _ = pc.check([]Node{ok, lagging}, 0)
+	if have, want := lags[lagging.Name()].Seconds, int64(60); have != want {
+		t.Errorf("wrong lag in time, have %d, want %d", have, want)
+	}
+	if lagging.status != NodeStatusOK {
+		t.Errorf("node within the lag threshold marked %v", StatusName(lagging.status))
+	}
+	// A single node running ahead doesn't make the others lag
+	ahead := &statusNode{testNode: newTestNode("ahead", 1100, []uint64{0}, []int{0}), lastProgress: now}
+	other := &statusNode{testNode: newTestNode("other", 1000, []uint64{0}, []int{0}), lastProgress: now}
+	pc.lagThreshold = 10
+	ok.status = NodeStatusOK
+	lags, _ = pc.check([]Node{ok, other, ahead}, 0)
+	if lag := lags[ok.Name()]; lag.Blocks != 0 || ok.status != NodeStatusOK {
+		t.Errorf("node lagging behind a single node: %+v, %v", lag, StatusName(ok.status))
+	}
+}
+
+func TestCheckStall(t *testing.T) {
+	now := time.Now().Unix()
+	var (
+		ok   = &statusNode{testNode: newTestNode("ok", 1000, []uint64{0}, []int{0}), lastProgress: now}
+		slow = &statusNode{testNode: newTestNode("slow", 1000, []uint64{0}, []int{0}), lastProgress: now - 120}
+		pc   = newProgressChecker("", "")
+	)
+	// The threshold goes by the configured block time or threshold, else the
+	// slot time reported by the nodes, else the default block time
+	for i, tt := range []struct {
+		blockTime, threshold, slotTime time.Duration
+		stalled                        bool
+	}{
+		{0, 0, 0, false},
+		{0, 0, 2 * time.Second, true},
+		{12 * time.Second, 0, 2 * time.Second, false},
+		{12 * time.Second, time.Minute, 2 * time.Second, true},
+	} {
+		pc.blockTime, pc.stallThreshold = tt.blockTime, tt.threshold
+		ok.status, slow.status = NodeStatusOK, NodeStatusOK
+		pc.check([]Node{ok, slow}, tt.slotTime)
+		if have := slow.status == NodeStatusStalled; have != tt.stalled {
+			t.Errorf("test %d: wrong status %v", i, StatusName(slow.status))
+		}
+		if ok.status != NodeStatusOK {
+			t.Errorf("test %d: progressing node marked %v", i, StatusName(ok.status))
+		}
+	}
+	// An event is sent when a node stalls, and when it progresses again
+	pc.blockTime, pc.stallThreshold = 0, 0
+	ok.status, slow.status = NodeStatusOK, NodeStatusOK
+	if _, stalls := pc.check([]Node{ok, slow}, 0); len(stalls) != 1 || stalls[0].Node != slow.Name() || stalls[0].Stalled {
+		t.Errorf("wrong events on progress: %v", stalls)
+	}
+	ok.status, slow.status = NodeStatusOK, NodeStatusOK
+	_, stalls := pc.check([]Node{ok, slow}, 2*time.Second)
+	if len(stalls) != 1 || stalls[0].Node != slow.Name() || !stalls[0].Stalled {
+		t.Fatalf("wrong events on stall: %v", stalls)
+	}
+	if have, want := stalls[0].Threshold, "50s"; have != want {
+		t.Errorf("wrong threshold, have %v, want %v", have, want)
+	}
+	ok.status, slow.status = NodeStatusOK, NodeStatusOK
+	if _, stalls := pc.check([]Node{ok, slow}, 2*time.Second); len(stalls) != 0 {
+		t.Errorf("repeated stall events: %v", stalls)
+	}
+	// A node of another status stalls all the same, but keeps its status
+	ok.status, slow.status = NodeStatusOK, NodeStatusSyncing
+	delete(pc.stalled, slow.Name())
+	_, stalls = pc.check([]Node{ok, slow}, 2*time.Second)
+	if len(stalls) != 1 || !stalls[0].Stalled || slow.status != NodeStatusSyncing {
+		t.Errorf("wrong stall of syncing node: %v, %v", stalls, StatusName(slow.status))
+	}
+	// A node left out of a round, e.g. while unreachable, is still stalled
+	if _, stalls = pc.check([]Node{ok}, 2*time.Second); len(stalls) != 0 || !pc.stalled[slow.Name()] {
+		t.Errorf("stall of unchecked node changed: %v", stalls)
+	}
+	slow.status = NodeStatusSyncing
+	if _, stalls = pc.check([]Node{ok, slow}, 2*time.Second); len(stalls) != 0 {
+		t.Errorf("repeated stall events after the node returned: %v", stalls)
+	}
+	// Only the nodes removed from the monitor are forgotten
+	pc.forget(slow.Name())
+	if len(pc.stalled) != 0 {
+		t.Errorf("stall of removed node kept: %v", pc.stalled)
+	}
+}
+
+// waitReport starts the monitor, and waits for the report of the first round.
+func waitReport(t *testing.T, nm *NodeMonitor) *Report {
+	t.Helper()
+	nm.Start()
+	for i := 0; i < 500; i++ {
+		if r := nm.LastReport(); r != nil {
+			return r
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("no report after starting")
+	return nil
+}
+
+func TestStallThresholdFirstRound(t *testing.T) {
+	log.Root().SetHandler(log.LvlFilterHandler(
+		log.LvlCrit, log.StreamHandler(os.Stderr, log.TerminalFormat(false))))
+
+	// Disable the vuln check for tests
+	disableVulnCheck = true
+
+	now := time.Now().Unix()
+	var (
+		ok   = &statusNode{testNode: newTestNode("ok", 1000, []uint64{0}, []int{0}), lastProgress: now}
+		slow = &statusNode{testNode: newTestNode("slow", 1000, []uint64{0}, []int{0}), lastProgress: now - 120}
+	)
+	nm, err := NewMonitor([]Node{ok, slow}, nil, time.Hour, "Playdoh-net", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nm.Stop()
+	// Within the default threshold, but not the configured one
+	nm.SetStallThreshold(0, time.Minute)
+	waitReport(t, nm)
+	if slow.Status() != NodeStatusStalled {
+		t.Errorf("configured stall threshold not applied to the first round, status %v", StatusName(slow.Status()))
+	}
+}
@@ -0,0 +1,259 @@
+package nodes
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// History event kinds
+const (
+	EventReorg = "reorg" // a node reorged its chain
+	EventSplit = "split" // two nodes were on different chains
+)
+
+const (
+	maxMemoryHistory = 1000 // how many events are kept, without a database
+	maxHistoryQuery  = 1000 // how many events are returned by a query, at most
+)
+
+// The events are keyed by their inverted id, so that iterating the keys yields
+// the newest first
+var historyPrefix = []byte("history-")
+
+// HistoryEvent is an entry in the durable history of reorgs and splits.
+type HistoryEvent struct {
+	Id       uint64       `json:"id"`
+	Kind     string       `json:"kind"`
+	Chain    string       `json:"chain"`
+	Nodes    []string     `json:"nodes"`
+	Number   uint64       `json:"number"`            // the reorged block, or the first block of a split
+	Depth    int64        `json:"depth"`             // the blocks replaced by a reorg, or the longest split branch
+	OldHash  *common.Hash `json:"oldHash,omitempty"` // the reorged block
+	NewHash  *common.Hash `json:"newHash,omitempty"` // the block replacing it
+	Time     time.Time    `json:"time"`
+	Resolved *time.Time   `json:"resolved,omitempty"` // when a split was resolved
+}
+
+// historyQuery filters the events returned from the history.
+type historyQuery struct {
+	before uint64 // only events with a lower id, if non-zero
+	limit  int
+	kind   string // only events of this kind, if set
+	node   string // only events involving this node, if set
+}
+
+func (q *historyQuery) matches(ev *HistoryEvent) bool {
+	if len(q.kind) > 0 && ev.Kind != q.kind {
+		return false
+	}
+	if len(q.node) == 0 {
+		return true
+	}
+	for _, n := range ev.Nodes {
+		if n == q.node {
+			return true
+		}
+	}
+	return false
+}
+
+// history records the reorgs and splits observed by the monitor. The events
+// are stored in the block database, or kept in memory if there is none.
+type history struct {
+	db        *blockDB
+	mu        sync.Mutex
+	lastId    uint64
+	memory    []*HistoryEvent          // the events, if there is no database
+	splits    map[string]*HistoryEvent // the unresolved splits, by node pair
+	lastReorg map[string]time.Time     // the time of the last recorded reorg, by node
+}
+
+func newHistory(db *blockDB) *history {
+	h := &history{
+		db:        db,
+		splits:    make(map[string]*HistoryEvent),
+		lastReorg: make(map[string]time.Time),
+	}
+	// Pick up the splits which were unresolved at shutdown
+	h.iterate(0, func(ev *HistoryEvent) bool {
+		if h.lastId == 0 {
+			h.lastId = ev.Id
+		}
+		if ev.Kind == EventSplit && ev.Resolved == nil && len(ev.Nodes) == 2 {
+			h.splits[ev.Nodes[0]+"/"+ev.Nodes[1]] = ev
+		}
+		return true
+	}, maxMemoryHistory)
+	return h
+}
+
+// put stores the event, assigning it an id if it is new.
+func (h *history) put(ev *HistoryEvent) {
+	if ev.Id == 0 {
+		// Ids are timestamps, which also keeps the database sorted by time
+		ev.Id = uint64(ev.Time.UnixNano())
+		if ev.Id <= h.lastId {
+			ev.Id = h.lastId + 1
+		}
+		h.lastId = ev.Id
+		if h.db == nil {
+			h.memory = append(h.memory, ev)
+			if len(h.memory) > maxMemoryHistory {
+				h.memory = h.memory[len(h.memory)-maxMemoryHistory:]
+			}
+		}
+	}
+	if h.db != nil {
+		if err := h.db.putHistory(ev); err != nil {
+			log.Error("Failed to store history event", "kind", ev.Kind, "error", err)
+		}
+	}
+}
+
+// recordReorgs stores the reorgs which the nodes observed since the last call.
+func (h *history) recordReorgs(nodes []Node, chain string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, node := range nodes {
+		rr, ok := node.(reorgRecorder)
+		if !ok {
+			continue
+		}
+		name := node.Name()
+		for _, r := range rr.Reorgs() {
+			if !r.Time.After(h.lastReorg[name]) {
+				continue
+			}
+			h.lastReorg[name] = r.Time
+			oldHash, newHash := r.OldHash, r.NewHash
+			h.put(&HistoryEvent{
+				Kind:    EventReorg,
+				Chain:   chain,
+				Nodes:   []string{name},
+				Number:  r.Number,
+				Depth:   int64(r.Depth),
+				OldHash: &oldHash,
+				NewHash: &newHash,
+				Time:    r.Time,
+			})
+		}
+	}
+}
+
+// recordSplits stores the splits which appeared since the last call, and
+// resolves the ones which are gone.
+func (h *history) recordSplits(splits []*chainSplit, chain string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now()
+	current := make(map[string]bool)
+	for _, s := range splits {
+		key := s.a + "/" + s.b
+		current[key] = true
+		if ev, ok := h.splits[key]; ok {
+			if s.length > ev.Depth {
+				ev.Depth = s.length
+				h.put(ev)
+			}
+			continue
+		}
+		ev := &HistoryEvent{
+			Kind:   EventSplit,
+			Chain:  chain,
+			Nodes:  []string{s.a, s.b},
+			Number: s.num,
+			Depth:  s.length,
+			Time:   now,
+		}
+		h.splits[key] = ev
+		h.put(ev)
+	}
+	for key, ev := range h.splits {
+		if current[key] {
+			continue
+		}
+		delete(h.splits, key)
+		resolved := now
+		ev.Resolved = &resolved
+		h.put(ev)
+		log.Info("Split resolved", "nodes", key, "number", ev.Number, "duration", now.Sub(ev.Time).Round(time.Second))
+	}
+}
+
+// query returns the matching events, newest first.
+func (h *history) query(q historyQuery) []*HistoryEvent {
+	if q.limit <= 0 || q.limit > maxHistoryQuery {
+		q.limit = maxHistoryQuery
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	events := make([]*HistoryEvent, 0)
+	h.iterate(q.before, func(ev *HistoryEvent) bool {
+		if q.matches(ev) {
+			cpy := *ev
+			events = append(events, &cpy)
+		}
+		return len(events) < q.limit
+	}, -1)
+	return events
+}
+
+// iterate calls fn for the events with an id lower than 'before' (or all, if
+// zero), newest first, until fn returns false or 'max' events were visited.
+func (h *history) iterate(before uint64, fn func(ev *HistoryEvent) bool, max int) {
+	if h.db != nil {
+		h.db.iterateHistory(before, fn, max)
+		return
+	}
+	for i := len(h.memory) - 1; i >= 0 && max != 0; i-- {
+		if before != 0 && h.memory[i].Id >= before {
+			continue
+		}
+		max--
+		if !fn(h.memory[i]) {
+			return
+		}
+	}
+}
+
+func historyKey(id uint64) []byte {
+	key := make([]byte, len(historyPrefix)+8)
+	copy(key, historyPrefix)
+	binary.BigEndian.PutUint64(key[len(historyPrefix):], ^id)
+	return key
+}
+
+func (db *blockDB) putHistory(ev *HistoryEvent) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	return db.db.Put(historyKey(ev.Id), data)
+}
+
+func (db *blockDB) iterateHistory(before uint64, fn func(ev *HistoryEvent) bool, max int) {
+	var start []byte
+	if before != 0 {
+		start = historyKey(before - 1)[len(historyPrefix):]
+	}
+	it := db.db.NewIterator(historyPrefix, start)
+	defer it.Release()
+
+	for max != 0 && it.Next() {
+		var ev HistoryEvent
+		if err := json.Unmarshal(it.Value(), &ev); err != nil {
+			log.Error("Failed to decode history event", "key", fmt.Sprintf("%x", it.Key()), "error", err)
+			continue
+		}
+		max--
+		if !fn(&ev) {
+			return
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package nodes
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	neturl "net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// reorgingNode is a test node which reports reorgs.
+type reorgingNode struct {
+	*testNode
+	reorgs []*Reorg
+}
+
+func (n *reorgingNode) Reorgs() []*Reorg {
+	return n.reorgs
+}
+
+func TestHistory(t *testing.T) {
+	log.Root().SetHandler(log.LvlFilterHandler(
+		log.LvlCrit, log.StreamHandler(os.Stderr, log.TerminalFormat(false))))
+
+	// Disable the vuln check for tests
+	disableVulnCheck = true
+
+	db, err := openBlockDB(filepath.Join(t.TempDir(), "blockDB"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	canon := &reorgingNode{testNode: newTestNode("canon", 13_000_000, []uint64{0}, []int{0})}
+	fork := newTestNode("fork", 12_999_900, []uint64{0, 12_999_800}, []int{0, 1})
+	nm, err := NewMonitor([]Node{canon, fork}, db, time.Second, "Playdoh-net", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nm.doChecks()
+	// The node reorgs, it should be recorded once
+	canon.reorgs = append(canon.reorgs, &Reorg{
+		Node:    canon.Name(),
+		Number:  13_000_000,
+		OldHash: common.Hash{1},
+		NewHash: common.Hash{2},
+		Depth:   1,
+		Time:    time.Now(),
+	})
+	nm.doChecks()
+	nm.doChecks()
+
+	srv := httptest.NewServer(nm.Handler())
+	defer srv.Close()
+	query := func(params string) []*HistoryEvent {
+		t.Helper()
+		res, err := http.Get(srv.URL + APIPrefix + "history" + params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("wrong status code: %d", res.StatusCode)
+		}
+		var events []*HistoryEvent
+		if err := json.NewDecoder(res.Body).Decode(&events); err != nil {
+			t.Fatal(err)
+		}
+		return events
+	}
+	events := query("")
+	if len(events) != 2 {
+		t.Fatalf("wrong events, have %d, want 2", len(events))
+	}
+	// Newest first
+	if events[0].Kind != EventReorg || events[1].Kind != EventSplit {
+		t.Fatalf("wrong events: %v, %v", events[0].Kind, events[1].Kind)
+	}
+	if have, want := *events[0].NewHash, (common.Hash{2}); have != want {
+		t.Errorf("wrong reorg hash, have %x, want %x", have, want)
+	}
+	if have, want := events[1].Number, uint64(12_999_800); have != want {
+		t.Errorf("wrong split number, have %d, want %d", have, want)
+	}
+	if events[1].Resolved != nil {
+		t.Errorf("split resolved too early")
+	}
+	// Filters and paging
+	if have := len(query("?kind=split")); have != 1 {
+		t.Errorf("wrong split events, have %d, want 1", have)
+	}
+	if have := len(query("?node=" + neturl.QueryEscape(fork.Name()))); have != 1 {
+		t.Errorf("wrong events for node, have %d, want 1", have)
+	}
+	if have := query("?limit=1"); len(have) != 1 || have[0].Kind != EventReorg {
+		t.Errorf("wrong limited events")
+	}
+	if have := query("?before=" + strconv.FormatUint(events[0].Id, 10)); len(have) != 1 || have[0].Kind != EventSplit {
+		t.Errorf("wrong page of events")
+	}
+	// Restarting the monitor keeps the unresolved split
+	nm, err = NewMonitor([]Node{canon, fork}, db, time.Second, "Playdoh-net", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nm.doChecks()
+	if len(nm.history.splits) != 1 {
+		t.Fatalf("unresolved split not restored")
+	}
+	// Removing the forked node resolves the split
+	nm.Reconfigure([]Node{canon}, time.Second, "Playdoh-net")
+	nm.doChecks()
+	events = nm.history.query(historyQuery{kind: EventSplit})
+	if len(events) != 1 {
+		t.Fatalf("wrong split events, have %d, want 1", len(events))
+	}
+	if events[0].Resolved == nil {
+		t.Errorf("split not resolved")
+	}
+}
@@ -0,0 +1,99 @@
+package nodes
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// Signature algorithms of minisign: plain ed25519, or ed25519 over the
+// blake2b-512 hash of the data (for large files).
+var (
+	minisignAlgPlain    = []byte("Ed")
+	minisignAlgHashed   = []byte("ED")
+	errSignatureInvalid = errors.New("signature could not be verified")
+)
+
+// minisignKey is a minisign public key.
+type minisignKey struct {
+	id  [8]byte
+	key ed25519.PublicKey
+}
+
+// parseMinisignKey parses a minisign public key, either the base64 encoded key
+// alone, or the contents of a key file (with the untrusted comment).
+func parseMinisignKey(s string) (*minisignKey, error) {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[len(lines)-1]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %v", err)
+	}
+	if len(data) != 2+8+ed25519.PublicKeySize || !bytes.Equal(data[:2], minisignAlgPlain) {
+		return nil, errors.New("invalid public key: not a minisign ed25519 key")
+	}
+	k := &minisignKey{key: ed25519.PublicKey(data[10:])}
+	copy(k.id[:], data[2:10])
+	return k, nil
+}
+
+// ID returns the key id, as printed by minisign (in reverse byte order).
+func (k *minisignKey) ID() string {
+	var rev [8]byte
+	for i := range k.id {
+		rev[len(rev)-1-i] = k.id[i]
+	}
+	return fmt.Sprintf("%X", rev)
+}
+
+// verifyMinisign checks that sig is a valid minisign signature of the data, by
+// one of the given keys. The trusted comment is covered by the signature too.
+func verifyMinisign(keys []*minisignKey, data, sig []byte) error {
+	lines := strings.Split(strings.TrimSpace(string(sig)), "\n")
+	if len(lines) != 4 {
+		return errors.New("invalid signature: expected 4 lines")
+	}
+	sigData, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil || len(sigData) != 2+8+ed25519.SignatureSize {
+		return errors.New("invalid signature encoding")
+	}
+	comment := strings.TrimRight(lines[2], "\r")
+	if !strings.HasPrefix(comment, "trusted comment: ") {
+		return errors.New("invalid signature: missing trusted comment")
+	}
+	comment = strings.TrimPrefix(comment, "trusted comment: ")
+	globalSig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	if err != nil || len(globalSig) != ed25519.SignatureSize {
+		return errors.New("invalid global signature encoding")
+	}
+	var key *minisignKey
+	for _, k := range keys {
+		if bytes.Equal(k.id[:], sigData[2:10]) {
+			key = k
+			break
+		}
+	}
+	if key == nil {
+		return errors.New("signing key not trusted")
+	}
+	msg := data
+	switch alg := sigData[:2]; {
+	case bytes.Equal(alg, minisignAlgHashed):
+		h := blake2b.Sum512(data)
+		msg = h[:]
+	case !bytes.Equal(alg, minisignAlgPlain):
+		return fmt.Errorf("unsupported signature algorithm %q", alg)
+	}
+	if !ed25519.Verify(key.key, msg, sigData[10:]) {
+		return errSignatureInvalid
+	}
+	signed := append(append([]byte{}, sigData[10:]...), comment...)
+	if !ed25519.Verify(key.key, signed, globalSig) {
+		return errSignatureInvalid
+	}
+	return nil
+}
@@ -0,0 +1,325 @@
+package nodes
+
+import (
+	"context"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/eth"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// mockChain is a programmable chain, served by a mockServer over json-rpc. The
+// chain can be extended and reorged, and the methods can be made slow or fail.
+type mockChain struct {
+	mu        sync.Mutex
+	chain     []*types.Header
+	version   string
+	chainID   *big.Int
+	syncing   bool
+	peers     uint64
+	badBlocks []*eth.BadBlockArgs
+	latency   time.Duration    // of every request
+	errs      map[string]error // injected errors, by method
+	notify    []*rpc.Notifier
+	subs      []*rpc.Subscription
+	subbing   chan struct{} // receives when a subscription is made
+}
+
+// newMockChain creates a chain of the given length.
+func newMockChain(length int) *mockChain {
+	m := &mockChain{
+		version: "Mock/v1.0.0-stable/linux-amd64/go1.20.2",
+		chainID: big.NewInt(1337),
+		peers:   25,
+		errs:    make(map[string]error),
+		subbing: make(chan struct{}, 10),
+	}
+	m.extend(length, 0)
+	return m
+}
+
+// extend adds blocks to the chain, with the fork id making them unique.
+func (m *mockChain) extend(n int, fork byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := 0; i < n; i++ {
+		h := &types.Header{
+			Number:     big.NewInt(int64(len(m.chain))),
+			Difficulty: common.Big0,
+			Extra:      []byte{fork},
+		}
+		if len(m.chain) > 0 {
+			h.ParentHash = m.chain[len(m.chain)-1].Hash()
+		}
+		m.chain = append(m.chain, h)
+	}
+}
+
+// rewind drops the blocks above the given number.
+func (m *mockChain) rewind(num int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.chain = m.chain[:num+1]
+}
+
+// reorg replaces the given number of blocks at the head with a fork, of the
+// same length.
+func (m *mockChain) reorg(depth int, fork byte) {
+	m.rewind(m.head() - depth)
+	m.extend(depth, fork)
+}
+
+// head returns the number of the head block.
+func (m *mockChain) head() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.chain) - 1
+}
+
+// hash returns the hash of the block with the given number.
+func (m *mockChain) hash(num int) common.Hash {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.chain[num].Hash()
+}
+
+// addBadBlock adds a bad block, following the block with the given number.
+func (m *mockChain) addBadBlock(num int) *eth.BadBlockArgs {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h := &types.Header{
+		ParentHash: m.chain[num].Hash(),
+		Number:     big.NewInt(int64(num + 1)),
+		Difficulty: common.Big0,
+		Extra:      []byte("bad"),
+	}
+	bl := types.NewBlock(h, nil, nil, nil, trie.NewStackTrie(nil))
+	enc, err := rlp.EncodeToBytes(bl)
+	if err != nil {
+		panic(err)
+	}
+	args := &eth.BadBlockArgs{Hash: bl.Hash(), RLP: hexutil.Encode(enc)}
+	m.badBlocks = append(m.badBlocks, args)
+	return args
+}
+
+// setError makes the given method fail with err, or succeed again if nil.
+func (m *mockChain) setError(method string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err == nil {
+		delete(m.errs, method)
+	} else {
+		m.errs[method] = err
+	}
+}
+
+// setLatency delays the responses to all requests.
+func (m *mockChain) setLatency(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.latency = d
+}
+
+// setSyncing sets the sync status and peer count.
+func (m *mockChain) setSyncing(syncing bool, peers uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.syncing, m.peers = syncing, peers
+}
+
+// announce pushes the head to the subscribers.
+func (m *mockChain) announce() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	head := m.chain[len(m.chain)-1]
+	for i, n := range m.notify {
+		n.Notify(m.subs[i].ID, head)
+	}
+}
+
+// call is done at the start of each request: it applies the latency, and
+// returns the injected error of the method, if any. The chain is locked on
+// success, the caller must unlock it.
+func (m *mockChain) call(ctx context.Context, method string) error {
+	m.mu.Lock()
+	latency, err := m.latency, m.errs[method]
+	m.mu.Unlock()
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	return nil
+}
+
+// mockEth is the eth namespace of the mock chain.
+type mockEth struct{ m *mockChain }
+
+func (s mockEth) GetBlockByNumber(ctx context.Context, num rpc.BlockNumber, full bool) (*types.Header, error) {
+	if err := s.m.call(ctx, "eth_getBlockByNumber"); err != nil {
+		return nil, err
+	}
+	defer s.m.mu.Unlock()
+	head := rpc.BlockNumber(len(s.m.chain) - 1)
+	switch num {
+	case rpc.LatestBlockNumber, rpc.PendingBlockNumber:
+		num = head
+	case rpc.FinalizedBlockNumber:
+		num = head - 64
+	case rpc.SafeBlockNumber:
+		num = head - 32
+	case rpc.EarliestBlockNumber:
+		num = 0
+	}
+	if num < 0 || num > head {
+		return nil, nil
+	}
+	return s.m.chain[num], nil
+}
+
+func (s mockEth) ChainId(ctx context.Context) (*hexutil.Big, error) {
+	if err := s.m.call(ctx, "eth_chainId"); err != nil {
+		return nil, err
+	}
+	defer s.m.mu.Unlock()
+	return (*hexutil.Big)(s.m.chainID), nil
+}
+
+func (s mockEth) Syncing(ctx context.Context) (interface{}, error) {
+	if err := s.m.call(ctx, "eth_syncing"); err != nil {
+		return nil, err
+	}
+	defer s.m.mu.Unlock()
+	if !s.m.syncing {
+		return false, nil
+	}
+	head := hexutil.Uint64(len(s.m.chain) - 1)
+	return map[string]interface{}{
+		"startingBlock": hexutil.Uint64(0),
+		"currentBlock":  head,
+		"highestBlock":  head + 100,
+	}, nil
+}
+
+func (s mockEth) NewHeads(ctx context.Context) (*rpc.Subscription, error) {
+	notifier, ok := rpc.NotifierFromContext(ctx)
+	if !ok {
+		return nil, rpc.ErrNotificationsUnsupported
+	}
+	if err := s.m.call(ctx, "eth_subscribe"); err != nil {
+		return nil, err
+	}
+	sub := notifier.CreateSubscription()
+	s.m.notify = append(s.m.notify, notifier)
+	s.m.subs = append(s.m.subs, sub)
+	s.m.mu.Unlock()
+	s.m.subbing <- struct{}{}
+	return sub, nil
+}
+
+// mockNet is the net namespace of the mock chain.
+type mockNet struct{ m *mockChain }
+
+func (s mockNet) PeerCount(ctx context.Context) (hexutil.Uint64, error) {
+	if err := s.m.call(ctx, "net_peerCount"); err != nil {
+		return 0, err
+	}
+	defer s.m.mu.Unlock()
+	return hexutil.Uint64(s.m.peers), nil
+}
+
+// mockWeb3 is the web3 namespace of the mock chain.
+type mockWeb3 struct{ m *mockChain }
+
+func (s mockWeb3) ClientVersion(ctx context.Context) (string, error) {
+	if err := s.m.call(ctx, "web3_clientVersion"); err != nil {
+		return "", err
+	}
+	defer s.m.mu.Unlock()
+	return s.m.version, nil
+}
+
+// mockDebug is the debug namespace of the mock chain.
+type mockDebug struct{ m *mockChain }
+
+func (s mockDebug) GetBadBlocks(ctx context.Context) ([]*eth.BadBlockArgs, error) {
+	if err := s.m.call(ctx, "debug_getBadBlocks"); err != nil {
+		return nil, err
+	}
+	defer s.m.mu.Unlock()
+	return append([]*eth.BadBlockArgs{}, s.m.badBlocks...), nil
+}
+
+// mockServer serves a mock chain over http and websocket.
+type mockServer struct {
+	*httptest.Server
+	chain  *mockChain
+	mu     sync.Mutex
+	server *rpc.Server
+}
+
+func newMockServer(chain *mockChain) *mockServer {
+	s := &mockServer{chain: chain}
+	s.server = s.newRPCServer()
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		server := s.server
+		s.mu.Unlock()
+		if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+			server.WebsocketHandler([]string{"*"}).ServeHTTP(w, r)
+			return
+		}
+		server.ServeHTTP(w, r)
+	}))
+	return s
+}
+
+func (s *mockServer) newRPCServer() *rpc.Server {
+	server := rpc.NewServer()
+	server.RegisterName("eth", mockEth{s.chain})
+	server.RegisterName("net", mockNet{s.chain})
+	server.RegisterName("web3", mockWeb3{s.chain})
+	server.RegisterName("debug", mockDebug{s.chain})
+	return server
+}
+
+// wsURL returns the websocket url of the server.
+func (s *mockServer) wsURL() string {
+	return "ws" + strings.TrimPrefix(s.URL, "http")
+}
+
+// restart drops the connections, including the subscriptions.
+func (s *mockServer) restart() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.server.Stop()
+	s.server = s.newRPCServer()
+	s.chain.mu.Lock()
+	s.chain.notify, s.chain.subs = nil, nil
+	s.chain.mu.Unlock()
+}
+
+// Close shuts down the server.
+func (s *mockServer) Close() {
+	s.mu.Lock()
+	s.server.Stop()
+	s.mu.Unlock()
+	s.Server.Close()
+}
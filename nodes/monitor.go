@@ -1,75 +1,247 @@
 package nodes
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"io/ioutil"
-	"os"
-	"path/filepath"
-	"regexp"
+	"io"
 	"runtime"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/event"
 	"github.com/ethereum/go-ethereum/log"
-	"github.com/ethereum/go-ethereum/metrics"
 	"github.com/ethereum/go-ethereum/rlp"
-	"github.com/syndtr/goleveldb/leveldb"
-	"github.com/syndtr/goleveldb/leveldb/errors"
-	"github.com/syndtr/goleveldb/leveldb/opt"
 )
 
+// roundTimeout is how long a round of checks may take, before the remaining
+// requests to the nodes are cancelled.
+const roundTimeout = time.Minute
+
+// pruneInterval is how often the headers beyond the retention are pruned.
+const pruneInterval = 10 * time.Minute
+
 // NodeMonitor monitors a set of nodes, and performs checks on them
 type NodeMonitor struct {
 	nodes           []Node
+	removed         []Node       // nodes removed by a reconfiguration, to be closed
+	mu              sync.RWMutex // protects nodes, removed, reloadInterval, chainName, badBlocks, lastReport, votes and the progress thresholds
 	badBlocks       map[common.Hash]*badBlockJson
 	quitCh          chan struct{}
 	backend         *blockDB
 	wg              sync.WaitGroup
 	reloadInterval  time.Duration
 	lastClean       time.Time
+	pruning         int32 // set while the headers are pruned in the background
 	lastBadBlocks   time.Time
 	forkHeightCache []int
+	beaconCache     []int // forkHeightCache for the beacon nodes
+	progress        *progressChecker
+	beaconProgress  *progressChecker // progress for the beacon nodes
+	votes           map[string]Vote  // the weights of the nodes in the consensus, by name
 	chainName       string
-	// used for testing
-	lastReport *Report
+	namespace       string // labels the metrics, empty when monitoring a single chain
+	lastReport      *Report
+	reportFeed      event.Feed
+	stallFeed       event.Feed
+	alerts          *alerter
+	history         *history
+	chains          *chainVerifier
+	triage          *triager
 }
 
-// NewMonitor creates a new NodeMonitor
-func NewMonitor(nodes []Node, db *blockDB, reload time.Duration, chainName string) (*NodeMonitor, error) {
+// NewMonitor creates a new NodeMonitor. The namespace labels the metrics of
+// the monitor, and is empty when monitoring a single chain. The first round of
+// checks is done once started, so that the settings made in between apply.
+func NewMonitor(nodes []Node, db *blockDB, reload time.Duration, chainName, namespace string) (*NodeMonitor, error) {
 	// Do initial healthcheck
 	for _, node := range nodes {
-		log.Info("Checking health", "node", node.Name())
-		v, err := node.Version()
-		if err != nil {
-			node.SetStatus(NodeStatusUnreachable)
-			log.Error("Error checking version", "error", err)
-		} else {
-			node.SetStatus(NodeStatusOK)
-		}
-		log.Info("RemoteNode OK", "version", v)
+		checkHealth(context.Background(), node)
 	}
 	if reload == 0 {
 		reload = 10 * time.Second
 	}
 
+	alerts, _ := newAlerter(alertsConfig{})
 	nm := &NodeMonitor{
+		alerts:         alerts,
+		history:        newHistory(db),
+		chains:         newChainVerifier(),
+		triage:         newTriager(db),
+		progress:       newProgressChecker(namespace, ""),
+		beaconProgress: newProgressChecker(namespace, "beacon/"),
 		nodes:          nodes,
 		badBlocks:      make(map[common.Hash]*badBlockJson),
 		quitCh:         make(chan struct{}),
 		backend:        db,
 		reloadInterval: reload,
 		chainName:      chainName,
+		namespace:      namespace,
 	}
-
-	nm.doChecks()
 	return nm, nil
 }
 
+func checkHealth(ctx context.Context, node Node) {
+	log.Info("Checking health", "node", node.Name())
+	v, err := node.Version(ctx)
+	if err != nil {
+		node.SetStatus(NodeStatusUnreachable)
+		log.Error("Error checking version", "error", err)
+	} else {
+		node.SetStatus(NodeStatusOK)
+	}
+	log.Info("RemoteNode OK", "version", v)
+}
+
+// Reconfigure replaces the set of monitored nodes and the monitor settings.
+// Nodes which were already part of the monitor are kept as is, along with
+// their cached chain data, whereas new nodes are health-checked first.
+// The changes take effect from the next round of checks.
+func (mon *NodeMonitor) Reconfigure(nodes []Node, reload time.Duration, chainName string) {
+	known := make(map[Node]bool)
+	for _, node := range mon.getNodes() {
+		known[node] = true
+	}
+	for _, node := range nodes {
+		if !known[node] {
+			checkHealth(context.Background(), node)
+		}
+	}
+	if reload == 0 {
+		reload = 10 * time.Second
+	}
+	var (
+		kept  = make(map[Node]bool)
+		names = make(map[string]bool)
+	)
+	for _, node := range nodes {
+		kept[node] = true
+		names[node.Name()] = true
+	}
+	mon.mu.Lock()
+	defer mon.mu.Unlock()
+	// The nodes which were removed may still be in use by a round of checks,
+	// they are released once it is over
+	for _, node := range mon.nodes {
+		if !kept[node] {
+			mon.removed = append(mon.removed, node)
+		}
+		if !names[node.Name()] {
+			mon.progress.forget(node.Name())
+			mon.beaconProgress.forget(node.Name())
+		}
+	}
+	mon.nodes = nodes
+	mon.reloadInterval = reload
+	mon.chainName = chainName
+}
+
+// closeRemoved releases the nodes removed by a reconfiguration. It must not be
+// called during a round of checks.
+func (mon *NodeMonitor) closeRemoved() {
+	mon.mu.Lock()
+	removed := mon.removed
+	mon.removed = nil
+	mon.mu.Unlock()
+	for _, node := range removed {
+		CloseNode(node)
+	}
+}
+
+// CloseNode releases the resources held by the node, if any.
+func CloseNode(node Node) {
+	if c, ok := node.(io.Closer); ok {
+		c.Close()
+	}
+}
+
+// getNodes returns the currently monitored nodes.
+func (mon *NodeMonitor) getNodes() []Node {
+	mon.mu.RLock()
+	defer mon.mu.RUnlock()
+	return mon.nodes
+}
+
+func (mon *NodeMonitor) getReloadInterval() time.Duration {
+	mon.mu.RLock()
+	defer mon.mu.RUnlock()
+	return mon.reloadInterval
+}
+
+func (mon *NodeMonitor) getChainName() string {
+	mon.mu.RLock()
+	defer mon.mu.RUnlock()
+	return mon.chainName
+}
+
+// SetLagThreshold sets how many blocks a node may be behind the head of the
+// nodes, before it is considered lagging. Zero means the default.
+// The change takes effect from the next round of checks.
+func (mon *NodeMonitor) SetLagThreshold(blocks uint64) {
+	if blocks == 0 {
+		blocks = DefaultLagThreshold
+	}
+	mon.mu.Lock()
+	defer mon.mu.Unlock()
+	mon.progress.lagThreshold = blocks
+	mon.beaconProgress.lagThreshold = blocks
+}
+
+// SetStallThreshold sets the expected block time of the chain, and how long a
+// node may go without progressing the chain before it is considered stalled.
+// A zero block time means the slot time reported by the nodes, or else the
+// default, and a zero threshold means 25 times the block time. The change takes
+// effect from the next round of checks.
+func (mon *NodeMonitor) SetStallThreshold(blockTime, threshold time.Duration) {
+	mon.mu.Lock()
+	defer mon.mu.Unlock()
+	mon.progress.blockTime, mon.progress.stallThreshold = blockTime, threshold
+	mon.beaconProgress.blockTime, mon.beaconProgress.stallThreshold = blockTime, threshold
+}
+
+// SetVotes sets how much the nodes count towards the canonical chain, by name.
+// Nodes without a vote have a weight of 1. The change takes effect from the
+// next round of checks.
+func (mon *NodeMonitor) SetVotes(votes map[string]Vote) {
+	mon.mu.Lock()
+	defer mon.mu.Unlock()
+	mon.votes = votes
+}
+
+func (mon *NodeMonitor) getVotes() map[string]Vote {
+	mon.mu.RLock()
+	defer mon.mu.RUnlock()
+	return mon.votes
+}
+
+// EnableAlerts configures the alert thresholds and webhooks. It must be called
+// before Start.
+func (mon *NodeMonitor) EnableAlerts(conf alertsConfig) error {
+	alerts, err := newAlerter(conf)
+	if err != nil {
+		return err
+	}
+	mon.alerts = alerts
+	return nil
+}
+
+// SubscribeAlerts subscribes the given channel to alerts being fired and resolved.
+func (mon *NodeMonitor) SubscribeAlerts(ch chan<- *Alert) event.Subscription {
+	return mon.alerts.feed.Subscribe(ch)
+}
+
+// SubscribeStalls subscribes the given channel to nodes stalling, and
+// progressing again.
+func (mon *NodeMonitor) SubscribeStalls(ch chan<- *StallEvent) event.Subscription {
+	return mon.stallFeed.Subscribe(ch)
+}
+
 func (mon *NodeMonitor) Start() {
+	mon.alerts.start(&mon.wg, mon.quitCh)
+	mon.triage.start(&mon.wg, mon.quitCh)
 	mon.wg.Add(1)
 	go mon.loop()
 }
@@ -77,43 +249,84 @@ func (mon *NodeMonitor) Start() {
 func (mon *NodeMonitor) Stop() {
 	close(mon.quitCh)
 	mon.wg.Wait()
+	mon.closeRemoved()
+	for _, node := range mon.getNodes() {
+		CloseNode(node)
+	}
 }
 
 func (mon *NodeMonitor) loop() {
 	defer mon.wg.Done()
+
+	// The first round goes ahead right away, with the settings made since the
+	// monitor was created
+	mon.doChecks()
 	for {
 		select {
 		case <-mon.quitCh:
 			return
-		case <-time.After(mon.reloadInterval):
+		case <-time.After(mon.getReloadInterval()):
 			mon.doChecks()
+			mon.closeRemoved()
 		}
 	}
 }
 
+// quitContext returns a context which is cancelled when quitCh is closed.
+func quitContext(quitCh chan struct{}) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-quitCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
 func (mon *NodeMonitor) doChecks() {
 	var activeNodes []Node
 
+	// The requests made during the round are cancelled if the monitor is
+	// stopped, or if the round takes too long
+	quitCtx, quit := quitContext(mon.quitCh)
+	defer quit()
+	ctx, cancel := context.WithTimeout(quitCtx, roundTimeout)
+	defer cancel()
+
+	nodes := mon.getNodes()
 	doneCh := make(chan Node)
-	for _, node := range mon.nodes {
+	for _, node := range nodes {
 		go func(node Node) {
 			defer func() {
 				doneCh <- node
 			}()
-			err := node.UpdateLatest()
-			v, _ := node.Version()
+			err := node.UpdateLatest(ctx)
+			v, _ := node.Version(ctx)
 			if err != nil {
 				log.Error("Error getting latest", "node", v, "error", err)
 				node.SetStatus(NodeStatusUnreachable)
 				return
 			}
-			node.SetStatus(NodeStatusOK)
+			node.SetStatus(nodeStatus(node))
+			mon.chains.refresh(ctx, node)
 		}(node)
 	}
 	// Wait for them to report back
-	for i := 0; i < len(mon.nodes); i++ {
-		node := <-doneCh
-		if node.Status() == NodeStatusUnreachable {
+	for i := 0; i < len(nodes); i++ {
+		<-doneCh
+	}
+	// Nodes on the wrong chain are left out of the checks
+	wrongChain := mon.chains.verify(nodes, mon.getChainName())
+	wrongChainErrs := make(map[string]error)
+	for node, err := range wrongChain {
+		log.Debug("Node on wrong chain", "node", node.Name(), "error", err)
+		node.SetStatus(NodeStatusWrongChain)
+		wrongChainErrs[node.Name()] = err
+	}
+	for _, node := range nodes {
+		if status := node.Status(); status == NodeStatusUnreachable || status == NodeStatusWrongChain {
 			continue
 		}
 		activeNodes = append(activeNodes, node)
@@ -122,64 +335,143 @@ func (mon *NodeMonitor) doChecks() {
 	sort.Slice(activeNodes, func(i, j int) bool {
 		return activeNodes[i].Name() < activeNodes[j].Name()
 	})
+	// Beacon nodes are cross-checked among themselves, and reported separately
+	var activeBeacons []Node
+	activeNodes, activeBeacons = splitBeacons(activeNodes)
+	// The stall threshold goes by the slot time of the chain, as far as any of
+	// the nodes can tell
+	slotTime := chainSlotTime(append(activeBeacons[:len(activeBeacons):len(activeBeacons)], activeNodes...))
+	mon.mu.Lock()
+	lags, stalls := mon.progress.check(activeNodes, slotTime)
+	beaconLags, beaconStalls := mon.beaconProgress.check(activeBeacons, slotTime)
+	mon.mu.Unlock()
+	for _, ev := range append(stalls, beaconStalls...) {
+		ev.Chain = mon.getChainName()
+		if ev.Stalled {
+			log.Warn("Node stalled", "node", ev.Node, "head", ev.Head, "since", ev.LastProgress, "threshold", ev.Threshold)
+		} else {
+			log.Info("Node progressing again", "node", ev.Node, "head", ev.Head)
+		}
+		mon.stallFeed.Send(ev)
+	}
+	for _, n := range nodes {
+		nodeGauge("status", mon.namespace, n.Name()).Update(int64(n.Status()))
+	}
 
 	// Pair-wise, figure out the splitblocks (if any)
-	heads := mon.findSplits(activeNodes)
-	var headList []int
-	for k := range heads {
-		headList = append(headList, int(k))
-	}
-	sort.Sort(sort.Reverse(sort.IntSlice(headList)))
-	// cache headlist for next round
-	mon.forkHeightCache = headList
+	headList, splits := mon.interestingNumbers(ctx, activeNodes, &mon.forkHeightCache, "chain/split")
 
 	// create a new report
-	r := NewReport(headList, mon.chainName)
-	for _, n := range mon.nodes {
+	r := NewReport(headList, mon.getChainName())
+	r.Splits = mon.describeSplits(ctx, splits, activeNodes)
+	if _, beacons := splitBeacons(nodes); len(beacons) > 0 {
+		beaconList, beaconSplits := mon.interestingNumbers(ctx, activeBeacons, &mon.beaconCache, "beacon/split")
+		r.Beacon = NewReport(beaconList, r.Chain)
+		r.Beacon.Splits = mon.describeSplits(ctx, beaconSplits, activeBeacons)
+		splits = append(splits, beaconSplits...)
+	}
+	for _, n := range nodes {
 		// check vulnerability reports
-		vuln, err := checkNode(n)
+		vuln, err := checkNode(ctx, n)
 		if err != nil {
 			log.Info("Error while checking for vulnerabilities", "error", err)
 		}
-		r.AddToReport(n, vuln)
+		if _, ok := n.(*BeaconNode); ok {
+			r.Beacon.AddToReport(ctx, n, vuln)
+		} else {
+			r.AddToReport(ctx, n, vuln)
+		}
+	}
+	r.markWrongChain(wrongChainErrs)
+	r.markLag(lags)
+	if r.Beacon != nil {
+		r.Beacon.markLag(beaconLags)
 	}
-	// Update bad blocks
-	mon.checkBadBlocks()
-	r.addBadBlocks(mon.badBlocks)
 
-	if mon.backend == nil {
-		// if there's no backend, this is probably a test.
-		// Just set it and return
-		mon.lastReport = r
-		return
+	// The canonical chain is decided by weighted majority, per layer
+	votes := mon.getVotes()
+	r.markConsensus(canonicalChain(ctx, activeNodes, r.Numbers, votes))
+	mon.reportMinority(r, "")
+	if r.Beacon != nil {
+		r.Beacon.markConsensus(canonicalChain(ctx, activeBeacons, r.Beacon.Numbers, votes))
+		mon.reportMinority(r.Beacon, "beacon/")
 	}
-	jsd, err := json.MarshalIndent(r, "", "  ")
-	if err != nil {
-		log.Warn("Json marshall fail", "error", err)
-		return
+
+	// Record the reorgs and splits in the history
+	mon.history.recordReorgs(nodes, r.Chain)
+	mon.history.recordSplits(splits, r.Chain)
+
+	// Disagreement on finality is checked by majority vote, per layer
+	mismatches := finalizedMismatches(ctx, activeNodes)
+	r.markFinalizedMismatch(mismatches)
+	if r.Beacon != nil {
+		beaconMismatches := finalizedMismatches(ctx, activeBeacons)
+		r.Beacon.markFinalizedMismatch(beaconMismatches)
+		mismatches = append(mismatches, beaconMismatches...)
 	}
-	if err := ioutil.WriteFile("www/data.json", jsd, 0777); err != nil {
-		log.Warn("Failed to write file", "error", err)
-		return
+	chainGauge("finalized/mismatch", mon.namespace).Update(int64(len(mismatches)))
+
+	// Update bad blocks
+	mon.checkBadBlocks(ctx, nodes)
+	mon.mu.Lock()
+	r.addBadBlocks(mon.badBlocks)
+	mon.mu.Unlock()
+	r.Alerts = mon.alerts.update(r, splits)
+	mon.prune(nodes)
+
+	mon.mu.Lock()
+	mon.lastReport = r
+	mon.mu.Unlock()
+
+	mon.reportFeed.Send(r)
+}
+
+// reportMinority updates the metrics of the nodes on a minority fork. The
+// prefix is of the metric names, for the beacon nodes.
+func (mon *NodeMonitor) reportMinority(r *Report, prefix string) {
+	for _, c := range r.Cols {
+		var minority int64
+		if c.Minority {
+			minority = 1
+		}
+		nodeGauge(prefix+"minority", mon.namespace, c.Name).Update(minority)
 	}
-	mon.provideHashes(r)
-	mon.provideBadBlocks()
-	mon.provideVulns()
+	chainGauge(prefix+"consensus/minority", mon.namespace).Update(int64(len(r.Minority)))
 }
 
-func (mon *NodeMonitor) checkBadBlocks() {
+// SubscribeReports subscribes the given channel to the reports created
+// at the end of each round of checks. The reports must not be modified.
+func (mon *NodeMonitor) SubscribeReports(ch chan<- *Report) event.Subscription {
+	return mon.reportFeed.Subscribe(ch)
+}
+
+// LastReport returns the report from the latest round of checks.
+func (mon *NodeMonitor) LastReport() *Report {
+	mon.mu.RLock()
+	defer mon.mu.RUnlock()
+	return mon.lastReport
+}
+
+func (mon *NodeMonitor) checkBadBlocks(ctx context.Context, nodes []Node) {
 	if time.Since(mon.lastBadBlocks) < time.Minute {
 		return
 	}
 	mon.lastBadBlocks = time.Now()
-	for _, node := range mon.nodes {
-		blocks := getBadBlocks(node)
+	for _, node := range nodes {
+		blocks := getBadBlocks(ctx, node)
+		mon.mu.Lock()
 		for i, _ := range blocks {
 			hash := blocks[i].Hash
 			info := mon.badBlocks[hash]
 			if info == nil {
 				mon.badBlocks[hash] = blocks[i]
 				log.Info("Added (new) bad block", "hash", hash)
+				mon.triage.enqueue(&triageRequest{
+					hash:     hash,
+					number:   blocks[i].Number,
+					reporter: node,
+					nodes:    nodes,
+				})
 				continue
 			}
 			// it's already reported. Add this client name to it (if not set already)
@@ -193,10 +485,111 @@ func (mon *NodeMonitor) checkBadBlocks() {
 				info.Clients = append(info.Clients, node.Name())
 			}
 		}
+		mon.mu.Unlock()
 	}
 }
 
-func (mon *NodeMonitor) findSplits(activeNodes []Node) map[uint64]bool {
+// prune deletes the headers beyond the retention from the block database,
+// unless done recently. The headers referenced by the history of reorgs and
+// splits, and by the bad blocks, are kept. As it scans the whole database, the
+// deletion runs in the background, not to hold up the checks.
+func (mon *NodeMonitor) prune(nodes []Node) {
+	if mon.backend == nil || mon.backend.retention == 0 || time.Since(mon.lastClean) < pruneInterval {
+		return
+	}
+	if atomic.LoadInt32(&mon.pruning) != 0 {
+		return // still at it
+	}
+	mon.lastClean = time.Now()
+	var head uint64
+	for _, n := range nodes {
+		if num := n.HeadNum(); num > head {
+			head = num
+		}
+	}
+	var (
+		keepHashes  = make(map[common.Hash]bool)
+		keepNumbers = make(map[uint64]bool)
+	)
+	mon.history.iterate(0, func(ev *HistoryEvent) bool {
+		if ev.OldHash != nil {
+			keepHashes[*ev.OldHash] = true
+		}
+		if ev.NewHash != nil {
+			keepHashes[*ev.NewHash] = true
+		}
+		if ev.Kind == EventSplit {
+			keepNumbers[ev.Number] = true
+		}
+		return true
+	}, -1)
+	mon.mu.RLock()
+	for hash, bb := range mon.badBlocks {
+		keepHashes[hash] = true
+		if bb.ParentHash != nil {
+			keepHashes[*bb.ParentHash] = true
+		}
+	}
+	mon.mu.RUnlock()
+
+	atomic.StoreInt32(&mon.pruning, 1)
+	mon.wg.Add(1)
+	go func() {
+		defer mon.wg.Done()
+		defer atomic.StoreInt32(&mon.pruning, 0)
+		if pruned := mon.backend.prune(head, keepHashes, keepNumbers, mon.quitCh); pruned > 0 {
+			log.Info("Pruned old headers", "count", pruned, "head", head)
+		}
+	}()
+}
+
+// chainSplit is a point where two nodes have diverged.
+type chainSplit struct {
+	a, b   string // names of the nodes
+	num    uint64 // the first block they disagree on
+	length int64  // the number of blocks not accepted by both
+}
+
+// splitBeacons separates the beacon nodes from the execution-layer nodes.
+func splitBeacons(nodes []Node) (execution []Node, beacons []Node) {
+	for _, n := range nodes {
+		if _, ok := n.(*BeaconNode); ok {
+			beacons = append(beacons, n)
+		} else {
+			execution = append(execution, n)
+		}
+	}
+	return execution, beacons
+}
+
+// interestingNumbers returns the numbers worth reporting for the given nodes,
+// which are their heads and the points where they split, in descending order.
+// The forkHeightCache is updated for the next round, and the size of the
+// largest split is reported to the named gauge.
+func (mon *NodeMonitor) interestingNumbers(ctx context.Context, activeNodes []Node, forkHeightCache *[]int, gauge string) ([]int, []*chainSplit) {
+	heads, splits := findSplits(ctx, activeNodes, *forkHeightCache)
+	var headList []int
+	for k := range heads {
+		headList = append(headList, int(k))
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(headList)))
+	// cache headlist for next round
+	*forkHeightCache = headList
+
+	// splitSize is the max amount of blocks in any chain not accepted by all nodes.
+	// If one node is simply 'behind' that does not count, since it has yet
+	// to accept the canon chain
+	var splitSize int64
+	for _, split := range splits {
+		if splitSize < split.length {
+			splitSize = split.length
+		}
+	}
+	chainGauge(gauge, mon.namespace).Update(splitSize)
+	return headList, splits
+}
+
+func findSplits(ctx context.Context, activeNodes []Node, forkHeightCache []int) (map[uint64]bool, []*chainSplit) {
 	t0 := time.Now()
 	var heads = make(map[uint64]bool)
 	var cache = make(map[common.Hash]int)
@@ -205,8 +598,8 @@ func (mon *NodeMonitor) findSplits(activeNodes []Node) map[uint64]bool {
 	var distinctNodes []Node
 
 	for i, node := range activeNodes {
-		block := node.BlockAt(node.HeadNum(), false)
-		ver, _ := node.Version()
+		block := node.BlockAt(ctx, node.HeadNum(), false)
+		ver, _ := node.Version(ctx)
 		heads[block.num] = true
 		if _, ok := cache[block.hash]; !ok {
 			cache[block.hash] = i
@@ -218,10 +611,6 @@ func (mon *NodeMonitor) findSplits(activeNodes []Node) map[uint64]bool {
 	}
 	log.Info("Latest", logCtx...)
 	t1 := time.Now()
-	// splitSize is the max amount of blocks in any chain not accepted by all nodes.
-	// If one node is simply 'behind' that does not count, since it has yet
-	// to accept the canon chain
-	var splitSize int64
 	// We want to cross-check all 'latest' numbers. So if we have
 	// node 1: x,
 	// node 2: y,
@@ -231,7 +620,10 @@ func (mon *NodeMonitor) findSplits(activeNodes []Node) map[uint64]bool {
 	// node 2: (x, z),
 	// node 3: (x, y),
 	// To figure out if they are on the same chain, or have diverged
-	var headMu sync.Mutex
+	var (
+		headMu sync.Mutex
+		splits []*chainSplit
+	)
 	forPairs(distinctNodes,
 		func(a, b Node) {
 			log.Info("Cross-checking", "a", a.Name(), "b", b.Name())
@@ -241,13 +633,13 @@ func (mon *NodeMonitor) findSplits(activeNodes []Node) map[uint64]bool {
 			}
 			// At the number where both nodes have blocks, check if the two
 			// blocks are identical
-			ha := a.BlockAt(highest, false)
+			ha := a.BlockAt(ctx, highest, false)
 			if ha == nil {
 				// Yeah this actually _does_ happen, see https://github.com/NethermindEth/nethermind/issues/2306
 				log.Error("Node seems to be missing blocks", "name", a.Name(), "number", highest)
 				return
 			}
-			hb := b.BlockAt(highest, false)
+			hb := b.BlockAt(ctx, highest, false)
 			if hb == nil {
 				log.Error("Node seems to be missing blocks", "name", b.Name(), "number", highest)
 				return
@@ -256,15 +648,18 @@ func (mon *NodeMonitor) findSplits(activeNodes []Node) map[uint64]bool {
 				return
 			}
 			// They appear to have diverged
-			split := findSplit(mon.forkHeightCache, int(highest), a, b)
+			split := findSplit(ctx, forkHeightCache, int(highest), a, b)
 			splitLength := int64(int(highest) - split)
-			if splitSize < splitLength {
-				splitSize = splitLength
-			}
 			log.Info("Split found", "x", a.Name(), "y", b.Name(), "num", split, "xHash", ha.hash, "yHash", hb.hash)
 			// Point of interest, add split-block and split-block-minus-one to heads
 			headMu.Lock()
 			defer headMu.Unlock()
+			splits = append(splits, &chainSplit{
+				a:      a.Name(),
+				b:      b.Name(),
+				num:    uint64(split),
+				length: splitLength,
+			})
 			heads[uint64(split)] = true
 			if split > 0 {
 				heads[uint64(split-1)] = true
@@ -273,98 +668,51 @@ func (mon *NodeMonitor) findSplits(activeNodes []Node) map[uint64]bool {
 	)
 	t2 := time.Now()
 	log.Info("Update complete", "head-update", t1.Sub(t0), "forkcheck", t2.Sub(t1))
-	metrics.GetOrRegisterGauge("chain/split", registry).Update(int64(splitSize))
-	return heads
+	return heads, splits
 }
 
-func (mon *NodeMonitor) provideHashes(r *Report) {
-	// And now provide relevant hashes
-	for _, hash := range r.Hashes {
-		hdr := mon.backend.get(hash)
-		if hdr == nil {
-			log.Warn("Missing header", "hash", hash)
+// finalizedMismatches returns the names of the nodes whose finalized block
+// disagrees with the majority of the other nodes. Each node votes on it with
+// its block at the same number, if it has finalized that far itself.
+func finalizedMismatches(ctx context.Context, activeNodes []Node) []string {
+	var mismatches []string
+	for _, a := range activeNodes {
+		fa := a.Finalized()
+		if fa == nil {
 			continue
 		}
-		fname := fmt.Sprintf("www/hashes/0x%x.json", hash)
-		// only write it if it isn't already there
-		if _, err := os.Stat(fname); os.IsNotExist(err) {
-			data, err := json.MarshalIndent(hdr, "", " ")
-			if err != nil {
-				log.Warn("Failed to marshal header", "error", err)
+		var agree, disagree int
+		for _, b := range activeNodes {
+			if a == b {
 				continue
 			}
-			if err := ioutil.WriteFile(fname, data, 0777); err != nil {
-				log.Warn("Failed to write file", "error", err)
-				return
+			fb := b.Finalized()
+			if fb == nil || fb.num < fa.num {
+				continue // b has no opinion on it
 			}
-		}
-	}
-	if time.Since(mon.lastClean) > time.Minute*10 {
-		cleanHashes("www/hashes/", r.Hashes)
-		mon.lastClean = time.Now()
-	}
-}
-
-func (mon *NodeMonitor) provideVulns() {
-	for _, v := range checkCache {
-		fname := fmt.Sprintf("www/vulns/%v.json", v.Uid)
-		// only write it if it isn't already there
-		if _, err := os.Stat(fname); os.IsNotExist(err) {
-			data, err := json.MarshalIndent(v, "", " ")
-			if err != nil {
-				log.Warn("Failed to marshal vulnerability", "error", err)
-				continue
+			hash := fb.hash
+			if fb.num > fa.num {
+				if hash = b.HashAt(ctx, fa.num, false); hash == (common.Hash{}) {
+					continue
+				}
 			}
-			if err := ioutil.WriteFile(fname, data, 0777); err != nil {
-				log.Warn("Failed to write file", "error", err)
-				return
+			if hash == fa.hash {
+				agree++
+			} else {
+				disagree++
 			}
 		}
-	}
-}
-
-// provideBadBlocks stores (newly found) bad block to disk
-func (mon *NodeMonitor) provideBadBlocks() {
-	for hash, block := range mon.badBlocks {
-		fname := fmt.Sprintf("www/badblocks/0x%x.json", hash)
-		// only write it if it isn't already there
-		if _, err := os.Stat(fname); !os.IsNotExist(err) {
-			continue
-		}
-		var data []byte
-		var err error
-		// try to retrieve header from backend
-		if bl := mon.backend.get(block.Hash); bl != nil {
-			type Header types.Header
-			data, err = json.MarshalIndent(struct {
-				Header
-				RLP string `json:"rlp"`
-			}{
-				Header: Header(*bl),
-				RLP:    block.RLP,
-			}, "", " ")
-			if err != nil {
-				log.Warn("Failed to marshal header", "error", err)
-			}
-		}
-		if len(data) == 0 {
-			// block not found in backend, print what we know
-			b := block
-			data, err = json.MarshalIndent(b, "", " ")
-			if err != nil {
-				log.Warn("Failed to marshal header", "error", err)
-				continue
-			}
-		}
-		if err := ioutil.WriteFile(fname, data, 0777); err != nil {
-			log.Warn("Failed to write file", "error", err)
-			return
+		if disagree > agree {
+			log.Error("Finalized block disagrees with majority", "node", a.Name(),
+				"number", fa.num, "hash", fa.hash, "agree", agree, "disagree", disagree)
+			mismatches = append(mismatches, a.Name())
 		}
 	}
+	return mismatches
 }
 
-func getBadBlocks(node Node) []*badBlockJson {
-	badBlocks := node.BadBlocks()
+func getBadBlocks(ctx context.Context, node Node) []*badBlockJson {
+	badBlocks := node.BadBlocks(ctx)
 	var blockJSON []*badBlockJson
 
 	var encBlock types.Block
@@ -393,36 +741,6 @@ func getBadBlocks(node Node) []*badBlockJson {
 	return blockJSON
 }
 
-var fileRe = regexp.MustCompile(`^0x([a-z0-9.]+).json$`)
-
-func cleanHashes(hashdir string, skip []common.Hash) {
-	// And clean out old hashes-files
-	files, err := ioutil.ReadDir(hashdir)
-
-	if err != nil {
-		log.Warn("Cleaning hashes failed", "error", err)
-		return
-	}
-	skipMap := make(map[common.Hash]bool)
-	for _, h := range skip {
-		skipMap[h] = true
-	}
-	count := 0
-	for _, f := range files {
-		match := fileRe.FindStringSubmatch(f.Name())
-		if match == nil {
-			continue
-		}
-		hash := common.HexToHash(match[1])
-		if _, ok := skipMap[hash]; ok {
-			continue
-		}
-		os.Remove(filepath.Join(hashdir, f.Name()))
-		count++
-	}
-	log.Info("Cleaned hashes", "files", count)
-}
-
 // For any differences, we want to figure out the split-block.
 // Let's say we have:
 // node 1: (num1: x)
@@ -432,12 +750,12 @@ func cleanHashes(hashdir string, skip []common.Hash) {
 //
 //  Search uses binary search to find and return the smallest index i
 //  in [0, n) at which f(i) is true
-func findSplit(forkHeightCache []int, num int, a Node, b Node) int {
+func findSplit(ctx context.Context, forkHeightCache []int, num int, a Node, b Node) int {
 	for i := len(forkHeightCache) - 1; i > 0; i-- {
 		head := forkHeightCache[i]
-		if a.HashAt(uint64(head), false) != b.HashAt(uint64(head), false) {
+		if a.HashAt(ctx, uint64(head), false) != b.HashAt(ctx, uint64(head), false) {
 			// they differ at 'head'
-			if head == 0 || a.HashAt(uint64(head-1), false) == b.HashAt(uint64(head-1), false) {
+			if head == 0 || a.HashAt(ctx, uint64(head-1), false) == b.HashAt(ctx, uint64(head-1), false) {
 				// ... and parent of 'head' is identical (or 'head' is genesis)
 				return head
 			}
@@ -449,7 +767,7 @@ func findSplit(forkHeightCache []int, num int, a Node, b Node) int {
 		left = forkHeightCache[0]
 	}
 	splitBlock := sort.Search(num-left, func(i int) bool {
-		return a.HashAt(uint64(left+i), false) != b.HashAt(uint64(left+i), false)
+		return a.HashAt(ctx, uint64(left+i), false) != b.HashAt(ctx, uint64(left+i), false)
 	})
 	return splitBlock + left
 }
@@ -476,48 +794,3 @@ func forPairs(elems []Node, fn func(a, b Node)) {
 	close(pairs)
 	wg.Wait()
 }
-
-type blockDB struct {
-	db *leveldb.DB
-}
-
-func NewBlockDB() (*blockDB, error) {
-	file := "blockDB"
-	db, err := leveldb.OpenFile(file, &opt.Options{
-		// defaults:
-		//BlockCacheCapacity:     8  * opt.MiB,
-		//WriteBuffer:            4 * opt.MiB,
-	})
-	if _, corrupted := err.(*errors.ErrCorrupted); corrupted {
-		db, err = leveldb.RecoverFile(file, nil)
-	}
-	if err != nil {
-		return nil, err
-	}
-	return &blockDB{db}, nil
-
-}
-
-func (db *blockDB) add(key common.Hash, h *types.Header) {
-	k := key[:]
-	if ok, _ := db.db.Has(k, nil); ok {
-		return
-	}
-	data, err := rlp.EncodeToBytes(h)
-	if err != nil {
-		panic(fmt.Sprintf("Failed encoding header: %v", err))
-	}
-	db.db.Put(k, data, nil)
-}
-
-func (db *blockDB) get(key common.Hash) *types.Header {
-	data, err := db.db.Get(key[:], nil)
-	if err != nil {
-		return nil
-	}
-	var h types.Header
-	if err = rlp.DecodeBytes(data, &h); err != nil {
-		panic(fmt.Sprintf("Failed decoding our own data: %v", err))
-	}
-	return &h
-}
@@ -1,8 +1,12 @@
 package nodes
 
 import (
+	"context"
 	"errors"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -15,7 +19,7 @@ type brokenNode struct {
 	id string
 }
 
-func (b *brokenNode) Version() (string, error) {
+func (b *brokenNode) Version(ctx context.Context) (string, error) {
 	return "", errors.New("broken node")
 }
 
@@ -29,15 +33,15 @@ func (b *brokenNode) Status() int {
 
 func (b brokenNode) SetStatus(int) {}
 
-func (b brokenNode) UpdateLatest() error {
+func (b brokenNode) UpdateLatest(ctx context.Context) error {
 	return errors.New("broken node")
 }
 
-func (b brokenNode) BlockAt(num uint64, force bool) *blockInfo {
+func (b brokenNode) BlockAt(ctx context.Context, num uint64, force bool) *blockInfo {
 	return nil
 }
 
-func (b brokenNode) HashAt(num uint64, force bool) common.Hash {
+func (b brokenNode) HashAt(ctx context.Context, num uint64, force bool) common.Hash {
 	return common.Hash{}
 }
 
@@ -45,11 +49,19 @@ func (b brokenNode) HeadNum() uint64 {
 	return 0
 }
 
+func (b brokenNode) Finalized() *blockInfo {
+	return nil
+}
+
+func (b brokenNode) Safe() *blockInfo {
+	return nil
+}
+
 func (b brokenNode) LastProgress() int64 {
 	return 0
 }
 
-func (b brokenNode) BadBlocks() []*eth.BadBlockArgs {
+func (b brokenNode) BadBlocks(ctx context.Context) []*eth.BadBlockArgs {
 	return []*eth.BadBlockArgs{}
 }
 
@@ -90,10 +102,11 @@ func TestMonitor(t *testing.T) {
 	nodes = append(nodes, &brokenNode{"broken-a"})
 	nodes = append(nodes, &brokenNode{"broken-b"})
 
-	nm, err := NewMonitor(nodes, nil, time.Second, "Playdoh-net")
+	nm, err := NewMonitor(nodes, nil, time.Second, "Playdoh-net", "")
 	if err != nil {
 		t.Fatal(err)
 	}
+	nm.doChecks()
 	if nm.lastReport == nil {
 		t.Fatalf("missing report")
 	}
@@ -153,3 +166,209 @@ func TestMonitor(t *testing.T) {
 	q4 := countQueries() - q1 - q2 - q3
 	t.Logf("Follow-up check after block progression and fork: %d unique block queries", q4)
 }
+
+func TestMonitorReconfigure(t *testing.T) {
+	log.Root().SetHandler(log.LvlFilterHandler(
+		log.LvlCrit, log.StreamHandler(os.Stderr, log.TerminalFormat(false))))
+
+	// Disable the vuln check for tests
+	disableVulnCheck = true
+
+	canon := newTestNode("canon", 13_000_000, []uint64{0}, []int{0})
+	fork := newTestNode("fork", 12_999_900, []uint64{0, 12_999_800}, []int{0, 1})
+	nm, err := NewMonitor([]Node{canon, fork}, nil, time.Second, "Playdoh-net", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nm.doChecks()
+	if have, want := len(nm.lastReport.Cols), 2; have != want {
+		t.Fatalf("wrong columns, want %d, have %d", want, have)
+	}
+	queries := canon.totalQueries
+
+	// Drop the forked node, add a fresh one
+	other := newTestNode("other", 13_000_000, []uint64{0}, []int{0})
+	nm.Reconfigure([]Node{canon, other}, 0, "Playdoh-net-2")
+	if have, want := nm.getReloadInterval(), 10*time.Second; have != want {
+		t.Fatalf("wrong reload interval, want %v, have %v", want, have)
+	}
+	nm.doChecks()
+	r := nm.lastReport
+	if have, want := r.Chain, "Playdoh-net-2"; have != want {
+		t.Fatalf("wrong chain name, want %v, have %v", want, have)
+	}
+	if have, want := len(r.Cols), 2; have != want {
+		t.Fatalf("wrong columns, want %d, have %d", want, have)
+	}
+	for _, c := range r.Cols {
+		if c.Name == fork.Name() {
+			t.Fatalf("removed node still reported")
+		}
+	}
+	// Without a split, only the head is interesting
+	if have, want := len(r.Numbers), 1; have != want {
+		r.Print()
+		t.Fatalf("wrong numbers, want %d, have %d", want, have)
+	}
+	if canon.totalQueries == queries {
+		t.Fatalf("kept node was not queried")
+	}
+	// A removed node is only closed once the round of checks is over
+	closing := &closingNode{testNode: newTestNode("closing", 13_000_000, []uint64{0}, []int{0})}
+	nm.Reconfigure([]Node{canon, other, closing}, 0, "Playdoh-net-2")
+	nm.Reconfigure([]Node{canon, other}, 0, "Playdoh-net-2")
+	if closing.closed {
+		t.Fatal("removed node closed during the round")
+	}
+	nm.closeRemoved()
+	if !closing.closed {
+		t.Fatal("removed node not closed")
+	}
+}
+
+// closingNode is a test node which records being closed.
+type closingNode struct {
+	*testNode
+	closed bool
+}
+
+func (n *closingNode) Close() error {
+	n.closed = true
+	return nil
+}
+
+func TestFinalizedMismatch(t *testing.T) {
+	log.Root().SetHandler(log.LvlFilterHandler(
+		log.LvlCrit, log.StreamHandler(os.Stderr, log.TerminalFormat(false))))
+
+	// Disable the vuln check for tests
+	disableVulnCheck = true
+
+	nodes := []Node{
+		newTestNode("canon-a", 13_000_000, []uint64{0}, []int{0}),
+		newTestNode("canon-b", 13_000_000, []uint64{0}, []int{0}),
+		newTestNode("canon-c", 12_999_990, []uint64{0}, []int{0}),
+		// Forked off below its finalized block
+		newTestNode("fork", 13_000_000, []uint64{0, 12_999_900}, []int{0, 1}),
+		// Forked off above its finalized block, which is harmless
+		newTestNode("recent-fork", 13_000_000, []uint64{0, 12_999_990}, []int{0, 2}),
+	}
+	nm, err := NewMonitor(nodes, nil, time.Second, "Playdoh-net", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nm.doChecks()
+	r := nm.LastReport()
+	for _, c := range r.Cols {
+		if c.Finalized == nil || c.Safe == nil {
+			t.Fatalf("node %v: missing finalized or safe block", c.Name)
+		}
+		if want := c.Name == nodes[3].Name(); c.FinalizedMismatch != want {
+			t.Errorf("node %v: wrong finalized mismatch, have %v, want %v", c.Name, c.FinalizedMismatch, want)
+		}
+	}
+	var found bool
+	for _, alert := range r.Alerts {
+		if alert.Kind == AlertFinalized {
+			found = true
+			if alert.Severity != AlertCritical {
+				t.Errorf("wrong severity, have %v, want %v", alert.Severity, AlertCritical)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("missing finalized alert")
+	}
+}
+
+// hangingNode is a test node which, once hanging, blocks in UpdateLatest until
+// the context is cancelled.
+type hangingNode struct {
+	*testNode
+	hang int32 // accessed atomically
+}
+
+func (n *hangingNode) UpdateLatest(ctx context.Context) error {
+	if atomic.LoadInt32(&n.hang) == 0 {
+		return nil
+	}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestStopCancelsChecks(t *testing.T) {
+	log.Root().SetHandler(log.LvlFilterHandler(
+		log.LvlCrit, log.StreamHandler(os.Stderr, log.TerminalFormat(false))))
+
+	// Disable the vuln check for tests
+	disableVulnCheck = true
+
+	node := &hangingNode{testNode: newTestNode("hanging", 13_000_000, []uint64{0}, []int{0})}
+	nm, err := NewMonitor([]Node{node}, nil, time.Millisecond, "Playdoh-net", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt32(&node.hang, 1)
+	nm.Start()
+	time.Sleep(50 * time.Millisecond) // let a round of checks start
+
+	done := make(chan struct{})
+	go func() {
+		nm.Stop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("in-flight checks not cancelled on stop")
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	quit := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-quit // don't respond until the test is done
+	}))
+	defer srv.Close()
+	defer close(quit)
+
+	node, err := NewRPCNode("slow", "", srv.URL, nil, nil, 0, 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if err := node.UpdateLatest(context.Background()); err == nil {
+		t.Fatal("expected timeout error")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("request not timed out, took %v", elapsed)
+	}
+}
+
+func TestMonitorSettingsFirstRound(t *testing.T) {
+	log.Root().SetHandler(log.LvlFilterHandler(
+		log.LvlCrit, log.StreamHandler(os.Stderr, log.TerminalFormat(false))))
+
+	// Disable the vuln check for tests
+	disableVulnCheck = true
+
+	canon := newTestNode("canon", 13_000_000, []uint64{0}, []int{0})
+	behind := newTestNode("behind", 12_999_900, []uint64{0}, []int{0})
+	nm, err := NewMonitor([]Node{canon, behind}, nil, time.Hour, "Playdoh-net", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The settings made before starting apply to the first round
+	nm.SetLagThreshold(1000)
+	nm.Start()
+	defer nm.Stop()
+	for i := 0; nm.LastReport() == nil; i++ {
+		if i == 500 {
+			t.Fatal("no report after starting")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if behind.Status() != NodeStatusOK {
+		t.Errorf("node within the configured lag threshold marked %v", StatusName(behind.Status()))
+	}
+}
@@ -1,6 +1,7 @@
 package nodes
 
 import (
+	"context"
 	"fmt"
 	"math/big"
 	"sort"
@@ -14,8 +15,23 @@ import (
 const (
 	NodeStatusOK = iota
 	NodeStatusUnreachable
+	NodeStatusSyncing    // the node reports that it is syncing
+	NodeStatusNoPeers    // the node has no peers
+	NodeStatusStalled    // the head has not progressed for a while
+	NodeStatusLagging    // the head is behind the other nodes
+	NodeStatusWrongChain // the node is not on the expected chain
 )
 
+var statusNames = []string{"ok", "unreachable", "syncing", "no-peers", "stalled", "lagging", "wrong-chain"}
+
+// StatusName returns the name of the given node status.
+func StatusName(status int) string {
+	if status < 0 || status >= len(statusNames) {
+		return "unknown"
+	}
+	return statusNames[status]
+}
+
 type blockInfo struct {
 	num   uint64
 	hash  common.Hash
@@ -28,27 +44,55 @@ func (bl *blockInfo) TerminalString() string {
 		bl.hash.TerminalString())
 }
 
+// Node is a monitored client. The methods which talk to the client take a
+// context, which bounds how long they may take.
 type Node interface {
-	Version() (string, error)
+	Version(ctx context.Context) (string, error)
 	Name() string
 	Status() int
 	LastProgress() int64
 	SetStatus(int)
-	UpdateLatest() error
-	BlockAt(num uint64, force bool) *blockInfo
-	HashAt(num uint64, force bool) common.Hash
+	UpdateLatest(ctx context.Context) error
+	BlockAt(ctx context.Context, num uint64, force bool) *blockInfo
+	HashAt(ctx context.Context, num uint64, force bool) common.Hash
 	HeadNum() uint64
-	BadBlocks() []*eth.BadBlockArgs
+	Finalized() *blockInfo // the latest finalized block, or nil if unknown
+	Safe() *blockInfo      // the latest safe block, or nil if unknown
+	BadBlocks(ctx context.Context) []*eth.BadBlockArgs
 	BadBlockCount() int
 }
 
+// blockJson is a block number and hash, as shown in the report.
+type blockJson struct {
+	Number uint64
+	Hash   common.Hash
+}
+
+func newBlockJson(bl *blockInfo) *blockJson {
+	if bl == nil {
+		return nil
+	}
+	return &blockJson{Number: bl.num, Hash: bl.hash}
+}
+
 type clientJson struct {
-	Version         string
-	Name            string
-	Status          int
-	LastProgress    int64
-	BadBlocks       int
-	Vulnerabilities []string
+	Version           string
+	Client            *clientVersion `json:",omitempty"` // the parsed version, if recognized
+	Name              string
+	Status            int
+	StatusName        string
+	Peers             int // -1 if unknown
+	LastProgress      int64
+	BadBlocks         int
+	Vulnerabilities   []string
+	Finalized         *blockJson
+	Safe              *blockJson
+	FinalizedMismatch bool             // the finalized block disagrees with the majority of nodes
+	Minority          bool             // the node is on a minority fork
+	Lag               *lagJson         `json:",omitempty"` // how far the node is behind the head of the nodes
+	Reorgs            int              // the number of recent reorgs observed
+	Requests          []*requestBucket `json:",omitempty"` // the recent requests, per minute
+	Error             string           `json:",omitempty"` // why the node is excluded from the checks
 }
 
 type badBlockJson struct {
@@ -93,7 +137,12 @@ type Report struct {
 	Numbers   []int
 	Hashes    []common.Hash
 	BadBlocks BadBlockList
+	Splits    []*splitJson
+	Consensus []*consensusJson `json:",omitempty"` // the canonical hash at the numbers
+	Minority  []string         // the nodes on a minority fork
+	Alerts    []*Alert
 	Chain     string
+	Beacon    *Report `json:",omitempty"` // the consensus-layer nodes, if any
 }
 
 func NewReport(headList []int, chainName string) *Report {
@@ -140,7 +189,10 @@ func (r *Report) Print() {
 
 func (r *Report) addBadBlocks(badBlocks map[common.Hash]*badBlockJson) {
 	for _, bb := range badBlocks {
-		r.BadBlocks = append(r.BadBlocks, bb)
+		// Copy it, since the monitor keeps adding clients to the original
+		cpy := *bb
+		cpy.Clients = append([]string(nil), bb.Clients...)
+		r.BadBlocks = append(r.BadBlocks, &cpy)
 	}
 	sort.Sort(sort.Reverse(r.BadBlocks))
 	// don't show more than 20 bad blocks
@@ -150,15 +202,29 @@ func (r *Report) addBadBlocks(badBlocks map[common.Hash]*badBlockJson) {
 }
 
 // AddToReport adds the given node to the report
-func (r *Report) AddToReport(node Node, vuln []vulnJson) {
-	v, _ := node.Version()
+func (r *Report) AddToReport(ctx context.Context, node Node, vuln []vulnJson) {
+	v, _ := node.Version(ctx)
 	// Add general node properties
 	np := &clientJson{
 		Version:      v,
+		Client:       parseClientVersion(v),
 		Name:         node.Name(),
 		Status:       node.Status(),
+		StatusName:   StatusName(node.Status()),
+		Peers:        -1,
 		LastProgress: node.LastProgress(),
 		BadBlocks:    node.BadBlockCount(), // TODO add counter len(badBlocks),
+		Finalized:    newBlockJson(node.Finalized()),
+		Safe:         newBlockJson(node.Safe()),
+	}
+	if rr, ok := node.(reorgRecorder); ok {
+		np.Reorgs = len(rr.Reorgs())
+	}
+	if hr, ok := node.(healthReporter); ok {
+		np.Peers = hr.Peers()
+	}
+	if rr, ok := node.(requestReporter); ok {
+		np.Requests = rr.RequestStats()
 	}
 	// Add vulnerabilites if applicable
 	if len(vuln) != 0 {
@@ -171,9 +237,9 @@ func (r *Report) AddToReport(node Node, vuln []vulnJson) {
 	// Add hashes
 	for _, num := range r.Numbers {
 		row := r.Rows[num]
-		block := node.BlockAt(uint64(num), false)
+		block := node.BlockAt(ctx, uint64(num), false)
 		txt := ""
-		if block != nil {
+		if block != nil && block.hash != (common.Hash{}) {
 			txt = fmt.Sprintf("0x%x", block.hash)
 			r.Hashes = append(r.Hashes, block.hash)
 		}
@@ -183,11 +249,53 @@ func (r *Report) AddToReport(node Node, vuln []vulnJson) {
 	r.dedup()
 }
 
-func ReportNode(node Node, nums []int) {
-	v, _ := node.Version()
+// markWrongChain flags the given nodes as not being on the expected chain.
+func (r *Report) markWrongChain(errs map[string]error) {
+	for _, c := range r.Cols {
+		if err, ok := errs[c.Name]; ok {
+			c.Error = err.Error()
+		}
+	}
+}
+
+// markLag sets how far the nodes are behind the head of the nodes.
+func (r *Report) markLag(lags map[string]*lagJson) {
+	for _, c := range r.Cols {
+		if lag, ok := lags[c.Name]; ok {
+			c.Lag = lag
+		}
+	}
+}
+
+// markFinalizedMismatch flags the named nodes as disagreeing on finality.
+func (r *Report) markFinalizedMismatch(names []string) {
+	for _, c := range r.Cols {
+		for _, name := range names {
+			if c.Name == name {
+				c.FinalizedMismatch = true
+			}
+		}
+	}
+}
+
+// markConsensus sets the canonical chain, and flags the nodes on a minority fork.
+func (r *Report) markConsensus(consensus []*consensusJson) {
+	r.Consensus = consensus
+	r.Minority = minorityNodes(consensus)
+	for _, c := range r.Cols {
+		for _, name := range r.Minority {
+			if c.Name == name {
+				c.Minority = true
+			}
+		}
+	}
+}
+
+func ReportNode(ctx context.Context, node Node, nums []int) {
+	v, _ := node.Version(ctx)
 	fmt.Printf("## %v\n", v)
 	for _, num := range nums {
-		block := node.BlockAt(uint64(num), false)
+		block := node.BlockAt(ctx, uint64(num), false)
 		if block != nil {
 			fmt.Printf("%d: %v\n", num, block.TerminalString())
 		} else {
@@ -0,0 +1,132 @@
+package nodes
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+// prometheusNamespace is prepended to all exported metric names.
+const prometheusNamespace = "nodemonitor_"
+
+var (
+	invalidMetricChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+	labelEscaper       = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	summaryQuantiles   = []float64{0.5, 0.75, 0.95, 0.99}
+)
+
+// promFamily is a set of samples sharing a metric name.
+type promFamily struct {
+	typ     string
+	samples []string
+}
+
+// promWriter aggregates registry metrics into metric families.
+type promWriter struct {
+	families map[string]*promFamily
+}
+
+func (p *promWriter) family(name, typ string) *promFamily {
+	f, ok := p.families[name]
+	if !ok {
+		f = &promFamily{typ: typ}
+		p.families[name] = f
+	}
+	return f
+}
+
+// add adds a sample of the named metric family. The extra labels are
+// appended to the metric labels.
+func (p *promWriter) add(f *promFamily, name string, labels []string, value interface{}) {
+	var sample strings.Builder
+	sample.WriteString(name)
+	if len(labels) > 0 {
+		sample.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				sample.WriteByte(',')
+			}
+			fmt.Fprintf(&sample, `%s="%s"`, invalidMetricChars.ReplaceAllString(labels[i], "_"), labelEscaper.Replace(labels[i+1]))
+		}
+		sample.WriteByte('}')
+	}
+	fmt.Fprintf(&sample, " %v", value)
+	f.samples = append(f.samples, sample.String())
+}
+
+func (p *promWriter) addSummary(name string, labels []string, count int64, sum float64, quantiles []float64) {
+	f := p.family(name, "summary")
+	for i, q := range summaryQuantiles {
+		p.add(f, name, append(labels[:len(labels):len(labels)], "quantile", strconv.FormatFloat(q, 'f', -1, 64)), quantiles[i])
+	}
+	p.add(f, name+"_sum", labels, sum)
+	p.add(f, name+"_count", labels, count)
+}
+
+func (p *promWriter) write(buf *bytes.Buffer) {
+	var names []string
+	for name := range p.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f := p.families[name]
+		sort.Strings(f.samples)
+		fmt.Fprintf(buf, "# TYPE %s %s\n", name, f.typ)
+		for _, sample := range f.samples {
+			buf.WriteString(sample)
+			buf.WriteByte('\n')
+		}
+	}
+}
+
+// writePrometheus writes the metrics in the given registry in the Prometheus
+// text exposition format. Metrics created through labeledName are exported
+// with their labels, all others get their registry name flattened.
+func writePrometheus(buf *bytes.Buffer, r metrics.Registry) {
+	p := &promWriter{families: make(map[string]*promFamily)}
+	r.Each(func(key string, i interface{}) {
+		m := lookupLabels(key)
+		name := prometheusNamespace + invalidMetricChars.ReplaceAllString(m.name, "_")
+		switch metric := i.(type) {
+		case metrics.Gauge:
+			p.add(p.family(name, "gauge"), name, m.labels, metric.Snapshot().Value())
+		case metrics.GaugeFloat64:
+			p.add(p.family(name, "gauge"), name, m.labels, metric.Snapshot().Value())
+		case metrics.Counter:
+			p.add(p.family(name, "counter"), name, m.labels, metric.Snapshot().Count())
+		case metrics.Meter:
+			p.add(p.family(name, "counter"), name, m.labels, metric.Snapshot().Count())
+		case metrics.Histogram:
+			h := metric.Snapshot()
+			p.addSummary(name, m.labels, h.Count(), float64(h.Sum()), h.Percentiles(summaryQuantiles))
+		case metrics.Timer:
+			// Timers record nanoseconds, export them as seconds
+			t := metric.Snapshot()
+			ps := t.Percentiles(summaryQuantiles)
+			for i := range ps {
+				ps[i] /= float64(time.Second)
+			}
+			p.addSummary(name+"_seconds", m.labels, t.Count(), float64(t.Sum())/float64(time.Second), ps)
+		}
+	})
+	p.write(buf)
+}
+
+// PrometheusHandler returns a http.Handler serving the metrics for scraping by
+// Prometheus.
+func PrometheusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		writePrometheus(&buf, registry)
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write(buf.Bytes())
+	})
+}
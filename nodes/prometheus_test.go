@@ -0,0 +1,43 @@
+package nodes
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+func TestWritePrometheus(t *testing.T) {
+	enabled := metrics.Enabled
+	metrics.Enabled = true
+	defer func() { metrics.Enabled = enabled }()
+
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterGauge(labeledName("head", "node", "geth"), r).Update(100)
+	metrics.GetOrRegisterGauge(labeledName("head", "node", `be"su`), r).Update(99)
+	metrics.GetOrRegisterGauge("chain/split", r).Update(2)
+	metrics.GetOrRegisterCounter(labeledName("requests", "node", "geth", "method", "eth_getBlockByNumber"), r).Inc(3)
+	metrics.GetOrRegisterTimer("latency", r).Update(2 * time.Second)
+
+	var buf bytes.Buffer
+	writePrometheus(&buf, r)
+	have := buf.String()
+	for _, want := range []string{
+		"# TYPE nodemonitor_head gauge\n" +
+			"nodemonitor_head{node=\"be\\\"su\"} 99\n" +
+			"nodemonitor_head{node=\"geth\"} 100\n",
+		"# TYPE nodemonitor_chain_split gauge\nnodemonitor_chain_split 2\n",
+		"# TYPE nodemonitor_requests counter\n" +
+			"nodemonitor_requests{node=\"geth\",method=\"eth_getBlockByNumber\"} 3\n",
+		"# TYPE nodemonitor_latency_seconds summary\n",
+		"nodemonitor_latency_seconds{quantile=\"0.5\"} 2\n",
+		"nodemonitor_latency_seconds_sum 2\n",
+		"nodemonitor_latency_seconds_count 1\n",
+	} {
+		if !strings.Contains(have, want) {
+			t.Errorf("missing output\nwant:\n%s\nhave:\n%s", want, have)
+		}
+	}
+}
@@ -2,6 +2,8 @@ package nodes
 
 import (
 	"os"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/ethereum/go-ethereum/log"
@@ -11,7 +13,70 @@ import (
 
 var registry = metrics.NewRegistry()
 
+// labeledMetric is the name and labels of a metric, for exporters which support
+// labels. In the registry itself (and thus in InfluxDB), the label values are
+// instead appended to the name.
+type labeledMetric struct {
+	name   string
+	labels []string // key-value pairs
+}
+
+var (
+	metricLabelsMu sync.RWMutex
+	metricLabels   = make(map[string]labeledMetric)
+)
+
+// labeledName returns the registry name for the given metric and label key-value
+// pairs, and records the labels for exporting.
+func labeledName(name string, labels ...string) string {
+	key := name
+	for i := 1; i < len(labels); i += 2 {
+		key += "/" + labels[i]
+	}
+	metricLabelsMu.Lock()
+	defer metricLabelsMu.Unlock()
+	metricLabels[key] = labeledMetric{name: name, labels: labels}
+	return key
+}
+
+// lookupLabels returns the name and labels of the given registry metric.
+func lookupLabels(key string) labeledMetric {
+	metricLabelsMu.RLock()
+	defer metricLabelsMu.RUnlock()
+	if m, ok := metricLabels[key]; ok {
+		return m
+	}
+	return labeledMetric{name: key}
+}
+
+// chainLabels prepends the chain label to the given label key-value pairs,
+// unless the chain is empty, as it is when monitoring a single chain.
+func chainLabels(chain string, labels ...string) []string {
+	if len(chain) == 0 {
+		return labels
+	}
+	return append([]string{"chain", chain}, labels...)
+}
+
+// chainGauge returns the gauge for the given metric of a chain.
+func chainGauge(name, chain string) metrics.Gauge {
+	return metrics.GetOrRegisterGauge(labeledName(name, chainLabels(chain)...), registry)
+}
+
+// nodeGauge returns the gauge for the given metric of a node on a chain.
+func nodeGauge(name, chain, node string) metrics.Gauge {
+	return metrics.GetOrRegisterGauge(labeledName(name, chainLabels(chain, "node", node)...), registry)
+}
+
+// nodeCounter returns the counter for the given metric of a node on a chain.
+func nodeCounter(name, chain, node string) metrics.Counter {
+	return metrics.GetOrRegisterCounter(labeledName(name, chainLabels(chain, "node", node)...), registry)
+}
+
 func EnableMetrics(conf *Config) {
+	if conf.Metrics.Prometheus {
+		metrics.Enabled = true
+	}
 	if !conf.Metrics.Enabled {
 		return
 	}
@@ -29,3 +94,18 @@ func EnableMetrics(conf *Config) {
 		conf.Metrics.Endpoint, conf.Metrics.Database,
 		conf.Metrics.Username, conf.Metrics.Password, conf.Metrics.Namespace, tags)
 }
+
+// PrometheusPath returns the path to serve the Prometheus metrics at, or the
+// empty string if disabled.
+func PrometheusPath(conf *Config) string {
+	if !conf.Metrics.Prometheus {
+		return ""
+	}
+	if len(conf.Metrics.PrometheusPath) == 0 {
+		return "/metrics"
+	}
+	if !strings.HasPrefix(conf.Metrics.PrometheusPath, "/") {
+		return "/" + conf.Metrics.PrometheusPath
+	}
+	return conf.Metrics.PrometheusPath
+}
@@ -0,0 +1,127 @@
+package nodes
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// Error classes of failed requests
+const (
+	errClassTimeout = "timeout" // the request timed out
+	errClassHTTP    = "http"    // the server responded with a non-2xx status
+	errClassRPC     = "rpc"     // the server responded with a json-rpc error
+	errClassOther   = "other"   // anything else, e.g. connection refused
+)
+
+const (
+	requestBucketSize = time.Minute // the period of each bucket of the request stats
+	requestBuckets    = 30          // how many buckets are kept
+)
+
+// requestReporter is implemented by nodes which keep statistics of the requests
+// made to them.
+type requestReporter interface {
+	RequestStats() []*requestBucket
+}
+
+// requestBucket sums up the requests made to a node during a period of time.
+type requestBucket struct {
+	Time     int64   // unix time of the start of the period
+	Requests int     // the number of requests
+	Errors   int     // the number of failed requests
+	Latency  float64 // the average latency, in milliseconds
+
+	total time.Duration
+}
+
+// requestMetrics records the latency, outcome and count of the requests made
+// to a node, per method. Besides the metrics, the recent requests are summed up
+// per minute, for the dashboard.
+type requestMetrics struct {
+	chain, node string
+
+	mu      sync.Mutex
+	buckets []*requestBucket // the oldest first
+}
+
+func newRequestMetrics(chain, node string) *requestMetrics {
+	return &requestMetrics{chain: chain, node: node}
+}
+
+// observe records a request to the given method, which started at the given
+// time and failed with err, if not nil. It is a no-op on a nil receiver.
+func (m *requestMetrics) observe(method string, start time.Time, err error) {
+	if m == nil {
+		return
+	}
+	elapsed := time.Since(start)
+	labels := chainLabels(m.chain, "node", m.node, "method", method)
+	metrics.GetOrRegisterCounter(labeledName("rpc/requests", labels...), registry).Inc(1)
+	metrics.GetOrRegisterTimer(labeledName("rpc/latency", labels...), registry).Update(elapsed)
+	if err != nil {
+		class, code := errorClass(err)
+		labels = append(labels, "class", class)
+		if len(code) > 0 {
+			labels = append(labels, "code", code)
+		}
+		metrics.GetOrRegisterCounter(labeledName("rpc/errors", labels...), registry).Inc(1)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	period := start.Truncate(requestBucketSize).Unix()
+	if n := len(m.buckets); n == 0 || m.buckets[n-1].Time < period {
+		m.buckets = append(m.buckets, &requestBucket{Time: period})
+		if len(m.buckets) > requestBuckets {
+			m.buckets = m.buckets[len(m.buckets)-requestBuckets:]
+		}
+	}
+	b := m.buckets[len(m.buckets)-1]
+	b.Requests++
+	if err != nil {
+		b.Errors++
+	}
+	b.total += elapsed
+	b.Latency = float64(b.total) / float64(b.Requests) / float64(time.Millisecond)
+}
+
+// stats returns a copy of the recent request buckets, the oldest first.
+func (m *requestMetrics) stats() []*requestBucket {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := make([]*requestBucket, len(m.buckets))
+	for i, b := range m.buckets {
+		cpy := *b
+		list[i] = &cpy
+	}
+	return list
+}
+
+// errorClass classifies a request error, returning the class and the status
+// or error code, if any.
+func errorClass(err error) (class, code string) {
+	var (
+		httpErr rpc.HTTPError
+		rpcErr  rpc.Error
+		netErr  net.Error
+	)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return errClassTimeout, ""
+	case errors.As(err, &httpErr):
+		return errClassHTTP, strconv.Itoa(httpErr.StatusCode)
+	case errors.As(err, &rpcErr):
+		return errClassRPC, strconv.Itoa(rpcErr.ErrorCode())
+	}
+	return errClassOther, ""
+}
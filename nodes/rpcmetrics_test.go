@@ -0,0 +1,102 @@
+package nodes
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+func TestRequestMetrics(t *testing.T) {
+	log.Root().SetHandler(log.LvlFilterHandler(
+		log.LvlCrit, log.StreamHandler(os.Stderr, log.TerminalFormat(false))))
+	enabled := metrics.Enabled
+	metrics.Enabled = true
+	defer func() { metrics.Enabled = enabled }()
+
+	chain := newMockChain(100)
+	srv := newMockServer(chain)
+	defer srv.Close()
+	node, err := NewRPCNode("requests", "metrics-test", srv.URL, nil, nil, 0, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	counter := func(name string, labels ...string) int64 {
+		key := labeledName(name, chainLabels("metrics-test", append([]string{"node", "requests"}, labels...)...)...)
+		if c, ok := registry.Get(key).(metrics.Counter); ok {
+			return c.Count()
+		}
+		return 0
+	}
+	node.UpdateLatest(ctx)
+	node.UpdateLatest(ctx)
+	chain.setError("eth_getBlockByNumber", errors.New("internal error"))
+	node.UpdateLatest(ctx)
+	chain.setError("eth_getBlockByNumber", nil)
+	chain.setLatency(time.Second)
+	node.UpdateLatest(ctx)
+
+	if have, want := counter("rpc/requests", "method", "eth_getBlockByNumber"), int64(6); have != want {
+		t.Errorf("wrong request count, have %d, want %d", have, want)
+	}
+	if have, want := counter("rpc/errors", "method", "eth_getBlockByNumber", "class", errClassRPC, "code", "-32000"), int64(1); have != want {
+		t.Errorf("wrong rpc error count, have %d, want %d", have, want)
+	}
+	if have, want := counter("rpc/errors", "method", "eth_getBlockByNumber", "class", errClassTimeout), int64(1); have != want {
+		t.Errorf("wrong timeout count, have %d, want %d", have, want)
+	}
+	key := labeledName("rpc/latency", chainLabels("metrics-test", "node", "requests", "method", "eth_getBlockByNumber")...)
+	if timer, ok := registry.Get(key).(metrics.Timer); !ok || timer.Count() != 6 {
+		t.Errorf("latency not recorded")
+	}
+	// The requests of the last minute are summed up for the dashboard. The
+	// first update also polled the sync status and peer count.
+	stats := node.RequestStats()
+	if len(stats) == 0 {
+		t.Fatal("no request stats")
+	}
+	var requests, errs int
+	for _, b := range stats {
+		requests += b.Requests
+		errs += b.Errors
+	}
+	if requests != 8 || errs != 2 {
+		t.Errorf("wrong request stats, have %d requests and %d errors, want 8 and 2", requests, errs)
+	}
+	if last := stats[len(stats)-1]; last.Latency <= 0 {
+		t.Errorf("wrong average latency: %v", last.Latency)
+	}
+}
+
+func TestErrorClass(t *testing.T) {
+	unavailable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down for maintenance", http.StatusServiceUnavailable)
+	}))
+	defer unavailable.Close()
+	node, err := NewRPCNode("unavailable", "", unavailable.URL, nil, nil, 0, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, httpErr := node.RPCMethodCaller.Version(context.Background())
+
+	for _, tt := range []struct {
+		err         error
+		class, code string
+	}{
+		{context.DeadlineExceeded, errClassTimeout, ""},
+		{httpErr, errClassHTTP, "503"},
+		{errors.New("connection refused"), errClassOther, ""},
+	} {
+		class, code := errorClass(tt.err)
+		if class != tt.class || code != tt.code {
+			t.Errorf("%v: wrong class, have %v/%v, want %v/%v", tt.err, class, code, tt.class, tt.code)
+		}
+	}
+}
@@ -20,41 +20,59 @@ import (
 	"go.uber.org/ratelimit"
 )
 
+// DefaultTimeout is how long a request to a client may take, unless configured
+// otherwise.
+const DefaultTimeout = 3 * time.Second
+
 type RPCMethodCaller interface {
-	HeaderByNumber(*big.Int) (*types.Header, error)
-	Version() (string, error)
-	GetBadBlocks() ([]*eth.BadBlockArgs, error)
+	HeaderByNumber(ctx context.Context, num *big.Int) (*types.Header, error)
+	Version(ctx context.Context) (string, error)
+	GetBadBlocks(ctx context.Context) ([]*eth.BadBlockArgs, error)
 }
 
 type JSONRPCMethodCaller struct {
-	rpcCli *rpc.Client
-	ethCli *ethclient.Client
+	rpcCli  *rpc.Client
+	ethCli  *ethclient.Client
+	timeout time.Duration // per request
 }
 
-func NewRPCHeaderCall(rpcCli *rpc.Client, ethCli *ethclient.Client) *JSONRPCMethodCaller {
+func NewRPCHeaderCall(rpcCli *rpc.Client, ethCli *ethclient.Client, timeout time.Duration) *JSONRPCMethodCaller {
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
 	return &JSONRPCMethodCaller{
-		rpcCli: rpcCli,
-		ethCli: ethCli,
+		rpcCli:  rpcCli,
+		ethCli:  ethCli,
+		timeout: timeout,
 	}
 }
 
-func (caller *JSONRPCMethodCaller) Version() (string, error) {
+// Close closes the connection to the node.
+func (caller *JSONRPCMethodCaller) Close() error {
+	caller.rpcCli.Close()
+	return nil
+}
+
+func (caller *JSONRPCMethodCaller) Version(ctx context.Context) (string, error) {
 	method := "web3_clientVersion"
 	var ver string
-	ctx, _ := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, caller.timeout)
+	defer cancel()
 	err := caller.rpcCli.CallContext(ctx, &ver, method)
 	return ver, err
 }
 
-func (caller *JSONRPCMethodCaller) HeaderByNumber(num *big.Int) (*types.Header, error) {
-	ctx, _ := context.WithTimeout(context.Background(), 3*time.Second)
+func (caller *JSONRPCMethodCaller) HeaderByNumber(ctx context.Context, num *big.Int) (*types.Header, error) {
+	ctx, cancel := context.WithTimeout(ctx, caller.timeout)
+	defer cancel()
 	return caller.ethCli.HeaderByNumber(ctx, num)
 }
 
-func (caller *JSONRPCMethodCaller) GetBadBlocks() ([]*eth.BadBlockArgs, error) {
+func (caller *JSONRPCMethodCaller) GetBadBlocks(ctx context.Context) ([]*eth.BadBlockArgs, error) {
 	method := "debug_getBadBlocks"
 	var blocks []*eth.BadBlockArgs
-	ctx, _ := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, caller.timeout)
+	defer cancel()
 	err := caller.rpcCli.CallContext(ctx, &blocks, method)
 	// TODO check if error is method not available
 	return blocks, err
@@ -67,6 +85,12 @@ type RemoteNode struct {
 	version       string
 	name          string
 	latest        *blockInfo
+	finalized     *blockInfo
+	safe          *blockInfo
+	tagsHead      common.Hash // the head when finalized and safe were last fetched
+	reorgs        []*Reorg    // the most recent reorgs
+	syncing       bool
+	peers         int // -1 if unknown
 	badBlockCount int
 	chainHistory  map[uint64]*blockInfo
 	// backend to store hash -> header into
@@ -75,45 +99,84 @@ type RemoteNode struct {
 	mu           sync.RWMutex
 	lastProgress int64 // Last unix-time the node progressed the chain
 
-	headGauge metrics.Gauge
+	headGauge         metrics.Gauge
+	finalizedLagGauge metrics.Gauge
+	safeLagGauge      metrics.Gauge
+	reorgCounter      metrics.Counter
+	syncingGauge      metrics.Gauge
+	peersGauge        metrics.Gauge
+	requests          *requestMetrics
 	// rate limiting
 	throttle  ratelimit.Limiter
 	lastCheck map[string]time.Time
+
+	// new heads subscription, for websocket connections
+	subscribed bool
+	quitCh     chan struct{}
+	wg         sync.WaitGroup
 }
 
-func NewRPCNode(name string, url string, authHeaders []string, db *blockDB, rateLimit int) (*RemoteNode, error) {
+// parseHeaders coerces "Key: value" strings into http headers.
+func parseHeaders(list []string) (http.Header, error) {
 	var headers = make(http.Header)
-	for _, hdr := range authHeaders {
-		// Try to coerce strings ->  map[string][]string
+	for _, hdr := range list {
 		if kv := strings.Split(hdr, ": "); len(kv) != 2 {
 			return nil, fmt.Errorf("Expected colon-separated key-value pair, got %s", hdr)
 		} else {
 			headers[kv[0]] = kv[1:]
 		}
 	}
-	rpcCli, err := rpc.DialOptions(context.Background(), url, rpc.WithHeaders(headers))
-	if err != nil {
-		return nil, err
-	}
+	return headers, nil
+}
+
+// newRemoteNode creates a node calling the given caller, with the metrics
+// labelled by chain and name, and requests throttled to rateLimit per second
+// (unlimited if zero).
+func newRemoteNode(name, chain, version string, caller RPCMethodCaller, db *blockDB, rateLimit int) *RemoteNode {
 	throttle := ratelimit.NewUnlimited()
 	if rateLimit > 0 {
 		throttle = ratelimit.New(rateLimit)
 	}
-	ethCli := ethclient.NewClient(rpcCli)
-	gaugeName := fmt.Sprintf("head/%v", name)
 	return &RemoteNode{
-		RPCMethodCaller: NewRPCHeaderCall(rpcCli, ethCli),
-		name:            name,
-		version:         "n/a",
-		chainHistory:    make(map[uint64]*blockInfo),
-		db:              db,
-		headGauge:       metrics.GetOrRegisterGauge(gaugeName, registry),
-		throttle:        throttle,
-		lastCheck:       make(map[string]time.Time),
-	}, nil
-}
-
-func NewInfuraNode(name, projectId, endpoint string, db *blockDB, rateLimit int) (*RemoteNode, error) {
+		RPCMethodCaller:   caller,
+		name:              name,
+		version:           version,
+		chainHistory:      make(map[uint64]*blockInfo),
+		db:                db,
+		headGauge:         nodeGauge("head", chain, name),
+		finalizedLagGauge: nodeGauge("finalized/lag", chain, name),
+		safeLagGauge:      nodeGauge("safe/lag", chain, name),
+		reorgCounter:      nodeCounter("reorgs", chain, name),
+		syncingGauge:      nodeGauge("syncing", chain, name),
+		peersGauge:        nodeGauge("peers", chain, name),
+		requests:          newRequestMetrics(chain, name),
+		peers:             -1,
+		throttle:          throttle,
+		lastCheck:         make(map[string]time.Time),
+	}
+}
+
+// NewRPCNode creates a node for the json-rpc endpoint at url. The chain labels
+// the metrics of the node, and is empty when monitoring a single chain.
+func NewRPCNode(name, chain, url string, authHeaders []string, db *blockDB, rateLimit int, timeout time.Duration) (*RemoteNode, error) {
+	headers, err := parseHeaders(authHeaders)
+	if err != nil {
+		return nil, err
+	}
+	rpcCli, err := rpc.DialOptions(context.Background(), url, rpc.WithHeaders(headers))
+	if err != nil {
+		return nil, err
+	}
+	ethCli := ethclient.NewClient(rpcCli)
+	caller := NewRPCHeaderCall(rpcCli, ethCli, timeout)
+	node := newRemoteNode(name, chain, "n/a", caller, db, rateLimit)
+	if isWebsocket(url) {
+		node.subscribe(caller)
+	}
+	return node, nil
+}
+
+func NewInfuraNode(name, chain, projectId, endpoint string, db *blockDB, rateLimit int, timeout time.Duration) (*RemoteNode, error) {
 	if len(projectId) == 0 {
 		return nil, errors.New("Missing infura_key")
 	}
@@ -123,24 +186,15 @@ func NewInfuraNode(name, projectId, endpoint string, db *blockDB, rateLimit int)
 		return nil, err
 	}
 	ethCli := ethclient.NewClient(rpcCli)
-	gaugeName := fmt.Sprintf("head/%v", name)
-	throttle := ratelimit.NewUnlimited()
-	if rateLimit > 0 {
-		throttle = ratelimit.New(rateLimit)
+	caller := NewRPCHeaderCall(rpcCli, ethCli, timeout)
+	node := newRemoteNode(name, chain, "Infura V3", caller, db, rateLimit)
+	if isWebsocket(url) {
+		node.subscribe(caller)
 	}
-	return &RemoteNode{
-		RPCMethodCaller: NewRPCHeaderCall(rpcCli, ethCli),
-		name:            name,
-		version:         "Infura V3",
-		chainHistory:    make(map[uint64]*blockInfo),
-		db:              db,
-		headGauge:       metrics.GetOrRegisterGauge(gaugeName, registry),
-		throttle:        throttle,
-		lastCheck:       make(map[string]time.Time),
-	}, nil
-}
-
-func NewAlchemyNode(name, apiKey, endpoint string, db *blockDB, rateLimit int) (*RemoteNode, error) {
+	return node, nil
+}
+
+func NewAlchemyNode(name, chain, apiKey, endpoint string, db *blockDB, rateLimit int, timeout time.Duration) (*RemoteNode, error) {
 	if len(apiKey) == 0 {
 		return nil, errors.New("Missing alchemy_key")
 	}
@@ -150,21 +204,12 @@ func NewAlchemyNode(name, apiKey, endpoint string, db *blockDB, rateLimit int) (
 		return nil, err
 	}
 	ethCli := ethclient.NewClient(rpcCli)
-	gaugeName := fmt.Sprintf("head/%v", name)
-	throttle := ratelimit.NewUnlimited()
-	if rateLimit > 0 {
-		throttle = ratelimit.New(rateLimit)
+	caller := NewRPCHeaderCall(rpcCli, ethCli, timeout)
+	node := newRemoteNode(name, chain, "Alchemy V2", caller, db, rateLimit)
+	if isWebsocket(url) {
+		node.subscribe(caller)
 	}
-	return &RemoteNode{
-		RPCMethodCaller: NewRPCHeaderCall(rpcCli, ethCli),
-		name:            name,
-		version:         "Alchemy V2",
-		chainHistory:    make(map[uint64]*blockInfo),
-		db:              db,
-		headGauge:       metrics.GetOrRegisterGauge(gaugeName, registry),
-		throttle:        throttle,
-		lastCheck:       make(map[string]time.Time),
-	}, nil
+	return node, nil
 }
 
 func (node *RemoteNode) SetStatus(status int) {
@@ -179,7 +224,7 @@ func (node *RemoteNode) Status() int {
 	return node.status
 }
 
-func (node *RemoteNode) Version() (string, error) {
+func (node *RemoteNode) Version(ctx context.Context) (string, error) {
 	method := "web3_clientVersion"
 	node.mu.Lock()
 	defer node.mu.Unlock()
@@ -190,7 +235,9 @@ func (node *RemoteNode) Version() (string, error) {
 	node.lastCheck[method] = time.Now()
 
 	node.throttle.Take()
-	ver, err := node.RPCMethodCaller.Version()
+	start := time.Now()
+	ver, err := node.RPCMethodCaller.Version(ctx)
+	node.requests.observe(method, start, err)
 	if err == nil {
 		node.version = ver
 	}
@@ -218,35 +265,96 @@ func (node *RemoteNode) LastProgress() int64 {
 	return node.lastProgress
 }
 
-func (node *RemoteNode) UpdateLatest() error {
+func (node *RemoteNode) UpdateLatest(ctx context.Context) error {
 	node.mu.Lock()
 	defer node.mu.Unlock()
 
-	bl, err := node.fetchHeader(nil)
-	if err != nil {
-		return err
+	// While subscribed to new heads, the head is already up to date
+	if !node.subscribed {
+		bl, err := node.fetchHeader(ctx, nil)
+		if err != nil {
+			return err
+		}
+		node.setLatest(bl)
 	}
-	if node.latest == nil || node.latest.hash != bl.hash {
-		node.lastProgress = time.Now().Unix()
-		node.latest = bl
-		node.headGauge.Update(int64(bl.num))
-		log.Trace("Set last progress to ", "time", node.lastProgress)
+	node.checkSync(ctx)
+	// Finalized and safe only move along with the head
+	if node.latest != nil && node.tagsHead != node.latest.hash {
+		node.tagsHead = node.latest.hash
+		node.finalized = node.fetchTag(ctx, rpc.FinalizedBlockNumber)
+		node.safe = node.fetchTag(ctx, rpc.SafeBlockNumber)
+		if node.finalized != nil {
+			node.finalizedLagGauge.Update(int64(node.latest.num - node.finalized.num))
+		}
+		if node.safe != nil {
+			node.safeLagGauge.Update(int64(node.latest.num - node.safe.num))
+		}
 	}
 	return nil
 }
 
-// throttledGetHeader fetches header at num, applies throttling and
-// stores the header info in the node chain and the backend
-func (node *RemoteNode) throttledGetHeader(num *big.Int) (*blockInfo, error) {
+// setLatest updates the head of the node, if it changed.
+func (node *RemoteNode) setLatest(bl *blockInfo) {
+	if node.latest != nil && node.latest.hash == bl.hash {
+		return
+	}
+	node.lastProgress = time.Now().Unix()
+	node.latest = bl
+	node.headGauge.Update(int64(bl.num))
+	log.Trace("Set last progress to ", "time", node.lastProgress)
+}
+
+// fetchTag fetches the block with the given tag (finalized or safe). Nodes which
+// don't support the tag, e.g. pre-merge chains, yield nil.
+func (node *RemoteNode) fetchTag(ctx context.Context, tag rpc.BlockNumber) *blockInfo {
+	h, err := node.throttledGetHeader(ctx, big.NewInt(int64(tag)))
+	if err != nil {
+		log.Debug("Error fetching tagged block", "node", node.name, "tag", tag, "error", err)
+		return nil
+	}
+	return node.storeHeader(h)
+}
+
+func (node *RemoteNode) Finalized() *blockInfo {
+	node.mu.RLock()
+	defer node.mu.RUnlock()
+	return node.finalized
+}
+
+func (node *RemoteNode) Safe() *blockInfo {
+	node.mu.RLock()
+	defer node.mu.RUnlock()
+	return node.safe
+}
+
+// Reorgs returns the most recent reorgs observed on the node.
+func (node *RemoteNode) Reorgs() []*Reorg {
+	node.mu.RLock()
+	defer node.mu.RUnlock()
+	return append([]*Reorg(nil), node.reorgs...)
+}
+
+// throttledGetHeader fetches header at num, applying throttling
+func (node *RemoteNode) throttledGetHeader(ctx context.Context, num *big.Int) (*types.Header, error) {
 	node.throttle.Take()
 	log.Debug("Doing check", "node", node.name, "requested", num)
-	h, err := node.RPCMethodCaller.HeaderByNumber(num)
+	start := time.Now()
+	h, err := node.RPCMethodCaller.HeaderByNumber(ctx, num)
+	node.requests.observe("eth_getBlockByNumber", start, err)
 	if err != nil {
 		return nil, err
 	}
 	if h == nil {
 		return nil, fmt.Errorf("Got nil header for, num %d, node %v", num, node.name)
 	}
+	if num != nil && num.Sign() >= 0 && num.Uint64() != h.Number.Uint64() {
+		return nil, fmt.Errorf("Remote node %v answered with wrong number, got %d, want %v", node.name, h.Number, num.Uint64())
+	}
+	return h, nil
+}
+
+// storeHeader stores the header info in the node chain and the backend
+func (node *RemoteNode) storeHeader(h *types.Header) *blockInfo {
 	// Store header to db aswell
 	if node.db != nil {
 		node.db.add(h.Hash(), h)
@@ -257,42 +365,60 @@ func (node *RemoteNode) throttledGetHeader(num *big.Int) (*blockInfo, error) {
 		pHash: h.ParentHash,
 	}
 	node.chainHistory[bl.num] = bl
-	if num != nil && num.Uint64() != bl.num {
-		return nil, fmt.Errorf("Remote node %v answered with wrong number, got %d, want %v", node.name, bl.num, num.Uint64())
-	}
-	return bl, nil
+	return bl
 }
 
-func (node *RemoteNode) fetchHeader(num *big.Int) (*blockInfo, error) {
-	hdr, err := node.throttledGetHeader(num)
+func (node *RemoteNode) fetchHeader(ctx context.Context, num *big.Int) (*blockInfo, error) {
+	h, err := node.throttledGetHeader(ctx, num)
 	if err != nil {
-		return hdr, err
+		return nil, err
+	}
+	return node.addHeader(h, func(num uint64) (*types.Header, error) {
+		return node.throttledGetHeader(ctx, new(big.Int).SetUint64(num))
+	}), nil
+}
+
+// addHeader stores the given header, and checks it against the cached chain:
+// if it replaces a known block, or its ancestors do, a reorg is recorded. The
+// replaced ancestors are refetched with getHeader.
+func (node *RemoteNode) addHeader(h *types.Header, getHeader func(num uint64) (*types.Header, error)) *blockInfo {
+	var (
+		replaced           int
+		oldBlock, newBlock *blockInfo // the highest replaced block, and its replacement
+	)
+	if prev, ok := node.chainHistory[h.Number.Uint64()]; ok && prev.hash != h.Hash() {
+		replaced++
+		oldBlock = prev
+	}
+	hdr := node.storeHeader(h)
+	if oldBlock != nil {
+		newBlock = hdr
 	}
 	// If we have a parent for this block, we can check if it's still valid
-	if parentInfo, ok := node.chainHistory[hdr.num-1]; ok {
-		current := hdr
-		reorgs := 0
-		for parentInfo != nil {
-			if parentInfo.hash == current.pHash {
-				break // not reorged
-			}
-			reorgs++
-			delete(node.chainHistory, parentInfo.num) // wipe and refetch parent
-			current, err = node.throttledGetHeader(new(big.Int).SetUint64(parentInfo.num))
-			if err != nil {
-				break
-			}
-			parentInfo = node.chainHistory[current.num-1]
+	current := hdr
+	for current.num > 0 && replaced < maxReorgDepth {
+		parentInfo, ok := node.chainHistory[current.num-1]
+		if !ok || parentInfo.hash == current.pHash {
+			break // not reorged
+		}
+		replaced++
+		if oldBlock == nil {
+			oldBlock, newBlock = parentInfo, &blockInfo{num: parentInfo.num, hash: current.pHash}
 		}
-		if reorgs > 1 {
-			log.Info("Node reorged", "name", node.name, "size", reorgs)
+		delete(node.chainHistory, parentInfo.num) // wipe and refetch parent
+		parent, err := getHeader(parentInfo.num)
+		if err != nil {
+			break
 		}
+		current = node.storeHeader(parent)
 	}
-
-	return hdr, nil
+	if replaced > 0 {
+		node.recordReorg(oldBlock, newBlock, replaced)
+	}
+	return hdr
 }
 
-func (node *RemoteNode) BlockAt(num uint64, force bool) *blockInfo {
+func (node *RemoteNode) BlockAt(ctx context.Context, num uint64, force bool) *blockInfo {
 	node.mu.Lock()
 	defer node.mu.Unlock()
 
@@ -304,11 +430,11 @@ func (node *RemoteNode) BlockAt(num uint64, force bool) *blockInfo {
 			return bl // have it already, don't refetch it
 		}
 	}
-	bl, _ := node.fetchHeader(new(big.Int).SetUint64(num))
+	bl, _ := node.fetchHeader(ctx, new(big.Int).SetUint64(num))
 	return bl
 }
 
-func (node *RemoteNode) HashAt(num uint64, force bool) common.Hash {
+func (node *RemoteNode) HashAt(ctx context.Context, num uint64, force bool) common.Hash {
 	node.mu.Lock()
 	defer node.mu.Unlock()
 	if !force {
@@ -325,17 +451,19 @@ func (node *RemoteNode) HashAt(num uint64, force bool) common.Hash {
 		}
 	}
 	// No, need to reach out to the remote node to fetch it
-	if bl, _ := node.fetchHeader(new(big.Int).SetUint64(num)); bl != nil {
+	if bl, _ := node.fetchHeader(ctx, new(big.Int).SetUint64(num)); bl != nil {
 		return bl.hash
 	}
 	return common.Hash{}
 }
 
-func (node *RemoteNode) BadBlocks() []*eth.BadBlockArgs {
+func (node *RemoteNode) BadBlocks(ctx context.Context) []*eth.BadBlockArgs {
 	node.mu.Lock()
 	defer node.mu.Unlock()
 
-	args, err := node.GetBadBlocks()
+	start := time.Now()
+	args, err := node.GetBadBlocks(ctx)
+	node.requests.observe("debug_getBadBlocks", start, err)
 	if err != nil {
 		return []*eth.BadBlockArgs{}
 	}
@@ -343,6 +471,12 @@ func (node *RemoteNode) BadBlocks() []*eth.BadBlockArgs {
 	return args
 }
 
+// RequestStats returns the recent requests made to the node, summed up per
+// minute.
+func (node *RemoteNode) RequestStats() []*requestBucket {
+	return node.requests.stats()
+}
+
 func (node *RemoteNode) BadBlockCount() int {
 	node.mu.RLock()
 	defer node.mu.RUnlock()
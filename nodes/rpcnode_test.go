@@ -0,0 +1,213 @@
+package nodes
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+func TestRPCNodeMonitor(t *testing.T) {
+	log.Root().SetHandler(log.LvlFilterHandler(
+		log.LvlCrit, log.StreamHandler(os.Stderr, log.TerminalFormat(false))))
+
+	// Disable the vuln check for tests
+	disableVulnCheck = true
+
+	var (
+		canon  = newMockChain(200)
+		other  = newMockChain(200)
+		forked = newMockChain(150)
+		nodes  []Node
+	)
+	forked.extend(52, 1) // split off at 150, two blocks ahead
+	forked.setSyncing(true, 3)
+	other.setSyncing(false, 0)
+	bad := canon.addBadBlock(199)
+	for _, c := range []struct {
+		name  string
+		chain *mockChain
+		ws    bool
+	}{
+		{"canon", canon, false},
+		{"forked", forked, false},
+		{"other", other, true},
+	} {
+		srv := newMockServer(c.chain)
+		defer srv.Close()
+		url := srv.URL
+		if c.ws {
+			url = srv.wsURL()
+		}
+		node, err := NewRPCNode(c.name, "", url, nil, nil, 0, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer node.Close()
+		nodes = append(nodes, node)
+	}
+	select {
+	case <-other.subbing:
+	case <-time.After(5 * time.Second):
+		t.Fatal("node did not subscribe")
+	}
+	other.announce()
+	nm, err := NewMonitor(nodes, nil, time.Second, "Playdoh-net", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nm.doChecks()
+	r := nm.LastReport()
+	cols := make(map[string]*clientJson)
+	for _, c := range r.Cols {
+		cols[c.Name] = c
+	}
+	if have, want := cols["canon"].Version, canon.version; have != want {
+		t.Errorf("wrong version, have %v, want %v", have, want)
+	}
+	if have, want := cols["canon"].Status, NodeStatusOK; have != want {
+		t.Errorf("canon: wrong status, have %v, want %v", StatusName(have), StatusName(want))
+	}
+	if have, want := cols["forked"].Status, NodeStatusSyncing; have != want {
+		t.Errorf("forked: wrong status, have %v, want %v", StatusName(have), StatusName(want))
+	}
+	if have, want := cols["other"].Status, NodeStatusNoPeers; have != want {
+		t.Errorf("other: wrong status, have %v, want %v", StatusName(have), StatusName(want))
+	}
+	if cols["canon"].Finalized == nil || cols["canon"].Finalized.Number != 199-64 {
+		t.Errorf("wrong finalized block: %v", cols["canon"].Finalized)
+	}
+	// The nodes sharing a head are checked once
+	if len(r.Splits) != 1 {
+		t.Fatalf("wrong splits, have %d, want 1", len(r.Splits))
+	}
+	if have, want := r.Splits[0].Number, uint64(150); have != want {
+		t.Errorf("wrong split number, have %d, want %d", have, want)
+	}
+	if len(r.BadBlocks) != 1 || r.BadBlocks[0].Hash != bad.Hash {
+		t.Fatalf("bad block not reported: %v", r.BadBlocks)
+	}
+
+	// Resolve the split, with the forked node reorging onto the canon chain
+	forked.setSyncing(false, 3)
+	forked.rewind(149)
+	forked.extend(50, 0)
+	nm.doChecks()
+	if r := nm.LastReport(); len(r.Splits) != 0 {
+		t.Errorf("split not resolved: %v", r.Splits)
+	}
+}
+
+func TestRPCNodeReorg(t *testing.T) {
+	log.Root().SetHandler(log.LvlFilterHandler(
+		log.LvlCrit, log.StreamHandler(os.Stderr, log.TerminalFormat(false))))
+
+	chain := newMockChain(100)
+	srv := newMockServer(chain)
+	defer srv.Close()
+	node, err := NewRPCNode("reorg", "", srv.URL, nil, nil, 0, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := node.UpdateLatest(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// Fetch the blocks below the head, so that the reorg replaces known blocks
+	for num := uint64(95); num < 99; num++ {
+		node.BlockAt(ctx, num, false)
+	}
+	old := chain.hash(99)
+	chain.reorg(3, 1)
+	chain.extend(1, 1)
+	if err := node.UpdateLatest(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := node.HeadNum(), uint64(100); have != want {
+		t.Fatalf("wrong head, have %d, want %d", have, want)
+	}
+	reorgs := node.Reorgs()
+	if len(reorgs) != 1 {
+		t.Fatalf("wrong reorgs, have %d, want 1", len(reorgs))
+	}
+	if have, want := reorgs[0].Depth, 3; have != want {
+		t.Errorf("wrong reorg depth, have %d, want %d", have, want)
+	}
+	if reorgs[0].Number != 99 || reorgs[0].OldHash != old || reorgs[0].NewHash != chain.hash(99) {
+		t.Errorf("wrong reorg: %+v", reorgs[0])
+	}
+	for num := 97; num <= 100; num++ {
+		if have, want := node.HashAt(ctx, uint64(num), false), chain.hash(num); have != want {
+			t.Errorf("block %d: wrong hash, have %x, want %x", num, have, want)
+		}
+	}
+}
+
+func TestRPCNodeFailures(t *testing.T) {
+	log.Root().SetHandler(log.LvlFilterHandler(
+		log.LvlCrit, log.StreamHandler(os.Stderr, log.TerminalFormat(false))))
+
+	// Disable the vuln check for tests
+	disableVulnCheck = true
+
+	chain := newMockChain(100)
+	srv := newMockServer(chain)
+	defer srv.Close()
+	node, err := NewRPCNode("failing", "", srv.URL, nil, nil, 0, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nm, err := NewMonitor([]Node{node}, nil, time.Second, "Playdoh-net", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nm.doChecks()
+	if have, want := node.Status(), NodeStatusOK; have != want {
+		t.Fatalf("wrong status, have %v, want %v", StatusName(have), StatusName(want))
+	}
+	// Errors make the node unreachable
+	chain.setError("eth_getBlockByNumber", errors.New("internal error"))
+	nm.doChecks()
+	if have, want := node.Status(), NodeStatusUnreachable; have != want {
+		t.Errorf("wrong status on error, have %v, want %v", StatusName(have), StatusName(want))
+	}
+	chain.setError("eth_getBlockByNumber", nil)
+	nm.doChecks()
+	if have, want := node.Status(), NodeStatusOK; have != want {
+		t.Errorf("wrong status after recovery, have %v, want %v", StatusName(have), StatusName(want))
+	}
+	// So do responses slower than the timeout
+	chain.setLatency(time.Second)
+	start := time.Now()
+	nm.doChecks()
+	if have, want := node.Status(), NodeStatusUnreachable; have != want {
+		t.Errorf("wrong status on timeout, have %v, want %v", StatusName(have), StatusName(want))
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("requests not timed out, took %v", elapsed)
+	}
+	// Nodes without the debug namespace have no bad blocks
+	chain.setLatency(0)
+	chain.setError("debug_getBadBlocks", errors.New("the method debug_getBadBlocks does not exist/is not available"))
+	if blocks := node.BadBlocks(context.Background()); len(blocks) != 0 {
+		t.Errorf("wrong bad blocks: %v", blocks)
+	}
+}
+
+func TestRPCNodeClose(t *testing.T) {
+	srv := newMockServer(newMockChain(10))
+	defer srv.Close()
+
+	node, err := NewRPCNode("closed", "", srv.wsURL(), nil, nil, 0, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node.Close()
+	// The connection to the node is closed along with it
+	if err := node.UpdateLatest(context.Background()); err == nil {
+		t.Fatal("closed node still connected")
+	}
+}
@@ -0,0 +1,413 @@
+package nodes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// APIPrefix is the path under which the JSON API is served.
+const APIPrefix = "/api/v1/"
+
+// Handler returns a http.Handler which serves the monitor data as a JSON API:
+//
+//	/api/v1/report            the latest report
+//	/api/v1/stream            a stream of reports, alerts and stalls, as server-sent events
+//	/api/v1/headers/<hash>    a header from the block database
+//	/api/v1/badblocks/<hash>  a bad block reported by any of the nodes
+//	/api/v1/vulns/<uid>       a known vulnerability
+//	/api/v1/history           the reorgs and splits, newest first
+//	/api/v1/triage/<hash>     the triage of a bad block, with /bad and /good for the raw traces
+func (mon *NodeMonitor) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(APIPrefix+"report", mon.serveReport)
+	mux.HandleFunc(APIPrefix+"stream", mon.serveStream)
+	mux.HandleFunc(APIPrefix+"headers/", mon.serveHeader)
+	mux.HandleFunc(APIPrefix+"badblocks/", mon.serveBadBlock)
+	mux.HandleFunc(APIPrefix+"vulns/", serveVuln)
+	mux.HandleFunc(APIPrefix+"history", mon.serveHistory)
+	mux.HandleFunc(APIPrefix+"triage/", mon.serveTriage)
+	return mux
+}
+
+// chainJson describes a monitored chain, as listed by the chains API.
+type chainJson struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+	Path string `json:"path"` // where the chain is served, relative to the root
+}
+
+// ChainsHandler returns a http.Handler which serves the dashboard files from
+// www and the JSON API for each of the given monitors under /chains/<id>/, with
+// the list of chains at /api/v1/chains. The first chain is also served at the
+// root, which is all there is when monitoring a single chain.
+func ChainsHandler(www http.Handler, mons []*NodeMonitor) http.Handler {
+	chainHandler := func(mon *NodeMonitor) http.Handler {
+		mux := http.NewServeMux()
+		mux.Handle("/", www)
+		mux.Handle(APIPrefix, mon.Handler())
+		return mux
+	}
+	mux := http.NewServeMux()
+	for _, mon := range mons {
+		if len(mon.namespace) > 0 {
+			prefix := "/chains/" + mon.namespace
+			mux.Handle(prefix+"/", http.StripPrefix(prefix, chainHandler(mon)))
+		}
+	}
+	mux.HandleFunc(APIPrefix+"chains", func(w http.ResponseWriter, r *http.Request) {
+		chains := make([]chainJson, 0, len(mons))
+		for _, mon := range mons {
+			chain := chainJson{Id: mon.namespace, Name: mon.getChainName()}
+			if len(mon.namespace) > 0 {
+				chain.Path = "chains/" + mon.namespace + "/"
+			}
+			chains = append(chains, chain)
+		}
+		writeJSON(w, chains)
+	})
+	if len(mons) > 0 {
+		mux.Handle("/", chainHandler(mons[0]))
+	}
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	data, err := json.MarshalIndent(v, "", " ")
+	if err != nil {
+		log.Warn("Json marshal fail", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// hashParam parses the hash which follows the given prefix in the request path.
+func hashParam(r *http.Request, prefix string) (common.Hash, bool) {
+	b, err := hexutil.Decode(strings.TrimPrefix(r.URL.Path, prefix))
+	if err != nil || len(b) != common.HashLength {
+		return common.Hash{}, false
+	}
+	return common.BytesToHash(b), true
+}
+
+func (mon *NodeMonitor) serveReport(w http.ResponseWriter, r *http.Request) {
+	report := mon.LastReport()
+	if report == nil {
+		http.Error(w, "no report available yet", http.StatusServiceUnavailable)
+		return
+	}
+	writeJSON(w, report)
+}
+
+// streamKeepAlive is how often a comment is sent on idle event streams, to
+// prevent proxies from closing the connection.
+const streamKeepAlive = 30 * time.Second
+
+// streamWriteTimeout is how long a write to an event stream may take, before
+// the client is disconnected.
+const streamWriteTimeout = 10 * time.Second
+
+// streamBacklog is how many alerts and stalls may be waiting to be sent to a
+// client, before it is disconnected.
+const streamBacklog = 64
+
+type connKey struct{}
+
+// ConnContext adds the connection to the context of its requests, so that the
+// event streams can set write deadlines on it. It is meant to be used as the
+// ConnContext of the http.Server.
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, c)
+}
+
+// streamEvent is an event waiting to be sent on an event stream.
+type streamEvent struct {
+	typ string
+	v   interface{}
+}
+
+// streamQueue takes the events for one client off the monitor's feeds as soon
+// as they are sent, so that a slow client never holds up the monitor. Only the
+// latest report is kept, whereas the other events are queued up to the backlog.
+type streamQueue struct {
+	mu       sync.Mutex
+	report   *Report
+	events   []streamEvent
+	overflow bool
+	wake     chan struct{}
+}
+
+func (q *streamQueue) push(ev streamEvent) {
+	q.mu.Lock()
+	if report, ok := ev.v.(*Report); ok {
+		q.report = report
+	} else if len(q.events) < streamBacklog {
+		q.events = append(q.events, ev)
+	} else {
+		q.overflow = true
+	}
+	q.mu.Unlock()
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// pop returns the waiting events, the report first, and whether the client has
+// fallen too far behind.
+func (q *streamQueue) pop() ([]streamEvent, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var events []streamEvent
+	if q.report != nil {
+		events = append(events, streamEvent{"report", q.report})
+	}
+	events = append(events, q.events...)
+	q.report, q.events = nil, nil
+	return events, q.overflow
+}
+
+// serveStream pushes each new report to the client as a server-sent event,
+// starting with the current one, along with the alerts being fired and resolved,
+// and the nodes stalling or progressing again. A client which falls behind is
+// only sent the latest report, and is disconnected if too many other events
+// pile up, or if a write takes too long.
+func (mon *NodeMonitor) serveStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	ch := make(chan *Report, 1)
+	sub := mon.SubscribeReports(ch)
+	defer sub.Unsubscribe()
+	alertCh := make(chan *Alert, 16)
+	alertSub := mon.SubscribeAlerts(alertCh)
+	defer alertSub.Unsubscribe()
+	stallCh := make(chan *StallEvent, 16)
+	stallSub := mon.SubscribeStalls(stallCh)
+	defer stallSub.Unsubscribe()
+
+	var (
+		queue = &streamQueue{wake: make(chan struct{}, 1)}
+		done  = make(chan struct{}) // closed when the handler returns
+		ended = make(chan struct{}) // closed when a subscription ends
+	)
+	defer close(done)
+	go func() {
+		defer close(ended)
+		for {
+			select {
+			case report := <-ch:
+				queue.push(streamEvent{"report", report})
+			case alert := <-alertCh:
+				queue.push(streamEvent{"alert", alert})
+			case stall := <-stallCh:
+				queue.push(streamEvent{"stall", stall})
+			case <-sub.Err():
+				return
+			case <-alertSub.Err():
+				return
+			case <-stallSub.Err():
+				return
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	conn, _ := r.Context().Value(connKey{}).(net.Conn)
+	if conn != nil {
+		defer conn.SetWriteDeadline(time.Time{})
+	}
+	write := func(data string) bool {
+		if conn != nil {
+			conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		}
+		if _, err := fmt.Fprint(w, data); err != nil {
+			return false
+		}
+		flusher.Flush()
+		return true
+	}
+	send := func(typ string, v interface{}) bool {
+		data, err := json.Marshal(v)
+		if err != nil {
+			log.Warn("Json marshal fail", "error", err)
+			return true
+		}
+		return write(fmt.Sprintf("event: %s\ndata: %s\n\n", typ, data))
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	if !write("") {
+		return
+	}
+	if report := mon.LastReport(); report != nil && !send("report", report) {
+		return
+	}
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-queue.wake:
+			events, overflow := queue.pop()
+			if overflow {
+				log.Debug("Dropping event stream client, too far behind", "remote", r.RemoteAddr)
+				return
+			}
+			for _, ev := range events {
+				if !send(ev.typ, ev.v) {
+					return
+				}
+			}
+		case <-keepAlive.C:
+			if !write(": keep-alive\n\n") {
+				return
+			}
+		case <-ended:
+			return
+		case <-mon.quitCh:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (mon *NodeMonitor) serveHeader(w http.ResponseWriter, r *http.Request) {
+	hash, ok := hashParam(r, APIPrefix+"headers/")
+	if !ok {
+		http.Error(w, "invalid hash", http.StatusBadRequest)
+		return
+	}
+	var hdr *types.Header
+	if mon.backend != nil {
+		hdr = mon.backend.get(hash)
+	}
+	if hdr == nil {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, hdr)
+}
+
+func (mon *NodeMonitor) serveBadBlock(w http.ResponseWriter, r *http.Request) {
+	hash, ok := hashParam(r, APIPrefix+"badblocks/")
+	if !ok {
+		http.Error(w, "invalid hash", http.StatusBadRequest)
+		return
+	}
+	var block badBlockJson
+	mon.mu.RLock()
+	bb, ok := mon.badBlocks[hash]
+	if ok {
+		block = *bb
+		block.Clients = append([]string(nil), bb.Clients...)
+	}
+	mon.mu.RUnlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	// Try to retrieve header from backend
+	if mon.backend != nil {
+		if bl := mon.backend.get(hash); bl != nil {
+			type Header types.Header
+			writeJSON(w, struct {
+				Header
+				RLP string `json:"rlp"`
+			}{
+				Header: Header(*bl),
+				RLP:    block.RLP,
+			})
+			return
+		}
+	}
+	// Block not found in backend, serve what we know
+	writeJSON(w, &block)
+}
+
+func serveVuln(w http.ResponseWriter, r *http.Request) {
+	vuln := getVuln(strings.TrimPrefix(r.URL.Path, APIPrefix+"vulns/"))
+	if vuln == nil {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, vuln)
+}
+
+// serveHistory serves the history events, filtered by the query parameters
+// 'kind' and 'node'. The number of events is set by 'limit', and older pages
+// are retrieved by passing the id of the last event as 'before'.
+func (mon *NodeMonitor) serveHistory(w http.ResponseWriter, r *http.Request) {
+	var (
+		params = r.URL.Query()
+		q      = historyQuery{
+			kind:  params.Get("kind"),
+			node:  params.Get("node"),
+			limit: 100,
+		}
+		err error
+	)
+	if v := params.Get("limit"); len(v) > 0 {
+		if q.limit, err = strconv.Atoi(v); err != nil {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := params.Get("before"); len(v) > 0 {
+		if q.before, err = strconv.ParseUint(v, 10, 64); err != nil {
+			http.Error(w, "invalid before", http.StatusBadRequest)
+			return
+		}
+	}
+	writeJSON(w, mon.history.query(q))
+}
+
+// serveTriage serves the triage of a bad block, or one of its traces if the
+// hash is followed by /bad or /good.
+func (mon *NodeMonitor) serveTriage(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, APIPrefix+"triage/")
+	param, kind, _ := strings.Cut(path, "/")
+	b, err := hexutil.Decode(param)
+	if err != nil || len(b) != common.HashLength {
+		http.Error(w, "invalid hash", http.StatusBadRequest)
+		return
+	}
+	hash := common.BytesToHash(b)
+	switch kind {
+	case "":
+		res := mon.triage.result(hash)
+		if res == nil {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, res)
+	case "bad", "good":
+		trace := mon.triage.trace(hash, kind)
+		if trace == nil {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(trace)
+	default:
+		http.NotFound(w, r)
+	}
+}
@@ -0,0 +1,224 @@
+package nodes
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+func TestServeReport(t *testing.T) {
+	log.Root().SetHandler(log.LvlFilterHandler(
+		log.LvlCrit, log.StreamHandler(os.Stderr, log.TerminalFormat(false))))
+
+	// Disable the vuln check for tests
+	disableVulnCheck = true
+
+	nodes := []Node{
+		newTestNode("canon", 13_000_000, []uint64{0}, []int{0}),
+		newTestNode("fork", 12_999_900, []uint64{0, 12_999_800}, []int{0, 1}),
+	}
+	nm, err := NewMonitor(nodes, nil, time.Second, "Playdoh-net", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nm.doChecks()
+	srv := httptest.NewServer(nm.Handler())
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + APIPrefix + "report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("wrong status code: %d", res.StatusCode)
+	}
+	var report Report
+	if err := json.NewDecoder(res.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := report.Chain, "Playdoh-net"; have != want {
+		t.Fatalf("wrong chain, want %v, have %v", want, have)
+	}
+	if have, want := len(report.Numbers), len(nm.LastReport().Numbers); have != want {
+		t.Fatalf("wrong numbers, want %d, have %d", want, have)
+	}
+	// The test nodes report bad blocks
+	if len(report.BadBlocks) == 0 {
+		t.Fatalf("missing bad blocks")
+	}
+	for path, want := range map[string]int{
+		"badblocks/" + report.BadBlocks[0].Hash.Hex(): http.StatusOK,
+		"badblocks/0x1234":                          http.StatusBadRequest,
+		"headers/" + report.BadBlocks[0].Hash.Hex(): http.StatusNotFound,
+		"vulns/unknown":                             http.StatusNotFound,
+	} {
+		res, err := http.Get(srv.URL + APIPrefix + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != want {
+			t.Errorf("%v: wrong status code, want %d, have %d", path, want, res.StatusCode)
+		}
+	}
+}
+
+func TestServeStream(t *testing.T) {
+	log.Root().SetHandler(log.LvlFilterHandler(
+		log.LvlCrit, log.StreamHandler(os.Stderr, log.TerminalFormat(false))))
+
+	// Disable the vuln check for tests
+	disableVulnCheck = true
+
+	canon := newTestNode("canon", 13_000_000, []uint64{0}, []int{0})
+	nm, err := NewMonitor([]Node{canon}, nil, time.Second, "Playdoh-net", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nm.doChecks()
+	srv := httptest.NewUnstartedServer(nm.Handler())
+	srv.Config.ConnContext = ConnContext
+	srv.Start()
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + APIPrefix + "stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if have, want := res.Header.Get("Content-Type"), "text/event-stream"; have != want {
+		t.Fatalf("wrong content type, want %v, have %v", want, have)
+	}
+	reader := bufio.NewReader(res.Body)
+	nextReport := func() *Report {
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(line, "data: ") {
+				continue
+			}
+			var report Report
+			if err := json.Unmarshal([]byte(line[len("data: "):]), &report); err != nil {
+				t.Fatal(err)
+			}
+			return &report
+		}
+	}
+	// The current report is sent first
+	if have, want := nextReport().Numbers[0], 13_000_000; have != want {
+		t.Fatalf("wrong head, want %d, have %d", want, have)
+	}
+	// Followed by each new one
+	canon.head += 2
+	nm.doChecks()
+	if have, want := nextReport().Numbers[0], 13_000_002; have != want {
+		t.Fatalf("wrong head, want %d, have %d", want, have)
+	}
+}
+
+func TestStreamQueue(t *testing.T) {
+	q := &streamQueue{wake: make(chan struct{}, 1)}
+	// Only the latest report is kept, ahead of the other events
+	for i := 0; i < 10; i++ {
+		q.push(streamEvent{"report", &Report{Numbers: []int{i}}})
+	}
+	q.push(streamEvent{"alert", &Alert{Key: "a"}})
+	events, overflow := q.pop()
+	if overflow || len(events) != 2 {
+		t.Fatalf("wrong events: %v, overflow %v", events, overflow)
+	}
+	if r, ok := events[0].v.(*Report); !ok || r.Numbers[0] != 9 {
+		t.Errorf("wrong report: %v", events[0].v)
+	}
+	// Too many other events flag the client as behind, without blocking
+	for i := 0; i <= streamBacklog; i++ {
+		q.push(streamEvent{"alert", &Alert{Key: "a"}})
+	}
+	if _, overflow := q.pop(); !overflow {
+		t.Errorf("overflow not flagged")
+	}
+}
+
+func TestChainsHandler(t *testing.T) {
+	log.Root().SetHandler(log.LvlFilterHandler(
+		log.LvlCrit, log.StreamHandler(os.Stderr, log.TerminalFormat(false))))
+
+	// Disable the vuln check for tests
+	disableVulnCheck = true
+
+	var mons []*NodeMonitor
+	for _, name := range []string{"Mainnet", "Sepolia"} {
+		node := newTestNode(name, 13_000_000, []uint64{0}, []int{0})
+		nm, err := NewMonitor([]Node{node}, nil, time.Second, name, chainID(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		nm.doChecks()
+		mons = append(mons, nm)
+	}
+	www := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("www" + r.URL.Path))
+	})
+	srv := httptest.NewServer(ChainsHandler(www, mons))
+	defer srv.Close()
+
+	get := func(path string) *http.Response {
+		t.Helper()
+		res, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("%v: wrong status code: %d", path, res.StatusCode)
+		}
+		return res
+	}
+	res := get(APIPrefix + "chains")
+	var chains []chainJson
+	if err := json.NewDecoder(res.Body).Decode(&chains); err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if len(chains) != 2 || chains[1].Id != "sepolia" || chains[1].Path != "chains/sepolia/" {
+		t.Fatalf("wrong chains: %v", chains)
+	}
+	// Each chain is served under its path, the first one also at the root
+	for path, want := range map[string]string{
+		"/api/v1/report":                "Mainnet",
+		"/chains/mainnet/api/v1/report": "Mainnet",
+		"/chains/sepolia/api/v1/report": "Sepolia",
+	} {
+		res := get(path)
+		var report Report
+		if err := json.NewDecoder(res.Body).Decode(&report); err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if report.Chain != want {
+			t.Errorf("%v: wrong chain, have %v, want %v", path, report.Chain, want)
+		}
+	}
+	for path, want := range map[string]string{
+		"/":                          "www/",
+		"/chains/sepolia/":           "www/",
+		"/chains/sepolia/index.html": "www/index.html",
+	} {
+		res := get(path)
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if string(body) != want {
+			t.Errorf("%v: wrong file, have %q, want %q", path, body, want)
+		}
+	}
+}
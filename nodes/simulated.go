@@ -0,0 +1,451 @@
+package nodes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/eth"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/naoina/toml"
+)
+
+// Kinds of scenario events.
+const (
+	scenarioFork     = "fork"     // the node follows a chain of its own
+	scenarioReorg    = "reorg"    // the latest blocks of the node are replaced
+	scenarioStall    = "stall"    // the node stops importing blocks
+	scenarioOutage   = "outage"   // the node is unreachable
+	scenarioBadBlock = "badblock" // the node rejects a block
+)
+
+var errSimulatedOutage = errors.New("simulated outage")
+
+// scenario describes a simulated network: the nodes, and the events which
+// happen to them over time. The state of the network is a function of the time
+// since the start of the scenario, so a scenario plays out the same every time.
+type scenario struct {
+	BlockTime  string // how often the network produces a block, default "12s"
+	StartBlock uint64 // the head of the network when the scenario starts
+	Nodes      []scenarioNode
+	Events     []scenarioEvent
+
+	blockTime time.Duration
+	start     time.Time
+	path      string    // of the file, if loaded from one
+	modTime   time.Time // of the file when loaded
+	refs      int       // the nodes playing the scenario, protected by scenariosMu
+}
+
+type scenarioNode struct {
+	Name    string
+	Version string // the client version, defaults to "Simulated/v1.0.0"
+	Lag     uint64 // how many blocks the node is behind the network
+}
+
+type scenarioEvent struct {
+	At       string // since the start of the scenario, e.g. "2m"
+	Node     string
+	Kind     string // fork, reorg, stall, outage or badblock
+	Duration string // how long a fork, stall or outage lasts, forever if empty
+	Depth    uint64 // fork: how far below the head the fork starts; reorg: the number of blocks replaced
+
+	at, duration time.Duration
+	seed         int // of the blocks on the fork
+}
+
+var (
+	scenariosMu sync.Mutex
+	scenarios   = make(map[string]*scenario) // by path, so that the nodes share a clock
+)
+
+// loadScenario loads the scenario file at path. The scenario starts when first
+// loaded, later calls return the same scenario, until the file is modified or
+// the scenario is released by all its nodes.
+func loadScenario(path string) (*scenario, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	scenariosMu.Lock()
+	defer scenariosMu.Unlock()
+	fi, err := os.Stat(abs)
+	if err != nil {
+		return nil, err
+	}
+	if sc, ok := scenarios[abs]; ok && sc.modTime.Equal(fi.ModTime()) {
+		sc.refs++
+		return sc, nil
+	}
+	f, err := os.Open(abs)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var sc scenario
+	if err := toml.NewDecoder(f).Decode(&sc); err != nil {
+		return nil, fmt.Errorf("scenario %v: %v", path, err)
+	}
+	if err := sc.init(); err != nil {
+		return nil, fmt.Errorf("scenario %v: %v", path, err)
+	}
+	sc.path, sc.modTime, sc.refs = abs, fi.ModTime(), 1
+	scenarios[abs] = &sc
+	return &sc, nil
+}
+
+// releaseScenario drops a node of the scenario. Once none are left, the scenario
+// is removed from the cache, so that it restarts when loaded again.
+func releaseScenario(sc *scenario) {
+	scenariosMu.Lock()
+	defer scenariosMu.Unlock()
+	if sc.refs--; sc.refs == 0 && scenarios[sc.path] == sc {
+		delete(scenarios, sc.path)
+	}
+}
+
+// init validates the scenario and starts it.
+func (sc *scenario) init() error {
+	sc.blockTime = 12 * time.Second
+	if len(sc.BlockTime) > 0 {
+		d, err := time.ParseDuration(sc.BlockTime)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid block time %q", sc.BlockTime)
+		}
+		sc.blockTime = d
+	}
+	names := make(map[string]bool)
+	for i := range sc.Nodes {
+		if len(sc.Nodes[i].Name) == 0 || names[sc.Nodes[i].Name] {
+			return fmt.Errorf("node %d: missing or duplicate name", i)
+		}
+		names[sc.Nodes[i].Name] = true
+		if len(sc.Nodes[i].Version) == 0 {
+			sc.Nodes[i].Version = "Simulated/v1.0.0"
+		}
+	}
+	for i := range sc.Events {
+		ev := &sc.Events[i]
+		if !names[ev.Node] {
+			return fmt.Errorf("event %d: unknown node %q", i, ev.Node)
+		}
+		switch ev.Kind {
+		case scenarioFork, scenarioReorg, scenarioStall, scenarioOutage, scenarioBadBlock:
+		default:
+			return fmt.Errorf("event %d: unknown kind %q", i, ev.Kind)
+		}
+		var err error
+		if ev.at, err = time.ParseDuration(ev.At); err != nil || ev.at < 0 {
+			return fmt.Errorf("event %d: invalid time %q", i, ev.At)
+		}
+		if len(ev.Duration) > 0 {
+			if ev.duration, err = time.ParseDuration(ev.Duration); err != nil {
+				return fmt.Errorf("event %d: invalid duration %q", i, ev.Duration)
+			}
+		}
+		if ev.Kind == scenarioReorg {
+			if ev.Depth == 0 {
+				return fmt.Errorf("event %d: reorg without depth", i)
+			}
+			// A reorg is a fork which was followed for as many blocks as are
+			// replaced, up to and including the head at the time of the reorg
+			ev.duration = time.Duration(ev.Depth)*sc.blockTime + 1
+			if ev.at -= ev.duration - 1; ev.at < 0 {
+				return fmt.Errorf("event %d: reorg of %d blocks starts before the scenario", i, ev.Depth)
+			}
+		}
+		ev.seed = i + 1
+	}
+	sc.start = time.Now()
+	return nil
+}
+
+// elapsed returns the time since the start of the scenario.
+func (sc *scenario) elapsed() time.Duration {
+	return time.Since(sc.start)
+}
+
+// head returns the head of the network at the given time.
+func (sc *scenario) head(at time.Duration) uint64 {
+	return sc.StartBlock + uint64(at/sc.blockTime)
+}
+
+// active returns whether the event is ongoing at the given time.
+func (ev *scenarioEvent) active(at time.Duration) bool {
+	return ev.at <= at && (ev.duration == 0 || at < ev.at+ev.duration)
+}
+
+// SimulatedNode is a node of a scenario.
+type SimulatedNode struct {
+	sc     *scenario
+	conf   *scenarioNode
+	events []*scenarioEvent // of this node
+	mu     sync.Mutex
+	status int
+	shared bool // whether the scenario is cached, and released on close
+	closed bool
+}
+
+// NewSimulatedNode creates the named node of the scenario in the given file.
+func NewSimulatedNode(name, path string) (*SimulatedNode, error) {
+	sc, err := loadScenario(path)
+	if err != nil {
+		return nil, err
+	}
+	node, err := newSimulatedNode(sc, name)
+	if err != nil {
+		releaseScenario(sc)
+		return nil, err
+	}
+	node.shared = true
+	return node, nil
+}
+
+// Close releases the scenario of the node.
+func (node *SimulatedNode) Close() error {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	if node.shared && !node.closed {
+		node.closed = true
+		releaseScenario(node.sc)
+	}
+	return nil
+}
+
+func newSimulatedNode(sc *scenario, name string) (*SimulatedNode, error) {
+	node := &SimulatedNode{sc: sc}
+	for i := range sc.Nodes {
+		if sc.Nodes[i].Name == name {
+			node.conf = &sc.Nodes[i]
+		}
+	}
+	if node.conf == nil {
+		return nil, fmt.Errorf("node %q not in scenario", name)
+	}
+	for i := range sc.Events {
+		if sc.Events[i].Node == name {
+			node.events = append(node.events, &sc.Events[i])
+		}
+	}
+	return node, nil
+}
+
+// ongoing returns the latest started event of the given kind which is ongoing
+// at the given time, or nil if there is none.
+func (node *SimulatedNode) ongoing(kind string, at time.Duration) *scenarioEvent {
+	var found *scenarioEvent
+	for _, ev := range node.events {
+		if ev.Kind == kind && ev.active(at) && (found == nil || ev.at >= found.at) {
+			found = ev
+		}
+	}
+	return found
+}
+
+// headAt returns the head of the node at the given time. A stalled node stays
+// at the head it had when it stalled, and catches up once the stall is over.
+func (node *SimulatedNode) headAt(at time.Duration) uint64 {
+	if ev := node.ongoing(scenarioStall, at); ev != nil {
+		at = ev.at
+	}
+	head := node.sc.head(at)
+	if head < node.conf.Lag {
+		return 0
+	}
+	return head - node.conf.Lag
+}
+
+// forkBase returns the first block of the fork: for a fork, counted from the
+// head when it starts, for a reorg, from the head when it ends.
+func (node *SimulatedNode) forkBase(ev *scenarioEvent) uint64 {
+	base := node.headAt(ev.at) + 1
+	if ev.Kind == scenarioReorg {
+		base = node.headAt(ev.at+ev.duration-1) + 1
+	}
+	if ev.Depth >= base {
+		return 1
+	}
+	return base - ev.Depth
+}
+
+// seedAt returns the seed of the block at the given number, as seen by the node
+// at the given time.
+func (node *SimulatedNode) seedAt(num uint64, at time.Duration) int {
+	var fork *scenarioEvent
+	for _, ev := range node.events {
+		if (ev.Kind == scenarioFork || ev.Kind == scenarioReorg) && ev.active(at) && (fork == nil || ev.at >= fork.at) {
+			fork = ev
+		}
+	}
+	if fork != nil && num >= node.forkBase(fork) {
+		return fork.seed
+	}
+	return 0
+}
+
+func (node *SimulatedNode) blockAt(num uint64, at time.Duration) *blockInfo {
+	if num > node.headAt(at) {
+		return nil
+	}
+	bl := &blockInfo{num: num, hash: hashFromSeed(node.seedAt(num, at), num)}
+	if num > 0 {
+		bl.pHash = hashFromSeed(node.seedAt(num-1, at), num-1)
+	}
+	return bl
+}
+
+func (node *SimulatedNode) Version(ctx context.Context) (string, error) {
+	if node.ongoing(scenarioOutage, node.sc.elapsed()) != nil {
+		return "", errSimulatedOutage
+	}
+	return node.conf.Version, nil
+}
+
+func (node *SimulatedNode) Name() string {
+	return node.conf.Name
+}
+
+func (node *SimulatedNode) Status() int {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	return node.status
+}
+
+func (node *SimulatedNode) SetStatus(status int) {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	node.status = status
+}
+
+// LastProgress returns the time when the node last imported a block.
+func (node *SimulatedNode) LastProgress() int64 {
+	at := node.sc.elapsed()
+	if ev := node.ongoing(scenarioStall, at); ev != nil {
+		at = ev.at
+	}
+	progress := at - at%node.sc.blockTime
+	// A stall which ended since, was followed by catching up
+	for _, ev := range node.events {
+		if ev.Kind == scenarioStall && ev.duration > 0 && !ev.active(at) {
+			if end := ev.at + ev.duration; end <= at && end > progress {
+				progress = end
+			}
+		}
+	}
+	return node.sc.start.Add(progress).Unix()
+}
+
+// SlotTime returns the block time of the scenario.
+func (node *SimulatedNode) SlotTime() time.Duration {
+	return node.sc.blockTime
+}
+
+func (node *SimulatedNode) UpdateLatest(ctx context.Context) error {
+	if node.ongoing(scenarioOutage, node.sc.elapsed()) != nil {
+		return errSimulatedOutage
+	}
+	return nil
+}
+
+func (node *SimulatedNode) BlockAt(ctx context.Context, num uint64, force bool) *blockInfo {
+	return node.blockAt(num, node.sc.elapsed())
+}
+
+func (node *SimulatedNode) HashAt(ctx context.Context, num uint64, force bool) common.Hash {
+	if bl := node.BlockAt(ctx, num, force); bl != nil {
+		return bl.hash
+	}
+	return common.Hash{}
+}
+
+func (node *SimulatedNode) HeadNum() uint64 {
+	return node.headAt(node.sc.elapsed())
+}
+
+// Finalized returns the block 64 blocks behind the head.
+func (node *SimulatedNode) Finalized() *blockInfo {
+	at := node.sc.elapsed()
+	if head := node.headAt(at); head >= 64 {
+		return node.blockAt(head-64, at)
+	}
+	return nil
+}
+
+// Safe returns the block 32 blocks behind the head.
+func (node *SimulatedNode) Safe() *blockInfo {
+	at := node.sc.elapsed()
+	if head := node.headAt(at); head >= 32 {
+		return node.blockAt(head-32, at)
+	}
+	return nil
+}
+
+// BadBlocks returns the blocks the node rejected so far: for each bad block
+// event, the block following the head of the node at that time.
+func (node *SimulatedNode) BadBlocks(ctx context.Context) []*eth.BadBlockArgs {
+	at := node.sc.elapsed()
+	var blocks []*eth.BadBlockArgs
+	for _, ev := range node.events {
+		if ev.Kind != scenarioBadBlock || ev.at > at {
+			continue
+		}
+		num := node.headAt(ev.at) + 1
+		h := &types.Header{
+			ParentHash: hashFromSeed(node.seedAt(num-1, ev.at), num-1),
+			Root:       hashFromSeed(ev.seed, num),
+			Difficulty: common.Big0,
+			Number:     new(big.Int).SetUint64(num),
+			GasLimit:   30_000_000,
+			GasUsed:    21_000,
+			Time:       num * uint64(node.sc.blockTime/time.Second),
+			Extra:      []byte("simulated bad block"),
+		}
+		bl := types.NewBlock(h, nil, nil, nil, trie.NewStackTrie(nil))
+		data, err := rlp.EncodeToBytes(bl)
+		if err != nil {
+			panic(err)
+		}
+		blocks = append(blocks, &eth.BadBlockArgs{Hash: bl.Hash(), RLP: hexutil.Encode(data)})
+	}
+	return blocks
+}
+
+func (node *SimulatedNode) BadBlockCount() int {
+	return len(node.BadBlocks(context.Background()))
+}
+
+// Reorgs returns the reorgs of the node so far: the forks which were abandoned,
+// and the reorg events.
+func (node *SimulatedNode) Reorgs() []*Reorg {
+	at := node.sc.elapsed()
+	var reorgs []*Reorg
+	for _, ev := range node.events {
+		if (ev.Kind != scenarioFork && ev.Kind != scenarioReorg) || ev.duration == 0 || ev.at+ev.duration > at {
+			continue
+		}
+		end := ev.at + ev.duration
+		head := node.headAt(end - 1)
+		base := node.forkBase(ev)
+		if head < base {
+			continue // no blocks were replaced
+		}
+		reorgs = append(reorgs, &Reorg{
+			Node:    node.conf.Name,
+			Number:  head,
+			OldHash: hashFromSeed(ev.seed, head),
+			NewHash: hashFromSeed(0, head),
+			Depth:   int(head - base + 1),
+			Time:    node.sc.start.Add(end),
+		})
+	}
+	return reorgs
+}
@@ -0,0 +1,213 @@
+package nodes
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/naoina/toml"
+)
+
+const testScenario = `
+block_time = "12s"
+start_block = 1000
+
+[[nodes]]
+name = "a"
+
+[[nodes]]
+name = "b"
+
+[[nodes]]
+name = "c"
+
+[[events]]
+at = "30s"
+node = "b"
+kind = "badblock"
+
+[[events]]
+at = "1m"
+node = "b"
+kind = "fork"
+depth = 2
+duration = "5m"
+
+[[events]]
+at = "2m"
+node = "c"
+kind = "stall"
+
+[[events]]
+at = "3m"
+node = "a"
+kind = "outage"
+duration = "1m"
+
+[[events]]
+at = "10m"
+node = "a"
+kind = "reorg"
+depth = 3
+`
+
+func TestSimulatedNetwork(t *testing.T) {
+	log.Root().SetHandler(log.LvlFilterHandler(
+		log.LvlCrit, log.StreamHandler(os.Stderr, log.TerminalFormat(false))))
+
+	// Disable the vuln check for tests
+	disableVulnCheck = true
+
+	path := filepath.Join(t.TempDir(), "scenario.toml")
+	if err := os.WriteFile(path, []byte(testScenario), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var nodes []Node
+	for _, name := range []string{"a", "b", "c"} {
+		node, err := NewSimulatedNode(name, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nodes = append(nodes, node)
+	}
+	if _, err := NewSimulatedNode("d", path); err == nil {
+		t.Fatal("unknown node accepted")
+	}
+	sc := nodes[0].(*SimulatedNode).sc
+	if sc != nodes[1].(*SimulatedNode).sc {
+		t.Fatal("nodes of a scenario do not share it")
+	}
+	nm, err := NewMonitor(nodes, nil, time.Second, "Playdoh-net", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nm.doChecks()
+	// advance runs a round of checks, the given time into the scenario
+	advance := func(d time.Duration) (*Report, map[string]*clientJson) {
+		t.Helper()
+		sc.start = time.Now().Add(-d)
+		nm.lastBadBlocks = time.Time{}
+		nm.doChecks()
+		r := nm.LastReport()
+		cols := make(map[string]*clientJson)
+		for _, c := range r.Cols {
+			cols[c.Name] = c
+		}
+		return r, cols
+	}
+
+	r, _ := advance(90 * time.Second)
+	if have, want := nodes[0].HeadNum(), uint64(1007); have != want {
+		t.Errorf("wrong head, have %d, want %d", have, want)
+	}
+	if len(r.Splits) == 0 || r.Splits[0].Number != 1004 {
+		t.Errorf("fork not reported: %v", r.Splits)
+	}
+	if len(r.BadBlocks) != 1 || r.BadBlocks[0].Number.Uint64() != 1003 {
+		t.Errorf("bad block not reported: %v", r.BadBlocks)
+	}
+
+	_, cols := advance(3*time.Minute + 30*time.Second)
+	if have, want := cols["a"].Status, NodeStatusUnreachable; have != want {
+		t.Errorf("node a: wrong status during outage, have %v, want %v", StatusName(have), StatusName(want))
+	}
+
+	r, cols = advance(8 * time.Minute)
+	if have, want := cols["a"].Status, NodeStatusOK; have != want {
+		t.Errorf("node a: wrong status after outage, have %v, want %v", StatusName(have), StatusName(want))
+	}
+	if have, want := cols["c"].Status, NodeStatusStalled; have != want {
+		t.Errorf("node c: wrong status, have %v, want %v", StatusName(have), StatusName(want))
+	}
+	if len(r.Splits) != 0 {
+		t.Errorf("fork not resolved: %v", r.Splits)
+	}
+	if have, want := cols["b"].Reorgs, 1; have != want {
+		t.Errorf("node b: wrong reorg count, have %d, want %d", have, want)
+	}
+	reorg := nodes[1].(*SimulatedNode).Reorgs()[0]
+	if reorg.Number != 1029 || reorg.Depth != 26 {
+		t.Errorf("node b: wrong reorg, number %d, depth %d", reorg.Number, reorg.Depth)
+	}
+
+	_, cols = advance(11 * time.Minute)
+	if have, want := cols["a"].Reorgs, 1; have != want {
+		t.Errorf("node a: wrong reorg count, have %d, want %d", have, want)
+	}
+	if reorg := nodes[0].(*SimulatedNode).Reorgs()[0]; reorg.Number != 1050 || reorg.Depth != 3 {
+		t.Errorf("node a: wrong reorg, number %d, depth %d", reorg.Number, reorg.Depth)
+	}
+}
+
+func TestScenarioExample(t *testing.T) {
+	sc, err := loadScenario(filepath.Join("..", "scenario.toml.example"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sc.Nodes) != 3 || len(sc.Events) != 5 {
+		t.Errorf("wrong scenario, %d nodes and %d events", len(sc.Nodes), len(sc.Events))
+	}
+	for _, n := range sc.Nodes {
+		if _, err := newSimulatedNode(sc, n.Name); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestScenarioCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scenario.toml")
+	if err := os.WriteFile(path, []byte(testScenario), 0644); err != nil {
+		t.Fatal(err)
+	}
+	a, err := NewSimulatedNode("a", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewSimulatedNode("b", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.sc != b.sc {
+		t.Fatal("nodes of one scenario don't share it")
+	}
+	// A node recreated after the file was modified restarts the scenario
+	modTime := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	a2, err := NewSimulatedNode("a", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a2.sc == a.sc {
+		t.Fatal("modified scenario not reloaded")
+	}
+	// Once all nodes are closed, the scenario is dropped
+	for _, n := range []*SimulatedNode{a, b, a2, a2} {
+		n.Close()
+	}
+	scenariosMu.Lock()
+	defer scenariosMu.Unlock()
+	if sc := scenarios[a2.sc.path]; sc != nil {
+		t.Errorf("released scenario still cached")
+	}
+}
+
+func TestScenarioInvalid(t *testing.T) {
+	for i, events := range []string{
+		`at = "-1m"` + "\nnode = \"a\"\nkind = \"stall\"",
+		// The reorg would start 36s before the scenario
+		`at = "0s"` + "\nnode = \"a\"\nkind = \"reorg\"\ndepth = 3",
+	} {
+		sc := new(scenario)
+		err := toml.Unmarshal([]byte("[[nodes]]\nname = \"a\"\n[[events]]\n"+events), sc)
+		if err != nil {
+			t.Fatalf("test %d: %v", i, err)
+		}
+		if err := sc.init(); err == nil {
+			t.Errorf("test %d: invalid scenario accepted", i)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package nodes
+
+import (
+	"context"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Split summaries, explaining how the blocks at a split point differ
+const (
+	SplitDifferentTxs       = "different transactions"                 // the blocks have different contents
+	SplitDifferentExecution = "same transactions, different execution" // the same contents, executed differently
+	SplitDifferentHeader    = "different header"                       // only other header fields differ
+)
+
+// splitJson describes a point where two nodes diverged, as shown in the report.
+type splitJson struct {
+	Nodes          []string      `json:"nodes"`
+	Number         uint64        `json:"number"` // the first block they disagree on
+	Length         int64         `json:"length"` // the number of blocks not accepted by both
+	Hashes         []common.Hash `json:"hashes"` // the blocks at the split point, per node
+	DivergedFields []string      `json:"divergedFields,omitempty"`
+	Summary        string        `json:"summary,omitempty"` // empty if the headers are not available
+}
+
+// diffHeaders returns the names of the consensus-relevant header fields which
+// differ between a and b.
+func diffHeaders(a, b *types.Header) []string {
+	var fields []string
+	if a.Root != b.Root {
+		fields = append(fields, "stateRoot")
+	}
+	if a.ReceiptHash != b.ReceiptHash {
+		fields = append(fields, "receiptsRoot")
+	}
+	if a.TxHash != b.TxHash {
+		fields = append(fields, "transactionsRoot")
+	}
+	if a.GasUsed != b.GasUsed {
+		fields = append(fields, "gasUsed")
+	}
+	if a.Bloom != b.Bloom {
+		fields = append(fields, "logsBloom")
+	}
+	if !equalBig(a.BaseFee, b.BaseFee) {
+		fields = append(fields, "baseFeePerGas")
+	}
+	if !equalHashPtr(a.WithdrawalsHash, b.WithdrawalsHash) {
+		fields = append(fields, "withdrawalsRoot")
+	}
+	return fields
+}
+
+// summarizeDiff tells whether the diverged fields point at different block
+// contents, or at the same contents being executed differently.
+func summarizeDiff(fields []string) string {
+	var executed bool
+	for _, f := range fields {
+		switch f {
+		case "transactionsRoot", "withdrawalsRoot":
+			return SplitDifferentTxs
+		case "stateRoot", "receiptsRoot", "gasUsed", "logsBloom":
+			executed = true
+		}
+	}
+	if executed {
+		return SplitDifferentExecution
+	}
+	return SplitDifferentHeader
+}
+
+func equalBig(a, b *big.Int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Cmp(b) == 0
+}
+
+func equalHashPtr(a, b *common.Hash) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// describeSplits diffs the headers of the diverging nodes at each split point,
+// as far as they are available in the block database.
+func (mon *NodeMonitor) describeSplits(ctx context.Context, splits []*chainSplit, nodes []Node) []*splitJson {
+	byName := make(map[string]Node)
+	for _, n := range nodes {
+		byName[n.Name()] = n
+	}
+	list := make([]*splitJson, 0, len(splits))
+	for _, s := range splits {
+		sj := &splitJson{
+			Nodes:  []string{s.a, s.b},
+			Number: s.num,
+			Length: s.length,
+		}
+		var headers []*types.Header
+		for _, name := range sj.Nodes {
+			var hash common.Hash
+			if n, ok := byName[name]; ok {
+				hash = n.HashAt(ctx, s.num, false)
+			}
+			sj.Hashes = append(sj.Hashes, hash)
+			if mon.backend != nil && hash != (common.Hash{}) {
+				if h := mon.backend.get(hash); h != nil {
+					headers = append(headers, h)
+				}
+			}
+		}
+		if len(headers) == 2 {
+			sj.DivergedFields = diffHeaders(headers[0], headers[1])
+			sj.Summary = summarizeDiff(sj.DivergedFields)
+		}
+		list = append(list, sj)
+	}
+	return list
+}
@@ -0,0 +1,118 @@
+package nodes
+
+import (
+	"context"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+func TestDiffHeaders(t *testing.T) {
+	base := func() *types.Header {
+		return &types.Header{
+			Number:  big.NewInt(100),
+			Root:    common.Hash{1},
+			TxHash:  common.Hash{2},
+			GasUsed: 21000,
+			BaseFee: big.NewInt(7),
+		}
+	}
+	for i, tt := range []struct {
+		modify  func(h *types.Header)
+		fields  []string
+		summary string
+	}{
+		{
+			modify:  func(h *types.Header) { h.Extra = []byte("other") },
+			summary: SplitDifferentHeader,
+		},
+		{
+			modify: func(h *types.Header) {
+				h.Root = common.Hash{3}
+				h.GasUsed = 42000
+			},
+			fields:  []string{"stateRoot", "gasUsed"},
+			summary: SplitDifferentExecution,
+		},
+		{
+			modify: func(h *types.Header) {
+				h.TxHash = common.Hash{3}
+				h.Root = common.Hash{3}
+			},
+			fields:  []string{"stateRoot", "transactionsRoot"},
+			summary: SplitDifferentTxs,
+		},
+		{
+			modify: func(h *types.Header) {
+				h.BaseFee = nil
+				h.WithdrawalsHash = &common.Hash{}
+			},
+			fields:  []string{"baseFeePerGas", "withdrawalsRoot"},
+			summary: SplitDifferentTxs,
+		},
+	} {
+		b := base()
+		tt.modify(b)
+		fields := diffHeaders(base(), b)
+		if !reflect.DeepEqual(fields, tt.fields) {
+			t.Errorf("test %d: wrong fields, have %v, want %v", i, fields, tt.fields)
+		}
+		if have := summarizeDiff(fields); have != tt.summary {
+			t.Errorf("test %d: wrong summary, have %q, want %q", i, have, tt.summary)
+		}
+	}
+}
+
+func TestDescribeSplits(t *testing.T) {
+	log.Root().SetHandler(log.LvlFilterHandler(
+		log.LvlCrit, log.StreamHandler(os.Stderr, log.TerminalFormat(false))))
+
+	// Disable the vuln check for tests
+	disableVulnCheck = true
+
+	db, err := openBlockDB(filepath.Join(t.TempDir(), "blockDB"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	canon := newTestNode("canon", 13_000_000, []uint64{0}, []int{0})
+	fork := newTestNode("fork", 12_999_900, []uint64{0, 12_999_800}, []int{0, 1})
+	// Store the headers at the split point, with the same transactions
+	// executed differently
+	for _, n := range []*testNode{canon, fork} {
+		db.add(n.HashAt(context.Background(), 12_999_800, false), &types.Header{
+			Number:     big.NewInt(12_999_800),
+			Difficulty: common.Big0,
+			TxHash:     common.Hash{1},
+			Root:       common.Hash{byte(n.seedAt(12_999_800))},
+		})
+	}
+	nm, err := NewMonitor([]Node{canon, fork}, db, time.Second, "Playdoh-net", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nm.doChecks()
+	r := nm.LastReport()
+	if len(r.Splits) != 1 {
+		t.Fatalf("wrong splits, have %d, want 1", len(r.Splits))
+	}
+	split := r.Splits[0]
+	if have, want := split.Number, uint64(12_999_800); have != want {
+		t.Errorf("wrong split number, have %d, want %d", have, want)
+	}
+	if split.Hashes[0] == split.Hashes[1] {
+		t.Errorf("split hashes should differ")
+	}
+	if have, want := split.DivergedFields, []string{"stateRoot"}; !reflect.DeepEqual(have, want) {
+		t.Errorf("wrong diverged fields, have %v, want %v", have, want)
+	}
+	if have, want := split.Summary, SplitDifferentExecution; have != want {
+		t.Errorf("wrong summary, have %q, want %q", have, want)
+	}
+}
@@ -0,0 +1,188 @@
+package nodes
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+const (
+	maxReorgDepth = 64  // how far back a new head is reconnected to the cached chain
+	maxReorgs     = 100 // how many reorgs are kept per node
+)
+
+var (
+	errSubscriptionClosed = errors.New("subscription closed")
+	errAncestorMissing    = errors.New("ancestor not fetched")
+)
+
+// resubscribeDelay is how long a node polls after losing its head subscription,
+// before subscribing again.
+var resubscribeDelay = 10 * time.Second
+
+// Reorg is a chain reorganisation observed on a node.
+type Reorg struct {
+	Node    string      `json:"node"`
+	Number  uint64      `json:"number"`  // the highest number where a block was replaced
+	OldHash common.Hash `json:"oldHash"` // the replaced block
+	NewHash common.Hash `json:"newHash"` // the block replacing it
+	Depth   int         `json:"depth"`   // the number of blocks replaced
+	Time    time.Time   `json:"time"`
+}
+
+// reorgRecorder is implemented by nodes which keep track of the reorgs they observe.
+type reorgRecorder interface {
+	Reorgs() []*Reorg
+}
+
+// headSubscriber is implemented by method callers which can push new heads.
+type headSubscriber interface {
+	SubscribeNewHead(ctx context.Context, ch chan<- *types.Header) (ethereum.Subscription, error)
+}
+
+func (caller *JSONRPCMethodCaller) SubscribeNewHead(ctx context.Context, ch chan<- *types.Header) (ethereum.Subscription, error) {
+	ctx, cancel := context.WithTimeout(ctx, caller.timeout)
+	defer cancel()
+	return caller.ethCli.SubscribeNewHead(ctx, ch)
+}
+
+// isWebsocket returns whether the url is for a websocket connection.
+func isWebsocket(url string) bool {
+	return strings.HasPrefix(url, "ws://") || strings.HasPrefix(url, "wss://")
+}
+
+// recordReorg records a reorg of the given depth, where the block 'oldBlock'
+// was replaced by 'newBlock'.
+func (node *RemoteNode) recordReorg(oldBlock, newBlock *blockInfo, depth int) {
+	log.Info("Node reorged", "name", node.name, "number", newBlock.num, "old", oldBlock.hash, "new", newBlock.hash, "size", depth)
+	node.reorgCounter.Inc(1)
+	node.reorgs = append(node.reorgs, &Reorg{
+		Node:    node.name,
+		Number:  newBlock.num,
+		OldHash: oldBlock.hash,
+		NewHash: newBlock.hash,
+		Depth:   depth,
+		Time:    time.Now(),
+	})
+	if len(node.reorgs) > maxReorgs {
+		node.reorgs = node.reorgs[len(node.reorgs)-maxReorgs:]
+	}
+}
+
+// subscribe starts following the new heads of the node. While the subscription
+// is live, the node is not polled for its head.
+func (node *RemoteNode) subscribe(sub headSubscriber) {
+	node.quitCh = make(chan struct{})
+	node.wg.Add(1)
+	go node.followHeads(sub)
+}
+
+// Close stops the head subscription, if any, and closes the connection to the
+// node.
+func (node *RemoteNode) Close() error {
+	if node.quitCh != nil {
+		close(node.quitCh)
+		node.wg.Wait()
+	}
+	if c, ok := node.RPCMethodCaller.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+func (node *RemoteNode) setSubscribed(subscribed bool) {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	node.subscribed = subscribed
+}
+
+func (node *RemoteNode) followHeads(sub headSubscriber) {
+	defer node.wg.Done()
+	ctx, cancel := quitContext(node.quitCh)
+	defer cancel()
+	for {
+		heads := make(chan *types.Header, 16)
+		start := time.Now()
+		s, err := sub.SubscribeNewHead(ctx, heads)
+		node.requests.observe("eth_subscribe", start, err)
+		if err != nil {
+			log.Warn("Failed to subscribe to new heads, polling", "node", node.name, "error", err)
+		} else {
+			log.Info("Subscribed to new heads", "node", node.name)
+			node.setSubscribed(true)
+			err = node.handleHeads(ctx, s, heads)
+			node.setSubscribed(false)
+			s.Unsubscribe()
+			if err == nil {
+				return // closed
+			}
+			log.Warn("Head subscription failed, falling back to polling", "node", node.name, "error", err)
+		}
+		select {
+		case <-node.quitCh:
+			return
+		case <-time.After(resubscribeDelay):
+		}
+	}
+}
+
+// handleHeads processes the new heads until the subscription fails, or the
+// node is closed.
+func (node *RemoteNode) handleHeads(ctx context.Context, s ethereum.Subscription, heads chan *types.Header) error {
+	for {
+		select {
+		case <-node.quitCh:
+			return nil
+		case err := <-s.Err():
+			if err == nil {
+				err = errSubscriptionClosed
+			}
+			return err
+		case h := <-heads:
+			// Refetch the reorged ancestors first, not to hold the lock
+			// during the requests
+			ancestors := node.fetchAncestors(ctx, h)
+			node.mu.Lock()
+			node.setLatest(node.addHeader(h, func(num uint64) (*types.Header, error) {
+				if parent, ok := ancestors[num]; ok {
+					return parent, nil
+				}
+				return nil, errAncestorMissing
+			}))
+			node.mu.Unlock()
+		}
+	}
+}
+
+// fetchAncestors fetches the ancestors of the header which replace the blocks
+// in the cached chain, up to maxReorgDepth of them.
+func (node *RemoteNode) fetchAncestors(ctx context.Context, h *types.Header) map[uint64]*types.Header {
+	var (
+		ancestors = make(map[uint64]*types.Header)
+		num       = h.Number.Uint64()
+		pHash     = h.ParentHash
+	)
+	for num > 0 && len(ancestors) < maxReorgDepth {
+		node.mu.RLock()
+		cached, ok := node.chainHistory[num-1]
+		node.mu.RUnlock()
+		if !ok || cached.hash == pHash {
+			break // not reorged
+		}
+		parent, err := node.throttledGetHeader(ctx, new(big.Int).SetUint64(num-1))
+		if err != nil {
+			break
+		}
+		ancestors[num-1] = parent
+		num, pHash = num-1, parent.ParentHash
+	}
+	return ancestors
+}
@@ -0,0 +1,117 @@
+package nodes
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+func TestHeadSubscription(t *testing.T) {
+	log.Root().SetHandler(log.LvlFilterHandler(
+		log.LvlCrit, log.StreamHandler(os.Stderr, log.TerminalFormat(false))))
+	resubscribeDelay = 10 * time.Millisecond
+
+	chain := newMockChain(10)
+	srv := newMockServer(chain)
+	defer srv.Close()
+
+	node, err := NewRPCNode("ws", "", srv.wsURL(), nil, nil, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node.Close()
+	waitSub := func() {
+		t.Helper()
+		select {
+		case <-chain.subbing:
+		case <-time.After(5 * time.Second):
+			t.Fatal("node did not subscribe")
+		}
+	}
+	// waitHead waits until the node has the given head.
+	waitHead := func(num uint64, hash common.Hash) {
+		t.Helper()
+		for i := 0; i < 500; i++ {
+			node.mu.RLock()
+			latest := node.latest
+			node.mu.RUnlock()
+			if latest != nil && latest.num == num && latest.hash == hash {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("node did not get head %d", num)
+	}
+	waitSub()
+	chain.announce()
+	waitHead(9, chain.hash(9))
+
+	// Extend the chain, the heads arrive without polling
+	for i := 0; i < 2; i++ {
+		chain.extend(1, 0)
+		chain.announce()
+	}
+	waitHead(11, chain.hash(11))
+
+	// Reorg the last two blocks, the node should record it
+	chain.reorg(2, 1)
+	chain.announce()
+	waitHead(11, chain.hash(11))
+	if have := node.HashAt(context.Background(), 10, false); have != chain.hash(10) {
+		t.Errorf("reorged block not refetched")
+	}
+	reorgs := node.Reorgs()
+	if len(reorgs) != 1 {
+		t.Fatalf("wrong reorgs, have %d, want 1", len(reorgs))
+	}
+	if have, want := reorgs[0].Depth, 2; have != want {
+		t.Errorf("wrong reorg depth, have %d, want %d", have, want)
+	}
+	// Drop the connection, the node should fall back to polling
+	resubscribeDelay = time.Hour
+	srv.restart()
+	for i := 0; ; i++ {
+		node.mu.RLock()
+		subscribed := node.subscribed
+		node.mu.RUnlock()
+		if !subscribed {
+			break
+		}
+		if i == 500 {
+			t.Fatal("node did not notice the dropped subscription")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	chain.extend(1, 0)
+	if err := node.UpdateLatest(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := node.HeadNum(), uint64(12); have != want {
+		t.Fatalf("wrong head after polling, have %d, want %d", have, want)
+	}
+}
+
+func TestProviderSubscription(t *testing.T) {
+	log.Root().SetHandler(log.LvlFilterHandler(
+		log.LvlCrit, log.StreamHandler(os.Stderr, log.TerminalFormat(false))))
+
+	chain := newMockChain(10)
+	srv := newMockServer(chain)
+	defer srv.Close()
+
+	// With the websocket endpoint of the provider, the node subscribes
+	node, err := NewInfuraNode("infura", "", "key", srv.wsURL()+"/ws/v3/", nil, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node.Close()
+	select {
+	case <-chain.subbing:
+	case <-time.After(5 * time.Second):
+		t.Fatal("node did not subscribe")
+	}
+}
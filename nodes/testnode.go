@@ -1,6 +1,7 @@
 package nodes
 
 import (
+	"context"
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
@@ -56,7 +57,7 @@ func (t *testNode) Status() int {
 
 func (t *testNode) SetStatus(int) {}
 
-func (t *testNode) Version() (string, error) {
+func (t *testNode) Version(ctx context.Context) (string, error) {
 	return "TestNode/v0.1/darwin/go1.4.1", nil
 }
 
@@ -64,11 +65,11 @@ func (t *testNode) Name() string {
 	return fmt.Sprintf("TestNode(%v)", t.id)
 }
 
-func (t *testNode) UpdateLatest() error {
+func (t *testNode) UpdateLatest(ctx context.Context) error {
 	return nil
 }
 
-func (t *testNode) BlockAt(num uint64, force bool) *blockInfo {
+func (t *testNode) BlockAt(ctx context.Context, num uint64, force bool) *blockInfo {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 	if num > uint64(t.head) {
@@ -86,8 +87,8 @@ func (t *testNode) BlockAt(num uint64, force bool) *blockInfo {
 	}
 }
 
-func (t *testNode) HashAt(num uint64, force bool) common.Hash {
-	if bl := t.BlockAt(num, force); bl != nil {
+func (t *testNode) HashAt(ctx context.Context, num uint64, force bool) common.Hash {
+	if bl := t.BlockAt(ctx, num, force); bl != nil {
 		return bl.hash
 	}
 	return common.Hash{}
@@ -97,11 +98,27 @@ func (t *testNode) HeadNum() uint64 {
 	return uint64(t.head)
 }
 
+// Finalized returns the block 64 blocks behind the head.
+func (t *testNode) Finalized() *blockInfo {
+	if t.head < 64 {
+		return nil
+	}
+	return t.BlockAt(context.Background(), uint64(t.head-64), false)
+}
+
+// Safe returns the block 32 blocks behind the head.
+func (t *testNode) Safe() *blockInfo {
+	if t.head < 32 {
+		return nil
+	}
+	return t.BlockAt(context.Background(), uint64(t.head-32), false)
+}
+
 func (t *testNode) LastProgress() int64 {
 	return 0
 }
 
-func (t *testNode) BadBlocks() []*eth.BadBlockArgs {
+func (t *testNode) BadBlocks(ctx context.Context) []*eth.BadBlockArgs {
 	var rlpHex string
 	var blHash common.Hash
 	var jsonBlock []byte
@@ -145,7 +162,7 @@ func (t *testNode) BadBlocks() []*eth.BadBlockArgs {
 }
 
 func (node *testNode) BadBlockCount() int {
-	return len(node.BadBlocks())
+	return len(node.BadBlocks(context.Background()))
 }
 
 func newTestNode(id string, head int, forks []uint64, seeds []int) *testNode {
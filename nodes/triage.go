@@ -0,0 +1,445 @@
+package nodes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+const (
+	traceTimeout       = time.Minute      // how long a node may take to trace a block
+	traceRetryDelay    = time.Minute      // the delay before tracing a bad block again, doubled on each failure
+	maxTraceAttempts   = 5                // how often the tracing of a bad block is attempted
+	traceRetryInterval = 10 * time.Second // how often the failed traces are checked for a retry
+)
+
+var errTracingUnsupported = errors.New("tracing not supported")
+
+// traceConfig is passed to the tracing methods. It leaves out storage and
+// memory, which would make the traces huge.
+var traceConfig = map[string]interface{}{
+	"disableStorage":   true,
+	"enableMemory":     false,
+	"enableReturnData": false,
+	"timeout":          traceTimeout.String(),
+}
+
+// blockTracer is implemented by nodes (and method callers) which can trace the
+// execution of blocks. The traces are returned as produced by the struct logger.
+type blockTracer interface {
+	TraceBadBlock(ctx context.Context, hash common.Hash) (json.RawMessage, error)
+	TraceBlockByHash(ctx context.Context, hash common.Hash) (json.RawMessage, error)
+}
+
+func (caller *JSONRPCMethodCaller) TraceBadBlock(ctx context.Context, hash common.Hash) (json.RawMessage, error) {
+	var res json.RawMessage
+	ctx, cancel := context.WithTimeout(ctx, traceTimeout)
+	defer cancel()
+	err := caller.rpcCli.CallContext(ctx, &res, "debug_traceBadBlock", hash, traceConfig)
+	return res, err
+}
+
+func (caller *JSONRPCMethodCaller) TraceBlockByHash(ctx context.Context, hash common.Hash) (json.RawMessage, error) {
+	var res json.RawMessage
+	ctx, cancel := context.WithTimeout(ctx, traceTimeout)
+	defer cancel()
+	err := caller.rpcCli.CallContext(ctx, &res, "debug_traceBlockByHash", hash, traceConfig)
+	return res, err
+}
+
+func (node *RemoteNode) TraceBadBlock(ctx context.Context, hash common.Hash) (json.RawMessage, error) {
+	tracer, ok := node.RPCMethodCaller.(blockTracer)
+	if !ok {
+		return nil, errTracingUnsupported
+	}
+	node.throttle.Take()
+	start := time.Now()
+	res, err := tracer.TraceBadBlock(ctx, hash)
+	node.requests.observe("debug_traceBadBlock", start, err)
+	return res, err
+}
+
+func (node *RemoteNode) TraceBlockByHash(ctx context.Context, hash common.Hash) (json.RawMessage, error) {
+	tracer, ok := node.RPCMethodCaller.(blockTracer)
+	if !ok {
+		return nil, errTracingUnsupported
+	}
+	node.throttle.Take()
+	start := time.Now()
+	res, err := tracer.TraceBlockByHash(ctx, hash)
+	node.requests.observe("debug_traceBlockByHash", start, err)
+	return res, err
+}
+
+// structLog is a step in the trace of a transaction.
+type structLog struct {
+	Pc      uint64   `json:"pc"`
+	Op      string   `json:"op"`
+	Gas     uint64   `json:"gas"`
+	GasCost uint64   `json:"gasCost"`
+	Depth   int      `json:"depth"`
+	Error   string   `json:"error,omitempty"`
+	Stack   []string `json:"stack,omitempty"`
+}
+
+// txTrace is the trace of a transaction in a block.
+type txTrace struct {
+	Result *struct {
+		Gas         uint64      `json:"gas"`
+		Failed      bool        `json:"failed"`
+		ReturnValue string      `json:"returnValue"`
+		StructLogs  []structLog `json:"structLogs"`
+	} `json:"result,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// txDiff points at the first difference between the traces of a transaction.
+type txDiff struct {
+	Tx     int        `json:"tx"`
+	Step   int        `json:"step"` // the first diverging step, or -1 if only the outcome differs
+	Reason string     `json:"reason"`
+	Bad    *structLog `json:"bad,omitempty"`  // the step in the trace of the bad block
+	Good   *structLog `json:"good,omitempty"` // the step in the trace from the accepting client
+}
+
+// badBlockTriage is the outcome of tracing a bad block.
+type badBlockTriage struct {
+	Hash       common.Hash `json:"hash"`
+	Client     string      `json:"client"`               // the client which rejected the block
+	AcceptedBy string      `json:"acceptedBy,omitempty"` // a client which accepted the block
+	Error      string      `json:"error,omitempty"`
+	Diffs      []*txDiff   `json:"diffs"`
+	Time       time.Time   `json:"time"`
+}
+
+// diffStep returns what differs between two steps, or the empty string.
+func diffStep(bad, good *structLog) string {
+	switch {
+	case bad.Op != good.Op:
+		return fmt.Sprintf("opcode differs: %v vs %v", bad.Op, good.Op)
+	case bad.Pc != good.Pc:
+		return fmt.Sprintf("pc differs: %d vs %d", bad.Pc, good.Pc)
+	case bad.Depth != good.Depth:
+		return fmt.Sprintf("depth differs: %d vs %d", bad.Depth, good.Depth)
+	case bad.Gas != good.Gas:
+		return fmt.Sprintf("gas differs: %d vs %d", bad.Gas, good.Gas)
+	case bad.GasCost != good.GasCost:
+		return fmt.Sprintf("gas cost differs: %d vs %d", bad.GasCost, good.GasCost)
+	case bad.Error != good.Error:
+		return fmt.Sprintf("error differs: %q vs %q", bad.Error, good.Error)
+	}
+	if len(bad.Stack) != len(good.Stack) {
+		return fmt.Sprintf("stack size differs: %d vs %d", len(bad.Stack), len(good.Stack))
+	}
+	for i := range bad.Stack {
+		// Clients differ in zero-padding the stack items
+		x, okx := new(big.Int).SetString(bad.Stack[i], 0)
+		y, oky := new(big.Int).SetString(good.Stack[i], 0)
+		if okx && oky && x.Cmp(y) == 0 {
+			continue
+		}
+		if bad.Stack[i] != good.Stack[i] {
+			return fmt.Sprintf("stack item %d differs: %v vs %v", i, bad.Stack[i], good.Stack[i])
+		}
+	}
+	return ""
+}
+
+// diffTraces compares the traces of a block, transaction by transaction, and
+// returns the first difference of each transaction which diverged.
+func diffTraces(bad, good []*txTrace) []*txDiff {
+	diffs := make([]*txDiff, 0)
+	for i := 0; i < len(bad) || i < len(good); i++ {
+		if i >= len(bad) || i >= len(good) {
+			diffs = append(diffs, &txDiff{Tx: i, Step: -1, Reason: "transaction missing from one of the traces"})
+			continue
+		}
+		b, g := bad[i], good[i]
+		if b.Result == nil || g.Result == nil {
+			if b.Error != g.Error {
+				diffs = append(diffs, &txDiff{Tx: i, Step: -1, Reason: fmt.Sprintf("trace error differs: %q vs %q", b.Error, g.Error)})
+			}
+			continue
+		}
+		var (
+			bLogs, gLogs = b.Result.StructLogs, g.Result.StructLogs
+			diff         *txDiff
+		)
+		for j := 0; j < len(bLogs) && j < len(gLogs); j++ {
+			if reason := diffStep(&bLogs[j], &gLogs[j]); reason != "" {
+				diff = &txDiff{Tx: i, Step: j, Reason: reason, Bad: &bLogs[j], Good: &gLogs[j]}
+				break
+			}
+		}
+		if diff == nil && len(bLogs) != len(gLogs) {
+			diff = &txDiff{Tx: i, Reason: fmt.Sprintf("trace length differs: %d vs %d steps", len(bLogs), len(gLogs))}
+			if diff.Step = len(bLogs); len(bLogs) > len(gLogs) {
+				diff.Step = len(gLogs)
+				diff.Bad = &bLogs[diff.Step]
+			} else {
+				diff.Good = &gLogs[diff.Step]
+			}
+		}
+		if diff == nil {
+			switch {
+			case b.Result.Gas != g.Result.Gas:
+				diff = &txDiff{Tx: i, Step: -1, Reason: fmt.Sprintf("gas used differs: %d vs %d", b.Result.Gas, g.Result.Gas)}
+			case b.Result.Failed != g.Result.Failed:
+				diff = &txDiff{Tx: i, Step: -1, Reason: fmt.Sprintf("failure differs: %v vs %v", b.Result.Failed, g.Result.Failed)}
+			case b.Result.ReturnValue != g.Result.ReturnValue:
+				diff = &txDiff{Tx: i, Step: -1, Reason: "return value differs"}
+			}
+		}
+		if diff != nil {
+			diffs = append(diffs, diff)
+		}
+	}
+	return diffs
+}
+
+// triageRequest is a newly seen bad block to triage.
+type triageRequest struct {
+	hash     common.Hash
+	number   *big.Int
+	reporter Node
+	nodes    []Node // candidates for having accepted the block
+}
+
+// triageRetry is a bad block which could not be traced on the client which
+// rejected it, to be retried later.
+type triageRetry struct {
+	req      *triageRequest
+	attempts int
+	next     time.Time
+}
+
+// triager traces the bad blocks reported by the nodes. The outcome is stored in
+// the block database, or kept in memory if there is none. If the rejecting
+// client fails to trace the block, it is retried with backoff, and the outcome
+// is only stored once the attempts run out.
+type triager struct {
+	db      *blockDB
+	queue   chan *triageRequest
+	mu      sync.Mutex
+	memory  map[string][]byte            // the stored data, if there is no database
+	retries map[common.Hash]*triageRetry // the bad blocks whose trace failed
+}
+
+func newTriager(db *blockDB) *triager {
+	return &triager{
+		db:      db,
+		queue:   make(chan *triageRequest, 100),
+		memory:  make(map[string][]byte),
+		retries: make(map[common.Hash]*triageRetry),
+	}
+}
+
+func triageKey(kind string, hash common.Hash) []byte {
+	return append([]byte(kind+"-"), hash[:]...)
+}
+
+func (db *blockDB) putTriage(key []byte, data []byte) error {
+	return db.db.Put(key, data)
+}
+
+func (db *blockDB) getTriage(key []byte) []byte {
+	data, _ := db.db.Get(key)
+	return data
+}
+
+func (t *triager) put(key []byte, data []byte) {
+	if t.db != nil {
+		if err := t.db.putTriage(key, data); err != nil {
+			log.Error("Failed to store triage data", "error", err)
+		}
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.memory[string(key)] = data
+}
+
+func (t *triager) get(key []byte) []byte {
+	if t.db != nil {
+		return t.db.getTriage(key)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.memory[string(key)]
+}
+
+// result returns the triage of the given bad block, if done.
+func (t *triager) result(hash common.Hash) *badBlockTriage {
+	data := t.get(triageKey("triage", hash))
+	if data == nil {
+		return nil
+	}
+	var res badBlockTriage
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil
+	}
+	return &res
+}
+
+// trace returns the stored trace of the given bad block, where kind is either
+// "bad" (from the rejecting client) or "good" (from an accepting client).
+func (t *triager) trace(hash common.Hash, kind string) json.RawMessage {
+	return t.get(triageKey("trace-"+kind, hash))
+}
+
+// enqueue schedules the bad block for triage, unless it was triaged already, or
+// is awaiting a retry.
+func (t *triager) enqueue(req *triageRequest) {
+	if t.get(triageKey("triage", req.hash)) != nil {
+		return
+	}
+	t.mu.Lock()
+	_, retrying := t.retries[req.hash]
+	t.mu.Unlock()
+	if retrying {
+		return
+	}
+	select {
+	case t.queue <- req:
+	default:
+		log.Warn("Triage queue full, skipping bad block", "hash", req.hash)
+	}
+}
+
+// start launches the triage of the enqueued bad blocks, until quitCh is closed.
+func (t *triager) start(wg *sync.WaitGroup, quitCh chan struct{}) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ctx, cancel := quitContext(quitCh)
+		defer cancel()
+		retry := time.NewTicker(traceRetryInterval)
+		defer retry.Stop()
+		for {
+			select {
+			case <-quitCh:
+				return
+			case req := <-t.queue:
+				t.process(ctx, req)
+			case <-retry.C:
+				for _, req := range t.due() {
+					t.process(ctx, req)
+				}
+			}
+		}
+	}()
+}
+
+// due returns the failed bad blocks whose retry is due.
+func (t *triager) due() []*triageRequest {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var reqs []*triageRequest
+	for _, r := range t.retries {
+		if time.Now().After(r.next) {
+			reqs = append(reqs, r.req)
+		}
+	}
+	return reqs
+}
+
+// failed records a failure to trace the bad block on the rejecting client, and
+// returns whether it is to be retried.
+func (t *triager) failed(req *triageRequest) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	r := t.retries[req.hash]
+	if r == nil {
+		r = &triageRetry{req: req}
+		t.retries[req.hash] = r
+	}
+	r.attempts++
+	if r.attempts >= maxTraceAttempts {
+		delete(t.retries, req.hash)
+		return false
+	}
+	r.next = time.Now().Add(traceRetryDelay << (r.attempts - 1))
+	return true
+}
+
+// process traces the bad block on the client which rejected it, and on a client
+// which accepted it (if any), and diffs the two.
+func (t *triager) process(ctx context.Context, req *triageRequest) {
+	res := &badBlockTriage{
+		Hash:   req.hash,
+		Client: req.reporter.Name(),
+		Diffs:  make([]*txDiff, 0),
+		Time:   time.Now(),
+	}
+	store := func() {
+		data, _ := json.Marshal(res)
+		t.put(triageKey("triage", req.hash), data)
+	}
+	tracer, ok := req.reporter.(blockTracer)
+	if !ok {
+		res.Error = errTracingUnsupported.Error()
+		store()
+		return
+	}
+	log.Info("Tracing bad block", "hash", req.hash, "client", res.Client)
+	badData, err := tracer.TraceBadBlock(ctx, req.hash)
+	if err != nil {
+		if ctx.Err() != nil {
+			return // shutting down, it's retried on the next start
+		}
+		if t.failed(req) {
+			log.Warn("Failed to trace bad block, retrying later", "hash", req.hash, "client", res.Client, "error", err)
+			return
+		}
+		log.Warn("Failed to trace bad block, giving up", "hash", req.hash, "client", res.Client, "error", err)
+		res.Error = fmt.Sprintf("tracing on %v failed: %v", res.Client, err)
+		store()
+		return
+	}
+	t.mu.Lock()
+	delete(t.retries, req.hash)
+	t.mu.Unlock()
+	t.put(triageKey("trace-bad", req.hash), badData)
+	defer store()
+
+	// Find a client which accepted the block, to trace it there too
+	var goodData json.RawMessage
+	for _, node := range req.nodes {
+		tracer, ok := node.(blockTracer)
+		if !ok || node == req.reporter || req.number == nil || !req.number.IsUint64() {
+			continue
+		}
+		if node.HashAt(ctx, req.number.Uint64(), false) != req.hash {
+			continue
+		}
+		if goodData, err = tracer.TraceBlockByHash(ctx, req.hash); err != nil {
+			log.Warn("Failed to trace accepted block", "hash", req.hash, "client", node.Name(), "error", err)
+			continue
+		}
+		res.AcceptedBy = node.Name()
+		t.put(triageKey("trace-good", req.hash), goodData)
+		break
+	}
+	if goodData == nil {
+		res.Error = "no client which accepted the block could trace it"
+		return
+	}
+	var bad, good []*txTrace
+	if err := json.Unmarshal(badData, &bad); err != nil {
+		res.Error = fmt.Sprintf("invalid trace from %v: %v", res.Client, err)
+		return
+	}
+	if err := json.Unmarshal(goodData, &good); err != nil {
+		res.Error = fmt.Sprintf("invalid trace from %v: %v", res.AcceptedBy, err)
+		return
+	}
+	res.Diffs = diffTraces(bad, good)
+	log.Info("Triaged bad block", "hash", req.hash, "client", res.Client, "acceptedBy", res.AcceptedBy, "diverging", len(res.Diffs))
+}
@@ -0,0 +1,219 @@
+package nodes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// tracingNode is a test node which serves canned traces.
+type tracingNode struct {
+	*testNode
+	trace json.RawMessage
+}
+
+func (n *tracingNode) TraceBadBlock(ctx context.Context, hash common.Hash) (json.RawMessage, error) {
+	if n.trace == nil {
+		return nil, errors.New("not found")
+	}
+	return n.trace, nil
+}
+
+func (n *tracingNode) TraceBlockByHash(ctx context.Context, hash common.Hash) (json.RawMessage, error) {
+	return n.TraceBadBlock(ctx, hash)
+}
+
+func TestDiffTraces(t *testing.T) {
+	parse := func(s string) []*txTrace {
+		t.Helper()
+		var traces []*txTrace
+		if err := json.Unmarshal([]byte(s), &traces); err != nil {
+			t.Fatal(err)
+		}
+		return traces
+	}
+	good := parse(`[
+		{"result": {"gas": 21000, "structLogs": []}},
+		{"result": {"gas": 30000, "structLogs": [
+			{"pc": 0, "op": "PUSH1", "gas": 100, "gasCost": 3, "depth": 1, "stack": []},
+			{"pc": 2, "op": "PUSH1", "gas": 97, "gasCost": 3, "depth": 1, "stack": ["0x1"]},
+			{"pc": 4, "op": "ADD", "gas": 94, "gasCost": 3, "depth": 1, "stack": ["0x1", "0x2"]}
+		]}}
+	]`)
+	for i, tt := range []struct {
+		bad    string
+		tx     int
+		step   int
+		reason string
+	}{
+		{ // Identical, apart from stack padding
+			bad: `[
+				{"result": {"gas": 21000, "structLogs": []}},
+				{"result": {"gas": 30000, "structLogs": [
+					{"pc": 0, "op": "PUSH1", "gas": 100, "gasCost": 3, "depth": 1, "stack": []},
+					{"pc": 2, "op": "PUSH1", "gas": 97, "gasCost": 3, "depth": 1, "stack": ["0x01"]},
+					{"pc": 4, "op": "ADD", "gas": 94, "gasCost": 3, "depth": 1, "stack": ["0x1", "0x2"]}
+				]}}
+			]`,
+			step: -2,
+		},
+		{ // Different gas cost
+			bad: `[
+				{"result": {"gas": 21000, "structLogs": []}},
+				{"result": {"gas": 30000, "structLogs": [
+					{"pc": 0, "op": "PUSH1", "gas": 100, "gasCost": 3, "depth": 1, "stack": []},
+					{"pc": 2, "op": "PUSH1", "gas": 97, "gasCost": 5, "depth": 1, "stack": ["0x1"]}
+				]}}
+			]`,
+			tx:     1,
+			step:   1,
+			reason: "gas cost differs: 5 vs 3",
+		},
+		{ // Shorter trace
+			bad: `[
+				{"result": {"gas": 21000, "structLogs": []}},
+				{"result": {"gas": 30000, "structLogs": [
+					{"pc": 0, "op": "PUSH1", "gas": 100, "gasCost": 3, "depth": 1, "stack": []}
+				]}}
+			]`,
+			tx:     1,
+			step:   1,
+			reason: "trace length differs: 1 vs 3 steps",
+		},
+		{ // Only the outcome differs
+			bad: `[
+				{"result": {"gas": 22000, "structLogs": []}},
+				{"result": {"gas": 30000, "structLogs": [
+					{"pc": 0, "op": "PUSH1", "gas": 100, "gasCost": 3, "depth": 1, "stack": []},
+					{"pc": 2, "op": "PUSH1", "gas": 97, "gasCost": 3, "depth": 1, "stack": ["0x1"]},
+					{"pc": 4, "op": "ADD", "gas": 94, "gasCost": 3, "depth": 1, "stack": ["0x1", "0x2"]}
+				]}}
+			]`,
+			tx:     0,
+			step:   -1,
+			reason: "gas used differs: 22000 vs 21000",
+		},
+	} {
+		diffs := diffTraces(parse(tt.bad), good)
+		if tt.step == -2 {
+			if len(diffs) != 0 {
+				t.Errorf("test %d: unexpected diffs: %v", i, diffs[0].Reason)
+			}
+			continue
+		}
+		if len(diffs) != 1 {
+			t.Fatalf("test %d: wrong diffs, have %d, want 1", i, len(diffs))
+		}
+		d := diffs[0]
+		if d.Tx != tt.tx || d.Step != tt.step || d.Reason != tt.reason {
+			t.Errorf("test %d: wrong diff, have tx %d step %d %q, want tx %d step %d %q",
+				i, d.Tx, d.Step, d.Reason, tt.tx, tt.step, tt.reason)
+		}
+	}
+}
+
+func TestTriage(t *testing.T) {
+	log.Root().SetHandler(log.LvlFilterHandler(
+		log.LvlCrit, log.StreamHandler(os.Stderr, log.TerminalFormat(false))))
+
+	// Disable the vuln check for tests
+	disableVulnCheck = true
+
+	db, err := openBlockDB(filepath.Join(t.TempDir(), "blockDB"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	good := &tracingNode{
+		testNode: newTestNode("good", 13_000_000, []uint64{0}, []int{0}),
+		trace:    json.RawMessage(`[{"result": {"gas": 21000, "structLogs": [{"pc": 0, "op": "STOP", "gas": 10, "depth": 1}]}}]`),
+	}
+	bad := &tracingNode{
+		testNode: newTestNode("bad", 12_999_900, []uint64{0}, []int{0}),
+		trace:    json.RawMessage(`[{"result": {"gas": 21000, "structLogs": [{"pc": 0, "op": "INVALID", "gas": 10, "depth": 1}]}}]`),
+	}
+	nm, err := NewMonitor([]Node{good, bad}, db, time.Second, "Playdoh-net", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nm.doChecks()
+	// The bad node rejected a block which the good node accepted
+	hash := good.HashAt(context.Background(), 12_999_950, false)
+	nm.triage.process(context.Background(), &triageRequest{
+		hash:     hash,
+		number:   big.NewInt(12_999_950),
+		reporter: bad,
+		nodes:    []Node{bad, good},
+	})
+
+	srv := httptest.NewServer(nm.Handler())
+	defer srv.Close()
+	get := func(path string) []byte {
+		t.Helper()
+		res, err := http.Get(srv.URL + APIPrefix + "triage/" + hash.Hex() + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("wrong status code for %q: %d", path, res.StatusCode)
+		}
+		data, _ := io.ReadAll(res.Body)
+		return data
+	}
+	var res badBlockTriage
+	if err := json.Unmarshal(get(""), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Client != bad.Name() || res.AcceptedBy != good.Name() {
+		t.Errorf("wrong clients, have %q/%q", res.Client, res.AcceptedBy)
+	}
+	if len(res.Diffs) != 1 || res.Diffs[0].Step != 0 || res.Diffs[0].Bad.Op != "INVALID" {
+		t.Fatalf("wrong diffs: %v", res.Diffs)
+	}
+	if have, want := string(get("/bad")), string(bad.trace); have != want {
+		t.Errorf("wrong bad trace, have %s, want %s", have, want)
+	}
+	if have, want := string(get("/good")), string(good.trace); have != want {
+		t.Errorf("wrong good trace, have %s, want %s", have, want)
+	}
+	// A block nobody accepted is still traced on the rejecting client
+	other := common.Hash{1}
+	nm.triage.process(context.Background(), &triageRequest{
+		hash:     other,
+		number:   big.NewInt(12_999_950),
+		reporter: bad,
+		nodes:    []Node{bad, good},
+	})
+	if r := nm.triage.result(other); r == nil || r.AcceptedBy != "" || r.Error == "" {
+		t.Errorf("wrong triage of unaccepted block: %v", r)
+	}
+	if nm.triage.trace(other, "bad") == nil {
+		t.Errorf("bad trace not stored")
+	}
+	// A failed trace is retried, and only stored once the attempts run out
+	failing := &tracingNode{testNode: newTestNode("failing", 12_999_900, []uint64{0}, []int{0})}
+	req := &triageRequest{hash: common.Hash{2}, reporter: failing, nodes: []Node{failing, good}}
+	for i := 0; i < maxTraceAttempts; i++ {
+		if r := nm.triage.result(req.hash); r != nil {
+			t.Fatalf("attempt %d: failed triage stored: %v", i, r)
+		}
+		nm.triage.process(context.Background(), req)
+	}
+	if r := nm.triage.result(req.hash); r == nil || r.Error == "" {
+		t.Errorf("failed triage not stored after %d attempts: %v", maxTraceAttempts, r)
+	}
+	if len(nm.triage.retries) != 0 {
+		t.Errorf("failed triage still awaiting a retry")
+	}
+}
@@ -1,22 +1,56 @@
 package nodes
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
+	"os"
 	"regexp"
+	"strings"
+	"sync"
 	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+const (
+	gethVulnFeed      = "https://geth.ethereum.org/docs/vulnerabilities/vulnerabilities.json"
+	vulnCheckInterval = 10 * time.Minute // how often the feeds are refetched
+	vulnFetchTimeout  = 5 * time.Second
 )
 
-const url = "https://geth.ethereum.org/docs/vulnerabilities/vulnerabilities.json"
+// gethVulnKeys are the minisign keys which sign the geth vulnerability feed.
+var gethVulnKeys = []string{
+	"RWQk7Lo5TQgd+wxBNZM+Zoy+7UhhMHaWKzqoes9tvSbFLJYZhNTbrIjx", // FB1D084D39BAEC24
+	"RWSHFuUDoxyLEzjszuWZI1xStS66QTyXFFZG18uDfO26CuCsbckX1e9J", // 138B1CA303E51687
+	"RWSEhAnSshOY/b+GmaiDkObbCWefsAoavjoLcPjBo1xn71yuOH5I+Lts", // FD9813B2D2098484
+}
+
+// defaultVulnFeeds is used if no feeds are configured.
+var defaultVulnFeeds = []vulnFeedConfig{{
+	Url:        gethVulnFeed,
+	PublicKeys: gethVulnKeys,
+	Client:     "geth",
+}}
 
 var (
-	checkCache      []vulnJson
+	checkMu         sync.RWMutex // protects vulnFeeds, the checks of the feeds and lastCheckUpdate
+	vulnFeeds       []*vulnFeed
 	lastCheckUpdate time.Time
 	// for testing
 	disableVulnCheck bool
 )
 
+func init() {
+	feeds, err := newVulnFeeds(defaultVulnFeeds)
+	if err != nil {
+		panic(err)
+	}
+	vulnFeeds = feeds
+}
+
 type vulnJson struct {
 	Name        string
 	Uid         string
@@ -33,68 +67,253 @@ type vulnJson struct {
 	regex *regexp.Regexp `json:"-"`
 }
 
-func fetchChecks(url string) ([]vulnJson, error) {
-	if disableVulnCheck {
-		return nil, nil
+// vulnFeed is a source of vulnerability checks, in the format of the geth
+// vulnerability feed.
+type vulnFeed struct {
+	url     string // http(s) url, or local file
+	sigUrl  string
+	keys    []*minisignKey // if set, the feed must be signed by one of them
+	client  string         // lowercase client name, empty to check all clients
+	checks  []vulnJson     // as of the latest successful fetch
+	modTime time.Time      // of the local file, when last loaded
+}
+
+func newVulnFeeds(confs []vulnFeedConfig) ([]*vulnFeed, error) {
+	if len(confs) == 0 {
+		confs = defaultVulnFeeds
 	}
-	client := http.Client{
-		Timeout: time.Second * 1, // Timeout after 1 seconds
+	var feeds []*vulnFeed
+	for _, conf := range confs {
+		if len(conf.Url) == 0 {
+			return nil, fmt.Errorf("vulnerability feed: missing url")
+		}
+		feed := &vulnFeed{
+			url:    conf.Url,
+			sigUrl: conf.Signature,
+			client: strings.ToLower(conf.Client),
+		}
+		if len(feed.sigUrl) == 0 {
+			feed.sigUrl = conf.Url + ".minisig"
+		}
+		for _, k := range conf.PublicKeys {
+			key, err := parseMinisignKey(k)
+			if err != nil {
+				return nil, fmt.Errorf("vulnerability feed %v: %v", conf.Url, err)
+			}
+			feed.keys = append(feed.keys, key)
+		}
+		feeds = append(feeds, feed)
 	}
+	return feeds, nil
+}
+
+// ConfigureVulnFeeds sets the feeds which the node versions are checked against,
+// including the vuln_file. If none are configured, the geth vulnerability feed
+// is used.
+func ConfigureVulnFeeds(conf *Config) error {
+	confs := conf.Vulns.Feeds
+	if len(conf.VulnFile) > 0 {
+		confs = append(confs[:len(confs):len(confs)], vulnFeedConfig{Url: conf.VulnFile})
+	}
+	feeds, err := newVulnFeeds(confs)
+	if err != nil {
+		return err
+	}
+	checkMu.Lock()
+	defer checkMu.Unlock()
+	vulnFeeds = feeds
+	lastCheckUpdate = time.Time{}
+	return nil
+}
+
+// localPath returns the path of the file at the given url, or the empty string
+// if it is not a local file.
+func localPath(url string) string {
+	if strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://") {
+		return ""
+	}
+	return strings.TrimPrefix(url, "file://")
+}
 
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+// fetchResource reads the given http(s) url, or local file.
+func fetchResource(ctx context.Context, url string) ([]byte, error) {
+	if path := localPath(url); len(path) > 0 {
+		return os.ReadFile(path)
+	}
+	client := http.Client{
+		Timeout: vulnFetchTimeout,
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return []vulnJson{}, err
+		return nil, err
 	}
 	req.Header.Set("User-Agent", "nodemonitor")
 
 	res, err := client.Do(req)
 	if err != nil {
-		return []vulnJson{}, err
+		return nil, err
 	}
-
 	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%v: %v", url, res.Status)
+	}
+	return ioutil.ReadAll(res.Body)
+}
 
-	data, err := ioutil.ReadAll(res.Body)
+// fetch loads the checks of the feed, after verifying its signature if keys are
+// configured.
+func (feed *vulnFeed) fetch(ctx context.Context) ([]vulnJson, error) {
+	data, err := fetchResource(ctx, feed.url)
 	if err != nil {
-		return []vulnJson{}, err
+		return nil, err
+	}
+	if len(feed.keys) > 0 {
+		sig, err := fetchResource(ctx, feed.sigUrl)
+		if err != nil {
+			return nil, fmt.Errorf("could not retrieve signature: %v", err)
+		}
+		if err := verifyMinisign(feed.keys, data, sig); err != nil {
+			return nil, err
+		}
 	}
-
 	var vulns []vulnJson
 	if err = json.Unmarshal(data, &vulns); err != nil {
-		return []vulnJson{}, err
+		return nil, err
 	}
-
 	checks := make([]vulnJson, 0, len(vulns))
 	for _, vuln := range vulns {
 		r, err := regexp.Compile(vuln.Check)
 		if err != nil {
-			return []vulnJson{}, err
+			return nil, err
 		}
 		vuln.regex = r
 		checks = append(checks, vuln)
 	}
-	return checks, err
+	return checks, nil
+}
+
+// fileModified returns whether the feed is a local file (or signed by one) which
+// was modified since last loaded. If so, the new modification time is recorded.
+// The caller must hold checkMu.
+func (feed *vulnFeed) fileModified() bool {
+	var modTime time.Time
+	for _, url := range []string{feed.url, feed.sigUrl} {
+		path := localPath(url)
+		if len(path) == 0 || (url == feed.sigUrl && len(feed.keys) == 0) {
+			continue
+		}
+		if info, err := os.Stat(path); err == nil && info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+	if modTime.IsZero() || modTime.Equal(feed.modTime) {
+		return false
+	}
+	feed.modTime = modTime
+	return true
 }
 
-func checkNode(node Node) ([]vulnJson, error) {
-	// Update the check cache every 10 minutes
-	var v []vulnJson
-	if checkCache != nil || time.Since(lastCheckUpdate) > 10*time.Minute {
-		checks, err := fetchChecks(url)
+// updateChecks refetches the remote feeds, unless done recently, and reloads
+// the local files which were modified. A feed which fails to load keeps the
+// checks it had. The requests are cancelled along with ctx.
+func updateChecks(ctx context.Context) {
+	if disableVulnCheck {
+		return
+	}
+	checkMu.Lock()
+	refetch := time.Since(lastCheckUpdate) >= vulnCheckInterval
+	if refetch {
+		lastCheckUpdate = time.Now()
+	}
+	var feeds []*vulnFeed
+	for _, feed := range vulnFeeds {
+		// Local files are reloaded once modified, or retried if missing
+		if feed.fileModified() || (refetch && (len(localPath(feed.url)) == 0 || feed.modTime.IsZero())) {
+			feeds = append(feeds, feed)
+		}
+	}
+	checkMu.Unlock()
+
+	for _, feed := range feeds {
+		checks, err := feed.fetch(ctx)
 		if err != nil {
-			return v, err
+			log.Warn("Error fetching vulnerability feed", "url", feed.url, "error", err)
+			continue
+		}
+		checkMu.Lock()
+		feed.checks = checks
+		checkMu.Unlock()
+	}
+}
+
+// clientName returns the lowercase name of the client from its version string,
+// e.g. "geth" for "Geth/v1.11.5-stable/linux-amd64/go1.20.2".
+func clientName(version string) string {
+	if i := strings.IndexByte(version, '/'); i >= 0 {
+		version = version[:i]
+	}
+	return strings.ToLower(version)
+}
+
+// affects returns whether the vulnerability affects the given client version.
+// For the feeds of a single client, the range from the introducing version up
+// to the fixed one (if any) is evaluated. Otherwise, if the vulnerability is not
+// tied to a version range, or for pre-releases (which may predate the fix of
+// the same version), the check regex is matched.
+func (vuln *vulnJson) affects(feed *vulnFeed, version string, cv *clientVersion) bool {
+	if cv != nil && len(feed.client) > 0 && cv.Name == feed.client && !cv.prerelease() {
+		if introduced, ok := parseSemver(vuln.Introduced); ok {
+			if cv.semver.cmp(introduced) < 0 {
+				return false
+			}
+			fixed, ok := parseSemver(vuln.Fixed)
+			return !ok || cv.semver.cmp(fixed) < 0
 		}
-		checkCache = checks
 	}
+	return vuln.regex.MatchString(version)
+}
+
+// matchVulns returns the known vulnerabilities of the given client version.
+func matchVulns(version string) []vulnJson {
+	checkMu.RLock()
+	defer checkMu.RUnlock()
+	var (
+		v    []vulnJson
+		cv   = parseClientVersion(version)
+		name = clientName(version)
+	)
+	for _, feed := range vulnFeeds {
+		if len(feed.client) > 0 && feed.client != name {
+			continue
+		}
+		for i := range feed.checks {
+			if feed.checks[i].affects(feed, version, cv) {
+				v = append(v, feed.checks[i])
+			}
+		}
+	}
+	return v
+}
 
-	version, err := node.Version()
+func checkNode(ctx context.Context, node Node) ([]vulnJson, error) {
+	updateChecks(ctx)
+	version, err := node.Version(ctx)
 	if err != nil {
-		return v, err
+		return nil, err
 	}
-	for _, c := range checkCache {
-		if c.regex.MatchString(version) {
-			v = append(v, c)
+	return matchVulns(version), nil
+}
+
+// getVuln returns the vulnerability with the given uid, or nil if unknown.
+func getVuln(uid string) *vulnJson {
+	checkMu.RLock()
+	defer checkMu.RUnlock()
+	for _, feed := range vulnFeeds {
+		for i := range feed.checks {
+			if feed.checks[i].Uid == uid {
+				return &feed.checks[i]
+			}
 		}
 	}
-	return v, nil
+	return nil
 }
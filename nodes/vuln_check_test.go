@@ -0,0 +1,279 @@
+package nodes
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// minisignTestKey generates a minisign key, returning the encoded public key.
+func minisignTestKey(t *testing.T) (string, [8]byte, ed25519.PrivateKey) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var id [8]byte
+	rand.Read(id[:])
+	data := append(append([]byte("Ed"), id[:]...), pub...)
+	return base64.StdEncoding.EncodeToString(data), id, priv
+}
+
+// signMinisign creates a minisign signature of the data.
+func signMinisign(priv ed25519.PrivateKey, id [8]byte, data []byte, comment string) []byte {
+	sig := ed25519.Sign(priv, data)
+	globalSig := ed25519.Sign(priv, append(append([]byte{}, sig...), comment...))
+	return []byte(fmt.Sprintf("untrusted comment: test\n%v\ntrusted comment: %v\n%v\n",
+		base64.StdEncoding.EncodeToString(append(append([]byte("Ed"), id[:]...), sig...)),
+		comment,
+		base64.StdEncoding.EncodeToString(globalSig)))
+}
+
+func TestMinisign(t *testing.T) {
+	read := func(name string) []byte {
+		t.Helper()
+		data, err := os.ReadFile(filepath.Join("testdata", "vcheck", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	var (
+		data = read("vulnerabilities.json")
+		sig  = read("vulnerabilities.json.minisig")
+	)
+	key, err := parseMinisignKey(string(read("minisign.pub")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := key.ID(), "284E00B52C269624"; have != want {
+		t.Errorf("wrong key id, have %v, want %v", have, want)
+	}
+	if err := verifyMinisign([]*minisignKey{key}, data, sig); err != nil {
+		t.Fatalf("valid signature rejected: %v", err)
+	}
+	// Tampered data
+	tampered := append([]byte{}, data...)
+	tampered[10] ^= 1
+	if err := verifyMinisign([]*minisignKey{key}, tampered, sig); err == nil {
+		t.Errorf("signature of tampered data accepted")
+	}
+	// Tampered trusted comment
+	badComment := bytes.Replace(sig, []byte("timestamp:"), []byte("timestamq:"), 1)
+	if err := verifyMinisign([]*minisignKey{key}, data, badComment); err == nil {
+		t.Errorf("signature with tampered trusted comment accepted")
+	}
+	// Untrusted key
+	other, _, _ := minisignTestKey(t)
+	otherKey, err := parseMinisignKey(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyMinisign([]*minisignKey{otherKey}, data, sig); err == nil {
+		t.Errorf("signature by untrusted key accepted")
+	}
+}
+
+func TestVulnFeeds(t *testing.T) {
+	checkMu.Lock()
+	oldFeeds, oldDisable := vulnFeeds, disableVulnCheck
+	checkMu.Unlock()
+	defer func() {
+		checkMu.Lock()
+		vulnFeeds, lastCheckUpdate, disableVulnCheck = oldFeeds, time.Time{}, oldDisable
+		checkMu.Unlock()
+	}()
+	disableVulnCheck = false
+
+	pub, id, priv := minisignTestKey(t)
+	var (
+		besuFeed = []byte(`[{"uid": "BESU-TEST-01", "check": "v21\\.1\\.[0-2]\\/"}]`)
+		anyFeed  = []byte(`[{"uid": "ANY-TEST-01", "check": ".*"}]`)
+		files    = map[string][]byte{
+			"/besu.json":         besuFeed,
+			"/besu.json.minisig": signMinisign(priv, id, besuFeed, "besu feed"),
+			// Served with the signature of another file
+			"/forged.json":         anyFeed,
+			"/forged.json.minisig": signMinisign(priv, id, besuFeed, "besu feed"),
+		}
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	gethKey, err := os.ReadFile(filepath.Join("testdata", "vcheck", "minisign.pub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ConfigureVulnFeeds(&Config{Vulns: vulnsConfig{Feeds: []vulnFeedConfig{
+		{Url: filepath.Join("testdata", "vcheck", "vulnerabilities.json"), PublicKeys: []string{string(gethKey)}, Client: "Geth"},
+		{Url: srv.URL + "/besu.json", PublicKeys: []string{pub}, Client: "besu"},
+		{Url: srv.URL + "/forged.json", PublicKeys: []string{pub}},
+		{Url: srv.URL + "/missing.json"},
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	updateChecks(context.Background())
+
+	for _, tt := range []struct {
+		version string
+		want    []string
+	}{
+		// Geth versions are matched by version range, which the regex of
+		// GETH-2020-01 leaves out
+		{"Geth/v1.9.14-stable-6d74d1e5/linux-amd64/go1.16", []string{"GETH-2020-01", "GETH-2020-03"}},
+		{"Geth/v1.9.24-unstable-15339cf1-20201204/linux-amd64/go1.16", nil},
+		{"Geth/v1.11.5-stable/linux-amd64/go1.20.2", nil},
+		// GETH-2020-02 has no version range, and falls back to the regex
+		{"Geth/v1.9.20-stable/linux-amd64/go1.15.2", []string{"GETH-2020-01", "GETH-2020-02"}},
+		{"besu/v21.1.1/linux-x86_64/openjdk-java-11", []string{"BESU-TEST-01"}},
+		{"besu/v21.1.5/linux-x86_64/openjdk-java-11", nil},
+		// Feeds are only applied to their client
+		{"Geth/v21.1.1/linux-amd64/go1.20.2", nil},
+	} {
+		var have []string
+		for _, v := range matchVulns(tt.version) {
+			have = append(have, v.Uid)
+		}
+		if fmt.Sprint(have) != fmt.Sprint(tt.want) {
+			t.Errorf("%v: wrong vulnerabilities, have %v, want %v", tt.version, have, tt.want)
+		}
+	}
+	if getVuln("ANY-TEST-01") != nil {
+		t.Errorf("feed with invalid signature was used")
+	}
+	if getVuln("BESU-TEST-01") == nil {
+		t.Errorf("vulnerability not served")
+	}
+	// Invalid keys are rejected
+	err = ConfigureVulnFeeds(&Config{Vulns: vulnsConfig{Feeds: []vulnFeedConfig{
+		{Url: srv.URL + "/besu.json", PublicKeys: []string{"not a key"}},
+	}}})
+	if err == nil {
+		t.Errorf("invalid public key accepted")
+	}
+}
+
+func TestVulnFile(t *testing.T) {
+	checkMu.Lock()
+	oldFeeds, oldDisable := vulnFeeds, disableVulnCheck
+	checkMu.Unlock()
+	defer func() {
+		checkMu.Lock()
+		vulnFeeds, lastCheckUpdate, disableVulnCheck = oldFeeds, time.Time{}, oldDisable
+		checkMu.Unlock()
+	}()
+	disableVulnCheck = false
+
+	file := filepath.Join(t.TempDir(), "vulnerabilities.json")
+	write := func(data string, modTime time.Time) {
+		t.Helper()
+		if err := os.WriteFile(file, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(file, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`[{"uid": "LOCAL-01", "name": "Old", "check": "Geth\\/v1\\.10\\..*"}]`, time.Now().Add(-time.Minute))
+	if err := ConfigureVulnFeeds(&Config{VulnFile: file}); err != nil {
+		t.Fatal(err)
+	}
+	if len(vulnFeeds) != 1 {
+		t.Fatalf("wrong number of feeds, have %d, want 1", len(vulnFeeds))
+	}
+	updateChecks(context.Background())
+	if v := matchVulns("Geth/v1.10.26-stable/linux-amd64/go1.18.5"); len(v) != 1 || v[0].Uid != "LOCAL-01" {
+		t.Fatalf("wrong vulnerabilities: %v", v)
+	}
+	// Modifications are picked up in the next round
+	write(`[{"uid": "LOCAL-02", "name": "New", "check": "Geth\\/v1\\.11\\..*"}]`, time.Now())
+	updateChecks(context.Background())
+	if v := matchVulns("Geth/v1.10.26-stable/linux-amd64/go1.18.5"); len(v) != 0 {
+		t.Errorf("stale vulnerabilities: %v", v)
+	}
+	if v := matchVulns("Geth/v1.11.5-stable/linux-amd64/go1.20.2"); len(v) != 1 || v[0].Uid != "LOCAL-02" {
+		t.Errorf("wrong vulnerabilities: %v", v)
+	}
+	// Served to the dashboard like any other feed
+	rec := httptest.NewRecorder()
+	serveVuln(rec, httptest.NewRequest(http.MethodGet, APIPrefix+"vulns/LOCAL-02", nil))
+	if rec.Code != http.StatusOK || !bytes.Contains(rec.Body.Bytes(), []byte(`"New"`)) {
+		t.Errorf("vulnerability not served: %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestVulnPrerelease(t *testing.T) {
+	checkMu.Lock()
+	oldFeeds, oldDisable := vulnFeeds, disableVulnCheck
+	checkMu.Unlock()
+	defer func() {
+		checkMu.Lock()
+		vulnFeeds, lastCheckUpdate, disableVulnCheck = oldFeeds, time.Time{}, oldDisable
+		checkMu.Unlock()
+	}()
+	disableVulnCheck = false
+
+	file := filepath.Join(t.TempDir(), "vulnerabilities.json")
+	feed := `[{"uid": "GETH-TEST-01", "introduced": "v1.11.0", "fixed": "v1.11.5", "check": "Geth\\/v1\\.11\\.[0-5]-.*"}]`
+	if err := os.WriteFile(file, []byte(feed), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err := ConfigureVulnFeeds(&Config{Vulns: vulnsConfig{Feeds: []vulnFeedConfig{{Url: file, Client: "geth"}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	updateChecks(context.Background())
+	for _, tt := range []struct {
+		version    string
+		vulnerable bool
+	}{
+		{"Geth/v1.11.4-stable-7e3b149b/linux-amd64/go1.20.2", true},
+		{"Geth/v1.11.5-stable-a38f4108/linux-amd64/go1.20.2", false},
+		// Built before the fix was released, which the regex tells
+		{"Geth/v1.11.5-unstable-2a3f26a0/linux-amd64/go1.20.2", true},
+	} {
+		if have := len(matchVulns(tt.version)) > 0; have != tt.vulnerable {
+			t.Errorf("%v: vulnerable %v, want %v", tt.version, have, tt.vulnerable)
+		}
+	}
+}
+
+func TestFetchCancelled(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-block:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+
+	// A feed fetched by a cancelled round is abandoned, not waited for
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	if _, err := fetchResource(ctx, srv.URL); err == nil {
+		t.Fatal("cancelled fetch succeeded")
+	}
+	if time.Since(start) >= vulnFetchTimeout {
+		t.Errorf("cancelled fetch took %v", time.Since(start))
+	}
+}
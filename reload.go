@@ -0,0 +1,323 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/holiman/nodemonitor/nodes"
+)
+
+// configPollInterval is how often the config file is checked for modifications.
+const configPollInterval = 5 * time.Second
+
+// clientEntry is a configured client, along with the node created for it.
+type clientEntry struct {
+	info  nodes.ClientInfo
+	creds string // provider credentials, for the kinds which use them
+	node  nodes.Node
+}
+
+// credentials returns the global config settings which the given client
+// depends on, so that a change of e.g. the infura key causes the infura
+// clients to be recreated.
+func credentials(c nodes.ClientInfo, config *nodes.Config) string {
+	switch c.Kind {
+	case "infura":
+		return config.InfuraKey + "@" + config.InfuraEndpoint
+	case "alchemy":
+		return config.AlchemyKey + "@" + config.AlchemyEndpoint
+	case "etherscan":
+		return config.EtherscanKey + "@" + config.EtherscanEndpoint
+	}
+	return ""
+}
+
+// changedFields returns the names of the settings which differ between a and b.
+func changedFields(a, b *clientEntry) []string {
+	var fields []string
+	if a.info.Kind != b.info.Kind {
+		fields = append(fields, "kind")
+	}
+	if a.info.Url != b.info.Url {
+		fields = append(fields, "url")
+	}
+	if a.info.Ratelimit != b.info.Ratelimit {
+		fields = append(fields, "ratelimit")
+	}
+	if a.info.Timeout != b.info.Timeout {
+		fields = append(fields, "timeout")
+	}
+	if a.info.Scenario != b.info.Scenario {
+		fields = append(fields, "scenario")
+	}
+	if !reflect.DeepEqual(a.info.AuthHeaders, b.info.AuthHeaders) {
+		fields = append(fields, "auth_headers")
+	}
+	if a.creds != b.creds {
+		fields = append(fields, "credentials")
+	}
+	return fields
+}
+
+// nodeSettings returns the client config without the settings which are not
+// used by the node itself, like its vote, so changing those keeps the node.
+func nodeSettings(c nodes.ClientInfo) nodes.ClientInfo {
+	c.Weight, c.Group = 0, ""
+	return c
+}
+
+func clientNodes(clients []*clientEntry) []nodes.Node {
+	var list []nodes.Node
+	for _, c := range clients {
+		list = append(list, c.node)
+	}
+	return list
+}
+
+// reconcileClients creates the client list for the given config. Clients whose
+// node configuration is identical to one in the old list keep their existing node
+// (and thereby the cached chain data), the others are instantiated anew.
+// If any node fails to be created, an error is returned and nothing is logged
+// as changed.
+func reconcileClients(old []*clientEntry, list []nodes.ClientInfo, config *nodes.Config, factory nodeFactory) ([]*clientEntry, error) {
+	var (
+		clients = make([]*clientEntry, len(list))
+		reused  = make(map[*clientEntry]bool)
+	)
+	for i, c := range list {
+		creds := credentials(c, config)
+		for _, e := range old {
+			if !reused[e] && e.creds == creds && reflect.DeepEqual(nodeSettings(e.info), nodeSettings(c)) {
+				clients[i] = e
+				reused[e] = true
+				break
+			}
+		}
+	}
+	for i, c := range list {
+		if clients[i] != nil {
+			continue
+		}
+		node, err := factory(c, config)
+		if err != nil {
+			// Release the nodes created so far
+			for _, e := range clients {
+				if e != nil && !reused[e] {
+					nodes.CloseNode(e.node)
+				}
+			}
+			return nil, fmt.Errorf("client %q: %w", c.Name, err)
+		}
+		clients[i] = &clientEntry{info: c, creds: credentials(c, config), node: node}
+	}
+	// All good, log what changed
+	for _, c := range clients {
+		if reused[c] {
+			continue
+		}
+		if old == nil {
+			log.Info("Client configured", "name", c.info.Name)
+			continue
+		}
+		var prev *clientEntry
+		for _, e := range old {
+			if !reused[e] && len(e.info.Name) > 0 && e.info.Name == c.info.Name {
+				prev = e
+				break
+			}
+		}
+		if prev == nil {
+			log.Info("Client added", "name", c.info.Name, "kind", c.info.Kind)
+			continue
+		}
+		reused[prev] = true
+		log.Info("Client changed", "name", c.info.Name, "fields", strings.Join(changedFields(prev, c), ","))
+	}
+	for _, e := range old {
+		if !reused[e] {
+			log.Info("Client removed", "name", e.info.Name, "kind", e.info.Kind)
+		}
+	}
+	return clients, nil
+}
+
+// closeNewClients releases the nodes of the clients which are not in the old
+// client list, after a rejected config change.
+func closeNewClients(old, clients []*clientEntry) {
+	kept := make(map[*clientEntry]bool)
+	for _, e := range old {
+		kept[e] = true
+	}
+	for _, e := range clients {
+		if !kept[e] {
+			nodes.CloseNode(e.node)
+		}
+	}
+}
+
+// configWatcher reloads the config file when it is modified on disk, or when
+// the process receives a SIGHUP, and applies the changes to the monitors.
+type configWatcher struct {
+	path    string
+	config  *nodes.Config
+	chains  []*chainEntry
+	modTime time.Time
+
+	quitCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+func newConfigWatcher(path string, config *nodes.Config, chains []*chainEntry) *configWatcher {
+	w := &configWatcher{
+		path:   path,
+		config: config,
+		chains: chains,
+		quitCh: make(chan struct{}),
+	}
+	if fi, err := os.Stat(path); err == nil {
+		w.modTime = fi.ModTime()
+	}
+	return w
+}
+
+func (w *configWatcher) Start() {
+	w.wg.Add(1)
+	go w.loop()
+}
+
+func (w *configWatcher) Stop() {
+	close(w.quitCh)
+	w.wg.Wait()
+}
+
+func (w *configWatcher) loop() {
+	defer w.wg.Done()
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
+	defer signal.Stop(hupCh)
+	for {
+		select {
+		case <-w.quitCh:
+			return
+		case <-hupCh:
+			log.Info("Received SIGHUP, reloading config", "file", w.path)
+			w.reload()
+		case <-time.After(configPollInterval):
+			fi, err := os.Stat(w.path)
+			if err != nil || fi.ModTime().Equal(w.modTime) {
+				continue
+			}
+			w.modTime = fi.ModTime()
+			log.Info("Config file modified, reloading", "file", w.path)
+			w.reload()
+		}
+	}
+}
+
+// reload reads the config file and applies it. If the new config is invalid,
+// it is rejected and the previous config stays live.
+func (w *configWatcher) reload() {
+	config, err := loadConfig(w.path)
+	if err != nil {
+		log.Error("Rejected config change, keeping previous config", "error", err)
+		return
+	}
+	chainConfs, err := config.ChainConfigs()
+	if err != nil {
+		log.Error("Rejected config change, keeping previous config", "error", err)
+		return
+	}
+	// Chains can't be added or removed on the fly, the others are reconfigured
+	var (
+		confs   = make(map[string]nodes.ChainConfig)
+		known   = make(map[string]bool)
+		reloads = make([]time.Duration, len(w.chains))
+		clients = make([][]*clientEntry, len(w.chains))
+	)
+	for _, c := range chainConfs {
+		confs[c.ID()] = c
+	}
+	for i, chain := range w.chains {
+		known[chain.conf.ID()] = true
+		c, ok := confs[chain.conf.ID()]
+		if !ok {
+			log.Warn("Chain removed, restart required to take effect", "chain", chain.conf.Name)
+			continue
+		}
+		if reloads[i], err = time.ParseDuration(c.ReloadInterval); err == nil {
+			clients[i], err = reconcileClients(chain.clients, c.Clients, config, chain.factory)
+		}
+		if err != nil {
+			// Release the nodes created for the chains so far
+			for j := 0; j < i; j++ {
+				closeNewClients(w.chains[j].clients, clients[j])
+			}
+			log.Error("Rejected config change, keeping previous config", "chain", c.Name, "error", err)
+			return
+		}
+	}
+	for _, c := range chainConfs {
+		if !known[c.ID()] {
+			log.Warn("Chain added, restart required to take effect", "chain", c.Name)
+		}
+	}
+	if w.config.ServerAddress != config.ServerAddress {
+		log.Warn("Server address changed, restart required to take effect", "address", w.config.ServerAddress)
+	}
+	if w.config.Metrics != config.Metrics {
+		log.Warn("Metrics config changed, restart required to take effect")
+	}
+	if w.config.Storage != config.Storage {
+		log.Warn("Storage config changed, restart required to take effect")
+	}
+	if !reflect.DeepEqual(w.config.Alerts, config.Alerts) {
+		log.Warn("Alerts config changed, restart required to take effect")
+	}
+	if w.config.VulnFile != config.VulnFile || !reflect.DeepEqual(w.config.Vulns, config.Vulns) {
+		if err := nodes.ConfigureVulnFeeds(config); err != nil {
+			log.Error("Rejected vulnerability feeds change, keeping previous feeds", "error", err)
+			config.VulnFile, config.Vulns = w.config.VulnFile, w.config.Vulns
+		} else {
+			log.Info("Vulnerability feeds changed")
+		}
+	}
+	for i, chain := range w.chains {
+		if clients[i] == nil {
+			continue // removed from the config
+		}
+		c := confs[chain.conf.ID()]
+		if chain.conf.ReloadInterval != c.ReloadInterval {
+			log.Info("Reload interval changed", "chain", c.Name, "old", chain.conf.ReloadInterval, "new", c.ReloadInterval)
+		}
+		if chain.conf.LagThreshold != c.LagThreshold {
+			log.Info("Lag threshold changed", "chain", c.Name, "old", chain.conf.LagThreshold, "new", c.LagThreshold)
+			chain.mon.SetLagThreshold(c.LagThreshold)
+		}
+		if chain.conf.BlockTime != c.BlockTime || chain.conf.StallThreshold != c.StallThreshold {
+			log.Info("Stall threshold changed", "chain", c.Name, "blocktime", c.BlockTime, "threshold", c.StallThreshold)
+			blockTime, threshold, _ := c.StallSettings() // validated by ChainConfigs
+			chain.mon.SetStallThreshold(blockTime, threshold)
+		}
+		if votes := c.Votes(); !reflect.DeepEqual(chain.conf.Votes(), votes) {
+			log.Info("Client weights changed", "chain", c.Name)
+			chain.mon.SetVotes(votes)
+		}
+		if chain.conf.Name != c.Name {
+			log.Info("Chain name changed", "old", chain.conf.Name, "new", c.Name)
+		}
+		if chain.conf.Datadir != c.Datadir {
+			log.Warn("Chain datadir changed, restart required to take effect", "chain", c.Name, "datadir", chain.conf.Datadir)
+			c.Datadir = chain.conf.Datadir
+		}
+		chain.mon.Reconfigure(clientNodes(clients[i]), reloads[i], c.Name)
+		chain.conf, chain.clients = c, clients[i]
+	}
+	w.config = config
+}
@@ -44,6 +44,36 @@ let utils = {
         x.append(target)
         return x
     },
+    // sparkline draws the average latency of the given request buckets as a
+    // line, with the share of failed requests as bars below it
+    sparkline: function(buckets){
+        let width = 90, height = 20, ns = "http://www.w3.org/2000/svg"
+        let svg = document.createElementNS(ns, "svg")
+        svg.setAttribute("width", width)
+        svg.setAttribute("height", height)
+        svg.classList.add("sparkline")
+        let max = Math.max(...buckets.map(b => b.Latency), 1)
+        let step = width / Math.max(buckets.length-1, 1)
+        let points = buckets.map(function(b, i){
+            return (i*step).toFixed(1) + "," + (height - 1 - b.Latency/max*(height-2)).toFixed(1)
+        })
+        buckets.forEach(function(b, i){
+            if (b.Errors == 0){
+                return
+            }
+            let bar = document.createElementNS(ns, "rect")
+            let h = Math.max(b.Errors/b.Requests*height, 2)
+            bar.setAttribute("x", Math.max(i*step-1, 0))
+            bar.setAttribute("y", height-h)
+            bar.setAttribute("width", 2)
+            bar.setAttribute("height", h)
+            svg.append(bar)
+        })
+        let line = document.createElementNS(ns, "polyline")
+        line.setAttribute("points", points.join(" "))
+        svg.append(line)
+        return svg
+    },
 }
 
 // little fifo to store hash->data mappings
@@ -87,8 +117,9 @@ let humanFriendly = {
     difficulty: (val) => parseInt(val, 16),
     hash: utils.etherscanLink,
     parentHash: utils.etherscanLink,
-    timeDelta: function(date) {
-        date = Math.abs(new Date() - date)
+    // timeDelta returns the time between 'date' and 'since' (or now, if unset)
+    timeDelta: function(date, since) {
+        date = Math.abs((since || new Date()) - date)
         let seconds = Math.floor(date / 1000);
         let interval = seconds / 31536000;
         if (interval > 1) {
@@ -130,17 +161,105 @@ let humanFriendly = {
 
 $(document).ready(function() {
     fetch()
+    fetchChains()
 });
 
+// fetchChains retrieves the monitored chains, and if there are several, shows
+// a selector linking to the dashboard of each.
+function fetchChains(){
+    // Chain dashboards are served under chains/<id>/, the first one also at the root
+    let match = window.location.pathname.match(/chains\/([^\/]+)\/[^\/]*$/)
+    let root = match ? "../../" : ""
+    $.ajax(root + "api/v1/chains", {
+        success: function(chains){
+            if (chains.length < 2){
+                return
+            }
+            let list = $("#chain-list")
+            list.empty()
+            chains.forEach(function(chain, i){
+                let current = match ? chain.id == match[1] : i == 0
+                let a = utils.tag("a", chain.name || chain.id, "dropdown-item")
+                a.href = root + chain.path
+                if (current){
+                    a.classList.add("active")
+                    $("#chain-current").text(chain.name || chain.id)
+                }
+                let li = utils.tag("li")
+                li.append(a)
+                list.append(li)
+            })
+            $("#chain-selector").removeClass("d-none")
+        },
+        cache: false,
+    })
+}
+
 function fetch(){
     // Retrieve the list of files
-    $.ajax("data.json", {
+    $.ajax("api/v1/report", {
         success: onData,
         failure: function(status, err){ alert(err); },
         cache: false,
     })
 }
 
+// fetchHistory retrieves the latest reorgs and splits, and shows them as a timeline
+function fetchHistory(){
+    $.ajax("api/v1/history?limit=50", {
+        success: populateHistory,
+        cache: false,
+    })
+}
+
+function populateHistory(events){
+    var historyB = $("#history tbody")
+    historyB.empty()
+    events.forEach(function(ev) {
+        let tRow = utils.tag("tr")
+        let when = new Date(ev.time)
+        let td = utils.tag("td", humanFriendly.timeDelta(when) + " ago")
+        td.title = when.toLocaleString()
+        tRow.append(td)
+        tRow.append(utils.tag("td", ev.kind))
+        tRow.append(utils.tag("td", ev.nodes.join(", ")))
+        tRow.append(utils.tag("td", ev.number))
+        let details = ""
+        if (ev.kind == "reorg"){
+            details = ev.depth + " block(s) replaced, " + utils.shortHash(ev.oldHash) + " → " + utils.shortHash(ev.newHash)
+        }else if (ev.resolved){
+            let resolved = new Date(ev.resolved)
+            details = ev.depth + " block(s) deep, resolved after " + humanFriendly.timeDelta(when, resolved)
+        }else{
+            details = ev.depth + " block(s) deep, ongoing"
+            $(tRow).addClass("table-warning")
+        }
+        tRow.append(utils.tag("td", details))
+        historyB.append(tRow)
+    })
+}
+
+// subscribe listens for the reports pushed by the server, and shows the state
+// of the connection in 'status'. It returns null if the browser does not
+// support server-sent events.
+function subscribe(status){
+    if (!window.EventSource){
+        return null
+    }
+    let source = new EventSource("api/v1/stream")
+    source.addEventListener("report", function(e){
+        onData(JSON.parse(e.data))
+    })
+    source.onopen = function(){
+        status.text("live")
+    }
+    source.onerror = function(){
+        // The browser reconnects by itself
+        status.text("reconnecting")
+    }
+    return source
+}
+
 // for debugging
 function progress(message){
     console.log(message)
@@ -154,12 +273,89 @@ function onData(data){
     if (data["Chain"]){
         $("title").text(data["Chain"])
     }
+    populateChain(data, "#nodes", "#table", showblock)
+    // Consensus-layer nodes, if any
+    if (data.Beacon){
+        $("#beacon").show()
+        populateChain(data.Beacon, "#beacon-nodes", "#beacon-table", function(root){
+            populateBlockInfo({"root": root})
+        }, true)
+    }else{
+        $("#beacon").hide()
+    }
+
+    // Populate active alerts
+    var alertsB = $("#alerts tbody")
+    alertsB.empty()
+    let alerts = data.Alerts || []
+    alerts.forEach(function(alert) {
+        let tRow = utils.tag("tr")
+        tRow.append(utils.tag("td", humanFriendly.timeDelta(new Date(alert.since)) + " ago"))
+        tRow.append(utils.tag("td", alert.kind))
+        if (alert.severity == "critical"){
+            $(tRow).addClass("table-danger")
+        }
+        tRow.append(utils.tag("td", alert.message))
+        alertsB.append(tRow)
+    })
+
+    // Populate the splits, with the header fields which differ
+    var splitsB = $("#splits tbody")
+    splitsB.empty()
+    let splits = data.Splits || []
+    splits.forEach(function(split) {
+        let tRow = utils.tag("tr")
+        tRow.append(utils.tag("td", split.nodes.join(" / ")))
+        tRow.append(utils.tag("td", split.number))
+        tRow.append(utils.tag("td", split.length))
+        let diff = "n/a (headers not available)"
+        if (split.summary){
+            diff = split.summary
+            if (split.divergedFields && split.divergedFields.length > 0){
+                diff += ": " + split.divergedFields.join(", ")
+            }
+        }
+        tRow.append(utils.tag("td", diff))
+        splitsB.append(tRow)
+    })
+
+    fetchHistory()
+
+    // Populate bad block info
+    var badblocksB = $("#badblocks tbody")
+    badblocksB.empty()
+    data.BadBlocks.forEach(function(badblock) {
+        let tRow = utils.tag("tr")
+        tRow.append(utils.tag("td", badblock.number))
+        tRow.append(utils.tag("td", utils.shortHash(badblock.hash)))
+        tRow.append(utils.tag("td", badblock.clients))
+        $(tRow).on('click', function(){
+            showBadBlock( badblock.hash)
+        })
+        badblocksB.append(tRow)
+    })
+}
+
+// formatBlock formats a finalized or safe block, as the epoch for beacon nodes.
+function formatBlock(block, beacon){
+    if (!block){
+        return "n/a"
+    }
+    if (beacon){
+        return "epoch " + Math.floor(block.Number/32) + " (" + utils.shortHash(block.Hash) + ")"
+    }
+    return block.Number + " (" + utils.shortHash(block.Hash) + ")"
+}
+
+// populateChain fills the nodes table and the chain table with the given
+// (sub-)report. Clicking a hash invokes 'onClick'.
+function populateChain(data, nodesTable, chainTable, onClick, beacon){
     // Populate node info
-    var nodeB = $("#nodes tbody")
+    var nodeB = $(nodesTable+" tbody")
     nodeB.empty()
 
     // Clear headings
-    var thead = $("#table thead")
+    var thead = $(chainTable+" thead")
     thead.empty()
 
     thead.append(utils.slantedHeading("Number"))
@@ -167,25 +363,78 @@ function onData(data){
     data.Cols.forEach(function(client) {
         let name = client.Name
         let version = client.Version
-        let status = "OK"
+        let status = client.StatusName || "ok"
+        let peers = client.Peers >= 0 ? client.Peers : "n/a"
         let progress = "Never"
         let badblocks = "0"
         let vulnerabilites = client.Vulnerabilities || []
         if (client.LastProgress > 0){
             progress = humanFriendly.timeDelta(new Date(client.LastProgress*1000)) + " ago"
         }
-        if (client.Status != 0) {
-            status = " (unhealthy)"
-        }
         if (client.BadBlocks > 0) {
             badblocks = client.BadBlocks
         }
         let tRow = utils.tag("tr")
-        tRow.append(utils.tag("td", name))
-        tRow.append(utils.tag("td", version))
-        tRow.append(utils.tag("td", status))
+        let nameTd = utils.tag("td", name)
+        if (client.Minority){
+            $(nameTd).addClass("table-danger")
+            nameTd.title = "On a minority fork"
+        }
+        tRow.append(nameTd)
+        let versionTd = utils.tag("td", version)
+        if (client.Client){
+            let c = client.Client
+            versionTd.title = [c.Name + " " + c.Version, c.Tag, c.Commit, c.OS, c.Runtime].filter(x => x).join(", ")
+        }
+        tRow.append(versionTd)
+        let statusTd = utils.tag("td", status)
+        if (client.Error){
+            statusTd.title = client.Error
+        }
+        // Unreachable or on the wrong chain
+        if (client.Status == 1 || client.Status == 6){
+            $(statusTd).addClass("table-danger")
+        } else if (client.Status != 0){
+            $(statusTd).addClass("table-warning")
+        }
+        tRow.append(statusTd)
+        tRow.append(utils.tag("td", peers))
         tRow.append(utils.tag("td", progress))
-        tRow.append(utils.tag("td", badblocks))
+        let lagTd = utils.tag("td", "n/a")
+        if (client.Lag){
+            lagTd.innerText = client.Lag.Blocks + (beacon ? " slots" : " blocks")
+            if (client.Lag.Blocks > 0){
+                lagTd.title = "Behind for " + humanFriendly.timeDelta(new Date(client.Lag.Seconds*1000), new Date(0))
+            }
+        }
+        if (client.Status == 5){
+            $(lagTd).addClass("table-warning")
+        }
+        tRow.append(lagTd)
+        let finalizedTd = utils.tag("td", formatBlock(client.Finalized, beacon))
+        if (client.FinalizedMismatch){
+            $(finalizedTd).addClass("table-danger")
+            finalizedTd.title = "Disagrees with the majority of nodes"
+        }
+        tRow.append(finalizedTd)
+        tRow.append(utils.tag("td", formatBlock(client.Safe, beacon)))
+        if (!beacon){
+            tRow.append(utils.tag("td", badblocks))
+            let requestsTd = utils.tag("td")
+            let buckets = client.Requests || []
+            if (buckets.length > 0){
+                let requests = 0, errors = 0
+                buckets.forEach(function(b){ requests += b.Requests; errors += b.Errors })
+                let last = buckets[buckets.length-1]
+                requestsTd.append(utils.sparkline(buckets))
+                requestsTd.title = requests + " requests, " + errors + " failed in the last " +
+                    buckets.length + " minute(s), " + last.Latency.toFixed(0) + " ms on average in the last one"
+                if (errors > 0){
+                    $(requestsTd).addClass("table-warning")
+                }
+            }
+            tRow.append(requestsTd)
+        }
         let vulnTd = utils.tag("td");
         vulnerabilites.forEach(element => {
             let warn = utils.tag("span","", "glyphicon")
@@ -194,14 +443,22 @@ function onData(data){
             $(warn).on('click', function(){showVulnerability(element)})
         });
         tRow.append(vulnTd)
-        
+
         nodeB.append(tRow)
         // Add td headings
         thead.append(utils.slantedHeading(name))
     })
     // Clear rows
-    var tbody = $("#table tbody")
+    var tbody = $(chainTable+" tbody")
     tbody.empty()
+    // The canonical hash at each number, by weighted majority
+    var canonical = {}
+    var consensus = data.Consensus || []
+    consensus.forEach(function(c){
+        if (c.Hash != "0x0000000000000000000000000000000000000000000000000000000000000000"){
+            canonical[c.Number] = c.Hash
+        }
+    })
     // Add rows
     data.Numbers.forEach(function(number) {
         number = ""+number
@@ -214,7 +471,11 @@ function onData(data){
             var td = utils.tag("td",hl)
             row.append(td)
             if (data.length == 0){ return }
-            $(td).on('click', function(){showblock(data)})
+            $(td).on('click', function(){onClick(data)})
+            if (canonical[number] && canonical[number] != data){
+                $(td).addClass("table-warning")
+                td.title = "Not the canonical hash"
+            }
             // Count how many even have this
             count = count+1
             if (rowData ==""){
@@ -232,27 +493,14 @@ function onData(data){
         }
         tbody.append(row)
     })
-
-    // Populate bad block info
-    var badblocksB = $("#badblocks tbody")
-    badblocksB.empty()
-    data.BadBlocks.forEach(function(badblock) {
-        let tRow = utils.tag("tr")
-        tRow.append(utils.tag("td", badblock.number))
-        tRow.append(utils.tag("td", utils.shortHash(badblock.hash)))
-        tRow.append(utils.tag("td", badblock.clients))
-        $(tRow).on('click', function(){
-            showBadBlock( badblock.hash)
-        })
-        badblocksB.append(tRow)
-    })
 }
 
 function showBadBlock( hash){
-    $.ajax("badblocks/"+hash+".json", {
+    $.ajax("api/v1/badblocks/"+hash, {
         dataType: "json",
         success: function(data){
             populateBlockInfo(data)
+            showTriage(hash)
         },
         error: function(status, err){
             populateBlockInfo({"hash": hash})
@@ -261,8 +509,57 @@ function showBadBlock( hash){
     })
 }
 
+// showTriage appends the outcome of tracing the bad block to the block info,
+// pointing at the first diverging step of each transaction.
+function showTriage(hash){
+    $.ajax("api/v1/triage/"+hash, {
+        dataType: "json",
+        success: function(data){
+            let tbody = $("#block tbody")
+            let addRow = function(key, value, cls){
+                let row = utils.tag("tr", "", cls)
+                row.append(utils.tag("td", key))
+                let v = utils.tag("td")
+                v.append(value)
+                row.append(v)
+                tbody.append(row)
+            }
+            let traces = utils.tag("span")
+            let link = function(kind){
+                let a = utils.tag("a", kind + " trace")
+                a.href = "api/v1/triage/" + hash + "/" + kind
+                return a
+            }
+            traces.append(link("bad"))
+            if (data.acceptedBy){
+                traces.append(" / ")
+                traces.append(link("good"))
+            }
+            addRow("triage", traces)
+            addRow("rejected by", data.client)
+            if (data.acceptedBy){
+                addRow("accepted by", data.acceptedBy)
+            }
+            if (data.error){
+                addRow("triage error", data.error, "table-warning")
+            }
+            data.diffs.forEach(function(diff){
+                let where = "tx " + diff.tx
+                if (diff.step >= 0){
+                    let op = diff.bad || diff.good
+                    where += ", step " + diff.step + " (" + op.op + " at pc " + op.pc + ")"
+                }
+                addRow(where, diff.reason, "table-danger")
+            })
+        },
+        error: function(status, err){
+            // Not triaged (yet)
+        },
+    })
+}
+
 function showVulnerability(vuln) {
-    $.ajax("vulns/"+vuln+".json", {
+    $.ajax("api/v1/vulns/"+vuln, {
         dataType: "json",
         success: function(data){
 
@@ -329,7 +626,7 @@ function showblock(hash){
     if (data){
         populateBlockInfo(data)
     }else{
-        $.ajax("hashes/"+hash+".json", {
+        $.ajax("api/v1/headers/"+hash, {
             dataType: "json",
             success: function(data){
                 miniFIFO.store(hash, data)